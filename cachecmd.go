@@ -0,0 +1,172 @@
+// cachecmd.go - the "epublatex cache" command
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/seehuhn/epublatex/latex/cache"
+)
+
+const cacheUsage = `usage: epublatex cache <command> [maths|tikz]...
+
+commands:
+  list          list all cached images
+  stats         show the number and total size of cached images
+  verify        check all cached images and remove damaged ones
+  prune [size]  reduce each cache to the given size (default: -cache-limit)
+  clear         remove all cached images`
+
+// cacheDirs lists the caches used by the maths and TikZ renderers.
+var cacheDirs = []struct {
+	name, subdir, ext string
+}{
+	{"maths", "maths", ".png"},
+	{"maths", "maths-svg", ".svg"},
+	{"tikz", "tikz", ".png"},
+	{"tikz", "tikz-svg", ".svg"},
+}
+
+// cacheCommand implements "epublatex cache ...".  The argument args
+// gives the command line arguments following "cache".
+func cacheCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", cacheUsage)
+	}
+	cmd := args[0]
+	args = args[1:]
+	switch cmd {
+	case "list", "stats", "verify", "prune", "clear":
+		// pass
+	default:
+		return fmt.Errorf("unknown command %q\n%s", cmd, cacheUsage)
+	}
+
+	limit := cache.PruneLimit()
+	if cmd == "prune" && len(args) > 0 && args[0] != "maths" && args[0] != "tikz" {
+		var size cache.ByteSize
+		err := size.Set(args[0])
+		if err != nil {
+			return err
+		}
+		limit = int64(size)
+		args = args[1:]
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range args {
+		if name != "maths" && name != "tikz" {
+			return fmt.Errorf("unknown cache %q\n%s", name, cacheUsage)
+		}
+		selected[name] = true
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	defer out.Flush()
+	for _, cd := range cacheDirs {
+		if len(selected) > 0 && !selected[cd.name] {
+			continue
+		}
+		c, err := cache.NewDataCache(cd.subdir, cd.ext)
+		if err != nil {
+			return err
+		}
+
+		switch cmd {
+		case "list":
+			err = cacheList(out, cd.subdir, c)
+		case "stats":
+			err = cacheStats(out, cd.subdir, c)
+		case "verify":
+			err = cacheVerify(out, cd.subdir, c)
+		case "prune":
+			err = c.Close(limit)
+		case "clear":
+			err = c.Close(-1)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cacheList(out *tabwriter.Writer, name string, c *cache.Cache) error {
+	all, err := c.List()
+	if err != nil {
+		return err
+	}
+	for _, e := range all {
+		if !e.Valid {
+			fmt.Fprintf(out, "%s/%s\t%s\t%s\t(invalid)\n",
+				name, e.Hash, cache.ByteSize(e.Size),
+				e.LastUsed.Format("2006-01-02 15:04"))
+			continue
+		}
+		res := "-"
+		if e.Resolution > 0 {
+			res = fmt.Sprintf("%ddpi", e.Resolution)
+		}
+		fmt.Fprintf(out, "%s/%s\t%s\t%s\t%s\t%s\t%s\n",
+			name, e.Hash, cache.ByteSize(e.Size),
+			e.LastUsed.Format("2006-01-02 15:04"),
+			res, e.Engine, shortKey(e.Key))
+	}
+	return nil
+}
+
+func cacheStats(out *tabwriter.Writer, name string, c *cache.Cache) error {
+	all, err := c.List()
+	if err != nil {
+		return err
+	}
+	var valid, invalid int
+	var total int64
+	for _, e := range all {
+		if e.Valid {
+			valid++
+		} else {
+			invalid++
+		}
+		total += e.Size
+	}
+	fmt.Fprintf(out, "%s\t%d images\t%s\t%d invalid\t%s\n",
+		name, valid, cache.ByteSize(total), invalid, c.Dir())
+	return nil
+}
+
+func cacheVerify(out *tabwriter.Writer, name string, c *cache.Cache) error {
+	bad, err := c.Verify()
+	for _, e := range bad {
+		fmt.Fprintf(out, "%s/%s\tremoved\n", name, e.Hash)
+	}
+	return err
+}
+
+// shortKey abbreviates a cache key for display on a single line.
+// The end of the key is kept, since this is where the renderers
+// store the formula or picture.
+func shortKey(key string) string {
+	runes := []rune(strings.Join(strings.Fields(key), " "))
+	if len(runes) > 70 {
+		runes = append([]rune("..."), runes[len(runes)-67:]...)
+	}
+	return string(runes)
+}
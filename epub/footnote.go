@@ -0,0 +1,61 @@
+// footnote.go - collect footnotes for the current chapter file
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package epub
+
+import "strconv"
+
+// Footnote describes a footnote which is waiting to be written at the
+// end of the current chapter file.
+type Footnote struct {
+	ID    string
+	RefID string
+	Label string
+	Body  string
+}
+
+// AddFootnote registers a footnote for the current chapter file and
+// returns the HTML code for the footnote marker.  The label and the
+// footnote text `body` must be valid HTML.  The footnote text is
+// written when the chapter file is closed.
+func (w *Book) AddFootnote(label, body string) (string, error) {
+	if !w.open {
+		return "", ErrBookClosed
+	}
+
+	n := strconv.Itoa(len(w.footnotes) + 1)
+	note := &Footnote{
+		ID:    "epub-fn" + n,
+		RefID: "epub-fnref" + n,
+		Label: label,
+		Body:  body,
+	}
+	w.footnotes = append(w.footnotes, note)
+
+	return w.renderTemplates(
+		[]string{"noteref.xhtml", w.driver.Config()}, note)
+}
+
+// writeFootnotes writes all collected footnotes to the current file.
+func (w *Book) writeFootnotes() error {
+	if len(w.footnotes) == 0 {
+		return nil
+	}
+	err := w.writeTemplates(
+		[]string{"footnotes.xhtml", w.driver.Config()}, w.footnotes)
+	w.footnotes = nil
+	return err
+}
@@ -0,0 +1,74 @@
+// footnote_test.go - unit tests for footnote.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package epub
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFootnotes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epubtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewXhtmlWriter(dir, "epubtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.AddSection(1, "Chapter", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := w.AddFootnote("1", "note text")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<a class="epub-noteref" id="epub-fnref1" href="#epub-fn1"><sup>1</sup></a>`
+	if ref != expected {
+		t.Errorf("wrong marker %q, expected %q", ref, expected)
+	}
+	err = w.WriteString("<p>text" + ref + "</p>\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.AddSection(1, "Next", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(w.footnotes) != 0 {
+		t.Error("footnotes not written at end of chapter")
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, err := ioutil.ReadFile(filepath.Join(dir, "ch1.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := `<p id="epub-fn1"><a href="#epub-fnref1">1</a> note text</p>`
+	if !strings.Contains(string(body), note) {
+		t.Errorf("footnote missing from chapter:\n%s", body)
+	}
+}
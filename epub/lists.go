@@ -0,0 +1,52 @@
+// lists.go - pages listing the figures or tables of a book
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package epub
+
+// ListEntry is one entry on a page created by AddList.  Label and
+// Title must be valid HTML.  The entry links to the element with the
+// given ID in the file Path.
+type ListEntry struct {
+	Label string
+	Title string
+	Path  string
+	ID    string
+}
+
+// AddList adds a page with a list of links, for example a list of
+// figures, to the spine.  Text written after the list is placed in a
+// new file, see ContentPath.  The argument `listType` gives the EPUB
+// structural semantics of the list, for example "lof" for a list of
+// figures or "lot" for a list of tables.
+func (w *Book) AddList(title, listType string, entries []ListEntry) error {
+	if !w.open {
+		return ErrBookClosed
+	}
+
+	err := w.closeSections(0)
+	if err != nil {
+		return err
+	}
+	w.part++
+	file := w.RegisterFile(listType, "application/xhtml+xml", true)
+	return w.addBookFileFromTemplate(file,
+		[]string{"list.xhtml", w.driver.Config()},
+		map[string]interface{}{
+			"Title":   title,
+			"Type":    listType,
+			"Entries": entries,
+		})
+}
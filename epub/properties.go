@@ -0,0 +1,66 @@
+// properties.go - manifest properties of the book files
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package epub
+
+import (
+	"bytes"
+	"io"
+)
+
+// AddProperty adds a property, like "nav" or "mathml", to the
+// manifest entry of the file.
+func (file *File) AddProperty(name string) {
+	if !file.HasProperty(name) {
+		file.Properties = append(file.Properties, name)
+	}
+}
+
+// HasProperty checks whether the file has the given manifest property.
+func (file *File) HasProperty(name string) bool {
+	for _, prop := range file.Properties {
+		if prop == name {
+			return true
+		}
+	}
+	return false
+}
+
+var mathTag = []byte("<math")
+
+// propertyWriter inspects the data written to an XHTML file, in order
+// to set the "mathml" manifest property when the file contains
+// MathML markup.
+type propertyWriter struct {
+	io.WriteCloser
+	file *File
+	tail []byte
+}
+
+func (w *propertyWriter) Write(p []byte) (int, error) {
+	if !w.file.HasProperty("mathml") {
+		buf := append(w.tail, p...)
+		if bytes.Contains(buf, mathTag) {
+			w.file.AddProperty("mathml")
+		}
+		// keep enough bytes to find a tag split across two writes
+		if len(buf) >= len(mathTag) {
+			buf = buf[len(buf)-len(mathTag)+1:]
+		}
+		w.tail = append([]byte(nil), buf...)
+	}
+	return w.WriteCloser.Write(p)
+}
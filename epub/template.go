@@ -18,6 +18,8 @@
 package epub
 
 import (
+	"bytes"
+	"io"
 	"path"
 	"strings"
 	"text/template"
@@ -47,6 +49,7 @@ func templateFormatList(list []string) string {
 
 var templateFunctions = template.FuncMap{
 	"formatlist": templateFormatList,
+	"join":       strings.Join,
 }
 
 func loadTemplates(names []string) (*template.Template, error) {
@@ -76,17 +79,29 @@ func loadTemplates(names []string) (*template.Template, error) {
 	return res, nil
 }
 
-func (w *Book) writeTemplates(tmplFiles []string, data interface{}) error {
+func (w *Book) executeTemplates(out io.Writer, tmplFiles []string,
+	data interface{}) error {
 	tmpl, err := loadTemplates(tmplFiles)
 	if err != nil {
 		return err
 	}
-	return tmpl.Execute(w.current, map[string]interface{}{
+	return tmpl.Execute(out, map[string]interface{}{
 		"This": data,
 		"Book": w,
 	})
 }
 
+func (w *Book) writeTemplates(tmplFiles []string, data interface{}) error {
+	return w.executeTemplates(w.current, tmplFiles, data)
+}
+
+func (w *Book) renderTemplates(tmplFiles []string, data interface{}) (
+	string, error) {
+	buf := &bytes.Buffer{}
+	err := w.executeTemplates(buf, tmplFiles, data)
+	return buf.String(), err
+}
+
 func (w *Book) addFileFromTemplate(path string, tmplFiles []string,
 	data interface{}) error {
 	err := w.createFile(path)
@@ -99,3 +114,18 @@ func (w *Book) addFileFromTemplate(path string, tmplFiles []string,
 	}
 	return w.closeFile()
 }
+
+// addBookFileFromTemplate writes the contents of a file listed in the
+// manifest, using the given templates.
+func (w *Book) addBookFileFromTemplate(file *File, tmplFiles []string,
+	data interface{}) error {
+	err := w.openFile(file)
+	if err != nil {
+		return err
+	}
+	err = w.writeTemplates(tmplFiles, data)
+	if err != nil {
+		return err
+	}
+	return w.closeFile()
+}
@@ -3,17 +3,20 @@
 package epub
 
 var templateFiles = map[string]string {
-	"book.css": "@namespace epub \"http://www.idpf.org/2007/ops\";\n\nbody {\n    margin: 1in auto;\n    max-width: 32em;\n    text-align: justify;\n    -webkit-hyphens: auto;\n    -ms-hyphens: auto;\n    hyphens: auto;\n}\nh1, h2, h3, h4, h5, h6 {\n    text-align: left;\n}\n\n#cover-image {\n    margin: 0;\n    border: none;\n    padding: 0;\n    max-width: 100%;\n}\n\n.epub-secno {\n    margin-right: 1em;\n}\n\n.error {\n    text-decoration: line-through;\n}\n\n.imath {\n    display: inline-block;\n    margin: 0;\n    padding: 0;\n    vertical-align: middle;\n    height: auto;\n}\n.dmath {\n    display: block;\n    margin: 3ex auto;\n    padding: 0;\n    height: auto;\n}\n\n.latex-nw {\n    white-space: nowrap;\n}\n.latex-block {\n    margin: 1ex 0;\n}\n.latex-eqno {\n    float: right;\n    padding-top: 1.5ex;\n}\n.latex-verb {\n    font-family: monospace;\n    white-space: pre;\n}\n.latex-verbatim {\n    margin: 4ex 0;\n}\n",
+	"book.css": "@namespace epub \"http://www.idpf.org/2007/ops\";\n\nbody {\n    margin: 1in auto;\n    max-width: 32em;\n    text-align: justify;\n    -webkit-hyphens: auto;\n    -ms-hyphens: auto;\n    hyphens: auto;\n}\nh1, h2, h3, h4, h5, h6 {\n    text-align: left;\n}\n\n#cover-image {\n    margin: 0;\n    border: none;\n    padding: 0;\n    max-width: 100%;\n}\n\n.epub-secno {\n    margin-right: 1em;\n}\n.epub-pagebreak {\n    page-break-before: always;\n    break-before: page;\n}\n\n.error {\n    text-decoration: line-through;\n}\n.latex-texerror {\n    color: #a00;\n    white-space: pre-wrap;\n}\n\n.imath {\n    display: inline-block;\n    margin: 0;\n    padding: 0;\n    vertical-align: baseline;\n    height: auto;\n}\n.dmath {\n    display: block;\n    margin: 3ex auto;\n    padding: 0;\n    height: auto;\n}\n\n.latex-nw {\n    white-space: nowrap;\n}\n.latex-block {\n    margin: 1ex 0;\n}\n.latex-eqno {\n    float: right;\n    padding-top: 1.5ex;\n}\nmath[display=\"block\"] {\n    margin: 1ex 0;\n}\n.latex-verb {\n    font-family: monospace;\n    white-space: pre;\n}\n.latex-verbatim {\n    margin: 4ex 0;\n}\nol.latex-enumerate {\n    list-style-type: none;\n}\n.latex-label {\n    margin-left: -2em;\n    display: inline-block;\n    min-width: 2em;\n}\ndl.latex-description dt {\n    font-weight: bold;\n}\n.epub-noteref {\n    text-decoration: none;\n}\n.epub-footnotes, aside.epub-footnote {\n    margin-top: 4ex;\n    font-size: smaller;\n}\ntable.latex-tabular, table.latex-tabularx, table.latex-array {\n    margin: 2ex auto;\n    border-collapse: collapse;\n}\ntable.latex-tabularx {\n    width: 100%;\n}\n.latex-tabular td, .latex-tabular th,\n.latex-tabularx td, .latex-tabularx th,\n.latex-array td, .latex-array th {\n    padding: 0.2ex 0.5em;\n    vertical-align: top;\n}\n.latex-align-left {\n    text-align: left;\n}\n.latex-align-center {\n    text-align: center;\n}\n.latex-align-right {\n    text-align: right;\n}\n.latex-align-justify {\n    text-align: justify;\n}\n.latex-vrule-left {\n    border-left: 1px solid;\n}\n.latex-vrule-right {\n    border-right: 1px solid;\n}\n.latex-rule-above {\n    border-top: 1px solid;\n}\n.latex-rule-below {\n    border-bottom: 1px solid;\n}\n.latex-thickrule-above {\n    border-top: 2px solid;\n}\n.latex-thickrule-below {\n    border-bottom: 2px solid;\n}\nol.epub-list {\n    list-style-type: none;\n    padding-left: 0;\n}\n.epub-list-label {\n    display: inline-block;\n    min-width: 3em;\n}\nfigure.latex-figure, figure.latex-table {\n    margin: 3ex 0;\n    text-align: center;\n}\nfigcaption {\n    margin: 1ex 2em;\n    text-align: left;\n}\n.latex-caption-label {\n    font-weight: bold;\n}\nimg.includegraphics {\n    max-width: 100%;\n}\n",
 	"chapter-head.xhtml": "{{define \"title\" -}}\n<title>{{.This.Title}}</title>\n{{end -}}\n\n{{template \"xhtml-head\" . -}}\n",
 	"chapter-tail.xhtml": "{{template \"xhtml-tail\" -}}\n",
-	"config/epub": "{{define \"xml-decl\"}}<?xml version=\"1.0\" encoding=\"utf-8\"?>\n{{end -}}\n{{define \"xmlns-epub\"}} xmlns:epub=\"http://www.idpf.org/2007/ops\"{{end -}}\n{{define \"xhtml-lang\" -}}\n  {{with .Book.Language}} xml:lang=\"{{.}}\" lang=\"{{.}}\"{{end}}{{end -}}\n{{define \"stylesheets\" -}}\n  <link rel=\"stylesheet\" type=\"text/css\" href=\"{{.Book.CSSPath}}\"/>\n{{end -}}\n{{define \"epub:type\"}} epub:type=\"{{.}}\"{{end -}}\n",
+	"config/epub": "{{define \"xml-decl\"}}<?xml version=\"1.0\" encoding=\"utf-8\"?>\n{{end -}}\n{{define \"xmlns-epub\"}} xmlns:epub=\"http://www.idpf.org/2007/ops\"{{end -}}\n{{define \"xhtml-lang\" -}}\n  {{with .Book.Language}} xml:lang=\"{{.}}\" lang=\"{{.}}\"{{end}}{{end -}}\n{{define \"stylesheets\" -}}\n  <link rel=\"stylesheet\" type=\"text/css\" href=\"{{.Book.CSSPath}}\"/>\n{{end -}}\n{{define \"epub:type\"}} epub:type=\"{{.}}\"{{end -}}\n{{define \"footnotes\" -}}\n{{range . -}}\n<aside epub:type=\"footnote\" class=\"epub-footnote\" id=\"{{.ID}}\">\n<p><a href=\"#{{.RefID}}\">{{.Label}}</a> {{.Body}}</p>\n</aside>\n{{end -}}\n{{end -}}\n",
 	"config/xhtml": "{{define \"xhtml-lang\" -}}\n  {{with .Book.Language}} xml:lang=\"{{.}}\" lang=\"{{.}}\"{{end}}{{end -}}\n{{define \"stylesheets\" -}}\n  <link rel=\"stylesheet\" type=\"text/css\" href=\"{{.Book.CSSPath}}\"/>\n{{end -}}\n",
 	"container.xml": "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<container version=\"1.0\" xmlns=\"urn:oasis:names:tc:opendocument:xmlns:container\">\n  <rootfiles>\n    <rootfile full-path=\"{{.This.ContentName}}\" media-type=\"application/oebps-package+xml\"/>\n  </rootfiles>\n</container>\n",
-	"content.opf": "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<package xmlns=\"http://www.idpf.org/2007/opf\"\n\t version=\"3.0\"\n\t xml:lang=\"{{.Book.Language}}\"\n\t unique-identifier=\"pub-id\">\n  <metadata xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n    <dc:identifier id=\"pub-id\">urn:uuid:{{.Book.UUID}}</dc:identifier>\n    <dc:title>{{.Book.Title}}</dc:title>{{range .Book.Authors}}\n    <dc:creator>{{.}}</dc:creator>{{end}}\n    <dc:language>{{.Book.Language}}</dc:language>\n    <meta property=\"dcterms:modified\">{{.Book.LastModified}}</meta>\n  </metadata>\n  <manifest>{{range .Book.Files}}\n    <item id=\"{{.ID}}\" href=\"{{.Path}}\" media-type=\"{{.MediaType}}\"\n      {{- if eq .Path $.Book.NavPath}} properties=\"nav\"{{end -}}\n      {{- if eq .ID $.Book.CoverImageID}} properties=\"cover-image\"{{end -}}\n      />{{end}}\n  </manifest>\n  <spine>{{range .Book.Spine}}\n    <itemref idref=\"{{.ID}}\"\n      {{- if eq .ID $.Book.CoverID}} linear=\"no\"{{end -}}\n      />{{end}}\n  </spine>\n</package>\n",
+	"content.opf": "<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<package xmlns=\"http://www.idpf.org/2007/opf\"\n\t version=\"3.0\"\n\t xml:lang=\"{{.Book.Language}}\"\n\t unique-identifier=\"pub-id\">\n  <metadata xmlns:dc=\"http://purl.org/dc/elements/1.1/\">\n    <dc:identifier id=\"pub-id\">urn:uuid:{{.Book.UUID}}</dc:identifier>\n    <dc:title>{{.Book.Title}}</dc:title>{{range .Book.Authors}}\n    <dc:creator>{{.}}</dc:creator>{{end}}\n    <dc:language>{{.Book.Language}}</dc:language>\n    <meta property=\"dcterms:modified\">{{.Book.LastModified}}</meta>\n  </metadata>\n  <manifest>{{range .Book.Files}}\n    <item id=\"{{.ID}}\" href=\"{{.Path}}\" media-type=\"{{.MediaType}}\"\n      {{- with .Properties}} properties=\"{{join . \" \"}}\"{{end -}}\n      />{{end}}\n  </manifest>\n  <spine>{{range .Book.Spine}}\n    <itemref idref=\"{{.ID}}\"\n      {{- if eq .ID $.Book.CoverID}} linear=\"no\"{{end -}}\n      />{{end}}\n  </spine>\n</package>\n",
 	"cover.xhtml": "{{define \"title\" -}}\n<title>Cover</title>\n{{end -}}\n\n{{define \"body-attributes\"}} id=\"cover\"{{block \"epub:type\" \"cover\"}}{{end -}}\n{{end -}}\n\n{{define \"contents\" -}}\n<img id=\"cover-image\" alt=\"{{html .Book.Title}}\" src=\"{{html .This.CoverImage}}\"/>\n{{end -}}\n\n{{template \"xhtml\" . -}}\n",
+	"footnotes.xhtml": "{{block \"footnotes\" .This -}}\n<div class=\"epub-footnotes\">\n<hr/>\n{{range . -}}\n<p id=\"{{.ID}}\"><a href=\"#{{.RefID}}\">{{.Label}}</a> {{.Body}}</p>\n{{end -}}\n</div>\n{{end -}}\n",
 	"front-head.xhtml": "{{template \"xhtml-head\" . -}}\n",
 	"front-tail.xhtml": "{{template \"xhtml-tail\" -}}\n",
+	"list.xhtml": "{{define \"title\" -}}\n<title>{{.This.Title}}</title>\n{{end -}}\n\n{{define \"contents\" -}}\n<h1>{{.This.Title}}</h1>\n<nav{{block \"epub:type\" .This.Type}}{{end}}>\n<ol class=\"epub-list\">\n{{range .This.Entries -}}\n<li><a href=\"{{.Path}}#{{.ID}}\"><span class=\"epub-list-label\">{{.Label}}</span>\n{{.Title}}</a></li>\n{{end -}}\n</ol>\n</nav>\n{{end -}}\n\n{{template \"xhtml\" . -}}\n",
 	"nav.xhtml": "{{define \"title\" -}}\n<title>EPUB 3 Navigation Document</title>\n{{end -}}\n\n{{define \"contents\" -}}\n<h1>Table of Contents</h1>\n<nav {{block \"epub:type\" \"toc\"}}{{end}}>{{range $x := .Book.Nav -}}\n{{range $x.Up}}\n<ol>\n<li>{{else}}</li><li>{{end -}}\n<a href=\"{{$x.Path}}#{{$x.ID}}\">{{$x.Title}}</a>{{range $x.Down}}</li>\n</ol>\n{{end}}{{end -}}\n</nav>\n{{end -}}\n\n{{template \"xhtml\" . -}}\n",
+	"noteref.xhtml": "{{with .This -}}\n<a{{block \"epub:type\" \"noteref\"}}{{end}} class=\"epub-noteref\" id=\"{{.RefID}}\" href=\"#{{.ID}}\"><sup>{{.Label}}</sup></a>\n{{- end -}}\n",
 	"parts/xhtml": "{{template \"xhtml-head\" . -}}\n{{block \"contents\" .}}{{end -}}\n{{template \"xhtml-tail\" . -}}\n",
 	"parts/xhtml-head": "{{block \"xml-decl\" .}}{{end -}}\n<!DOCTYPE html>\n<html xmlns=\"http://www.w3.org/1999/xhtml\"\n      {{- block \"xmlns-epub\" .}}{{end}}\n      {{- block \"xhtml-lang\" .}}{{end}}>\n<head>\n{{block \"title\" .}}{{end -}}\n<meta charset=\"utf-8\"/>\n{{block \"stylesheets\" .}}{{end -}}\n</head>\n<body{{block \"body-attributes\" . }}{{end}}>\n",
 	"parts/xhtml-tail": "</body>\n</html>\n",
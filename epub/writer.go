@@ -22,7 +22,6 @@ import (
 	"bufio"
 	"compress/flate"
 	"errors"
-	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -50,9 +49,10 @@ var (
 )
 
 type File struct {
-	ID        string
-	MediaType string
-	Path      string
+	ID         string
+	MediaType  string
+	Path       string
+	Properties []string
 }
 
 type Book struct {
@@ -79,6 +79,15 @@ type Book struct {
 	nextID      int
 	current     io.WriteCloser
 	currentPath string
+	currentTail string
+	footnotes   []*Footnote
+	pageBreak   bool
+
+	// part counts the pages added by AddList since the start of
+	// the current chapter, and sectionsOpen is the number of
+	// section elements open in the current file.
+	part         int
+	sectionsOpen int
 
 	driver driver
 }
@@ -136,6 +145,7 @@ func newWriter(driver driver, identifier string) (
 	}
 
 	nav := w.RegisterFile(navName, "application/xhtml+xml", false)
+	nav.AddProperty("nav")
 	w.NavPath = nav.Path
 	css := w.RegisterFile(cssName, "text/css", false)
 	w.CSSPath = css.Path
@@ -159,16 +169,14 @@ func (w *Book) Close() error {
 	}
 
 	files := []struct {
-		path      string
+		file      *File
 		templates []string
 	}{
-		{w.driver.MakePath(w.Files[w.CSSPath].Path),
-			[]string{"book.css"}},
-		{w.driver.MakePath(w.Files[w.NavPath].Path),
-			[]string{"nav.xhtml", w.driver.Config()}},
+		{w.Files[w.CSSPath], []string{"book.css"}},
+		{w.Files[w.NavPath], []string{"nav.xhtml", w.driver.Config()}},
 	}
 	for _, file := range files {
-		err = w.addFileFromTemplate(file.path, file.templates, nil)
+		err = w.addBookFileFromTemplate(file.file, file.templates, nil)
 		if err != nil {
 			return err
 		}
@@ -199,6 +207,9 @@ func (w *Book) RegisterFile(baseName, mimeType string, inSpine bool) *File {
 	case "image/jpeg":
 		ext = ".jpg"
 		dir = "img/"
+	case "image/svg+xml":
+		ext = ".svg"
+		dir = "img/"
 	default:
 		panic("unknown mime type " + mimeType)
 	}
@@ -214,6 +225,7 @@ func (w *Book) RegisterFile(baseName, mimeType string, inSpine bool) *File {
 func (w *Book) closeFile() error {
 	err := w.current.Close()
 	w.current = nil
+	w.pageBreak = false
 	return err
 }
 
@@ -233,6 +245,20 @@ func (w *Book) createFile(path string) error {
 	return nil
 }
 
+// openFile creates the file for a manifest entry and makes it the
+// current file.  For XHTML files, the manifest properties are
+// updated as the file is written.
+func (w *Book) openFile(file *File) error {
+	err := w.createFile(w.driver.MakePath(file.Path))
+	if err != nil {
+		return err
+	}
+	if file.MediaType == "application/xhtml+xml" {
+		w.current = &propertyWriter{WriteCloser: w.current, file: file}
+	}
+	return nil
+}
+
 type epubFileCloser Book
 
 func (w *epubFileCloser) Write(p []byte) (n int, err error) {
@@ -287,10 +313,11 @@ func (w *Book) AddCoverImage(r io.Reader) error {
 	if err != nil {
 		return err
 	}
+	coverImage.AddProperty("cover-image")
 	w.CoverImageID = coverImage.ID
 
 	cover := w.RegisterFile(coverName, "application/xhtml+xml", true)
-	err = w.addFileFromTemplate(w.driver.MakePath(cover.Path),
+	err = w.addBookFileFromTemplate(cover,
 		[]string{"cover.xhtml", w.driver.Config()},
 		map[string]string{
 			"CoverImage": coverImage.Path,
@@ -311,7 +338,7 @@ func (w *Book) AddTitle(title string, authors []string) error {
 	w.Title = title
 	w.Authors = authors
 	file := w.RegisterFile(titleName, "application/xhtml+xml", true)
-	err := w.addFileFromTemplate(w.driver.MakePath(file.Path),
+	err := w.addBookFileFromTemplate(file,
 		[]string{"title.xhtml", w.driver.Config()}, nil)
 	if err != nil {
 		return err
@@ -326,24 +353,26 @@ func (w *Book) closeSections(level int) error {
 	}
 
 	for w.SectionLevel > level {
-		if w.SectionNumber[0] > 0 {
+		// The open sections are always the innermost ones.
+		if w.sectionsOpen > 0 {
 			err := w.writeTemplates(
 				[]string{"section-tail.xhtml", w.driver.Config()},
 				nil)
 			if err != nil {
 				return err
 			}
+			w.sectionsOpen--
 		}
 		w.SectionLevel--
 	}
 
 	if w.SectionLevel <= 0 {
-		tailName := "chapter-tail.xhtml"
-		if w.SectionNumber[0] == 0 {
-			tailName = "front-tail.xhtml"
+		err := w.writeFootnotes()
+		if err != nil {
+			return err
 		}
-		err := w.writeTemplates(
-			[]string{tailName, w.driver.Config()},
+		err = w.writeTemplates(
+			[]string{w.currentTail, w.driver.Config()},
 			nil)
 		if err != nil {
 			return err
@@ -356,10 +385,27 @@ func (w *Book) closeSections(level int) error {
 	return nil
 }
 
+// EndChapter closes the file of the current chapter, if any.  The
+// next section must have level 1.  Footnotes added after the call
+// are written into the file of the next chapter.
+func (w *Book) EndChapter() error {
+	if !w.open {
+		return ErrBookClosed
+	}
+	return w.closeSections(0)
+}
+
 func (w *Book) AddSection(level int, title string, secID string) error {
 	if !w.open {
 		return ErrBookClosed
 	}
+	if w.current == nil && w.part > 0 && level > 1 {
+		// a section in the text following a list
+		err := w.openFront()
+		if err != nil {
+			return err
+		}
+	}
 	if level <= 0 || level > w.SectionLevel+1 {
 		return ErrWrongSectionLevel
 	}
@@ -371,15 +417,17 @@ func (w *Book) AddSection(level int, title string, secID string) error {
 	w.SectionNumber.Inc(level)
 
 	if w.current == nil {
-		name := fmt.Sprintf("ch%s", w.SectionNumber)
+		w.part = 0
+		name := contentName(w.SectionNumber[0], w.part)
 		file := w.RegisterFile(name, "application/xhtml+xml", true)
 
 		log.Println("writing", file.Path, "...")
-		err := w.createFile(w.driver.MakePath(file.Path))
+		err := w.openFile(file)
 		if err != nil {
 			return err
 		}
 		w.currentPath = file.Path
+		w.currentTail = "chapter-tail.xhtml"
 		err = w.writeTemplates(
 			[]string{"chapter-head.xhtml", w.driver.Config()},
 			map[string]interface{}{
@@ -391,6 +439,11 @@ func (w *Book) AddSection(level int, title string, secID string) error {
 		}
 	}
 
+	err = w.writePageBreak()
+	if err != nil {
+		return err
+	}
+
 	if secID == "" {
 		secID = "epub-" + w.SectionNumber.String()
 	}
@@ -416,6 +469,7 @@ func (w *Book) AddSection(level int, title string, secID string) error {
 		up:    up,
 	})
 
+	w.sectionsOpen++
 	return w.writeTemplates(
 		[]string{"section-head.xhtml", w.driver.Config()},
 		map[string]interface{}{
@@ -431,27 +485,81 @@ func (w *Book) WriteString(s string) error {
 		return ErrBookClosed
 	}
 	if w.current == nil {
-		if w.SectionLevel > 0 {
-			panic("unexpected front matter")
-		}
-		w.SectionLevel = 1
-		w.SectionNumber = SecNo{0}
-
-		name := "front"
-		file := w.RegisterFile(name, "application/xhtml+xml", true)
-		log.Println("writing", file.Path, "...")
-		err := w.createFile(w.driver.MakePath(file.Path))
-		if err != nil {
-			return err
-		}
-		w.currentPath = file.Path
-		err = w.writeTemplates(
-			[]string{"front-head.xhtml", w.driver.Config()}, nil)
+		err := w.openFront()
 		if err != nil {
 			return err
 		}
 	}
-	_, err := w.current.Write([]byte(s))
+	err := w.writePageBreak()
+	if err != nil {
+		return err
+	}
+	_, err = w.current.Write([]byte(s))
+	return err
+}
+
+// openFront opens the file for the front matter, or for the text of
+// a chapter which follows a page added by AddList.
+func (w *Book) openFront() error {
+	if w.SectionLevel > 0 {
+		panic("unexpected front matter")
+	}
+	w.SectionLevel = 1
+	if len(w.SectionNumber) == 0 {
+		w.SectionNumber = SecNo{0}
+	}
+
+	name := contentName(w.SectionNumber[0], w.part)
+	file := w.RegisterFile(name, "application/xhtml+xml", true)
+	log.Println("writing", file.Path, "...")
+	err := w.openFile(file)
+	if err != nil {
+		return err
+	}
+	w.currentPath = file.Path
+	w.currentTail = "front-tail.xhtml"
+	return w.writeTemplates(
+		[]string{"front-head.xhtml", w.driver.Config()}, nil)
+}
+
+// ContentPath returns the path of the XHTML file which holds the text
+// of the given chapter, where chapter 0 is the front matter.  Every
+// page added by AddList ends the current file, and the text after
+// the page is written to a new file; `part` is the number of such
+// pages since the start of the chapter.
+func ContentPath(chapter, part int) string {
+	return contentName(chapter, part) + ".xhtml"
+}
+
+func contentName(chapter, part int) string {
+	name := "front"
+	if chapter > 0 {
+		name = "ch" + strconv.Itoa(chapter)
+	}
+	if part > 0 {
+		name += "-" + strconv.Itoa(part)
+	}
+	return name
+}
+
+// PageBreak makes the text written next start on a new page.  Since
+// every file of the book starts on a new page, the page break is
+// omitted if the next text starts a new file.
+func (w *Book) PageBreak() error {
+	if !w.open {
+		return ErrBookClosed
+	}
+	w.pageBreak = w.current != nil
+	return nil
+}
+
+// writePageBreak writes a pending page break to the current file.
+func (w *Book) writePageBreak() error {
+	if !w.pageBreak {
+		return nil
+	}
+	w.pageBreak = false
+	_, err := w.current.Write([]byte("<div class=\"epub-pagebreak\"></div>\n"))
 	return err
 }
 
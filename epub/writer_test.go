@@ -2,6 +2,9 @@ package epub
 
 import (
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -26,3 +29,119 @@ func TestWriterSimple(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestPageBreak(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epubtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewXhtmlWriter(dir, "epubtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []func() error{
+		w.PageBreak, // no file open yet, ignored
+		func() error { return w.WriteString("<p>front</p>\n") },
+		w.PageBreak,
+		func() error { return w.WriteString("<p>more</p>\n") },
+		w.PageBreak, // the chapter starts a new file, ignored
+		func() error { return w.AddSection(1, "Chapter", "") },
+		func() error { return w.WriteString("<p>text</p>\n") },
+		w.PageBreak,
+		func() error { return w.AddSection(2, "Section", "") },
+		w.PageBreak, // nothing follows, ignored
+		w.Close,
+	}
+	for i, step := range steps {
+		err = step()
+		if err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+	}
+
+	pageBreak := `<div class="epub-pagebreak"></div>`
+	testCases := []struct {
+		file, before string
+	}{
+		{"front.xhtml", "<p>more</p>"},
+		{"ch1.xhtml", `<section class="h2">`},
+	}
+	for _, test := range testCases {
+		body, err := ioutil.ReadFile(filepath.Join(dir, test.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(body), pageBreak); n != 1 {
+			t.Errorf("%s: expected 1 page break, found %d:\n%s",
+				test.file, n, body)
+		}
+		if !strings.Contains(string(body), pageBreak+"\n"+test.before) {
+			t.Errorf("%s: page break not found before %s:\n%s",
+				test.file, test.before, body)
+		}
+	}
+}
+
+func TestContentFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epubtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewXhtmlWriter(dir, "epubtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := []ListEntry{{Label: "1", Title: "x", Path: "ch1.xhtml", ID: "x"}}
+	steps := []func() error{
+		func() error { return w.WriteString("<p>front</p>\n") },
+		func() error { return w.AddList("List of Figures", "lof", list) },
+		func() error { return w.WriteString("<p>more front</p>\n") },
+		func() error { return w.AddSection(1, "One", "") },
+		func() error { return w.AddSection(2, "One.One", "") },
+		func() error { return w.AddList("List of Tables", "lot", list) },
+		func() error { return w.AddSection(2, "One.Two", "") },
+		func() error { return w.AddSection(1, "Two", "") },
+		w.Close,
+	}
+	for i, step := range steps {
+		err = step()
+		if err != nil {
+			t.Fatalf("step %d: %s", i, err)
+		}
+	}
+
+	testCases := []struct {
+		chapter, part int
+		contents      string
+	}{
+		{0, 0, "front"},
+		{0, 1, "more front"},
+		{1, 0, "One.One"},
+		{1, 1, "1.2"},
+		{2, 0, "Two"},
+	}
+	for _, test := range testCases {
+		path := ContentPath(test.chapter, test.part)
+		if w.Files[path] == nil {
+			t.Errorf("file %s not registered", path)
+			continue
+		}
+		body, err := ioutil.ReadFile(filepath.Join(dir, path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(body), test.contents) {
+			t.Errorf("%s: %q not found:\n%s", path, test.contents, body)
+		}
+		open := strings.Count(string(body), "<section")
+		close := strings.Count(string(body), "</section>")
+		if open != close {
+			t.Errorf("%s: %d sections opened, %d closed:\n%s",
+				path, open, close, body)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// backend.go - storage for the cached files
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Backend is a key-value store for cached files.  The names used as
+// keys consist of a hash of the cache key, followed by the file name
+// extension of the cache.  Implementations must be safe for
+// concurrent use.
+type Backend interface {
+	// Get returns the file contents and the metadata stored under the
+	// given name.  If no file is found, the returned error satisfies
+	// os.IsNotExist().  The caller verifies the data against the
+	// checksum in the metadata.
+	Get(name string) ([]byte, *Meta, error)
+
+	// Put stores file contents, together with their metadata, under
+	// the given name.  Any previously stored value is overwritten.
+	Put(name string, data []byte, meta *Meta) error
+}
+
+// DirBackend is a Backend which stores files in a local directory.
+// The metadata for every file is stored as JSON, in a second file with
+// the extension ".meta" appended to the file name.
+type DirBackend struct {
+	Dir string
+}
+
+// Get implements the Backend interface.  The modification time of
+// the file is set to the current time, to record the use of the file.
+func (b *DirBackend) Get(name string) ([]byte, *Meta, error) {
+	path := filepath.Join(b.Dir, name)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta, err := readMeta(path + metaExt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// record the use of the file, to protect it from pruning
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+
+	return data, meta, nil
+}
+
+// Put implements the Backend interface.
+func (b *DirBackend) Put(name string, data []byte, meta *Meta) error {
+	path := filepath.Join(b.Dir, name)
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	// The metadata is written first, so that other processes never
+	// see data files without metadata.
+	err = b.writeFile(path+metaExt, append(metaData, '\n'))
+	if err != nil {
+		return err
+	}
+	return b.writeFile(path, data)
+}
+
+// writeFile atomically replaces the file at `path` with the given
+// contents.  The data is written to a temporary file first, which is
+// then renamed, so that other processes never see partial files.
+func (b *DirBackend) writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(b.Dir, tmpPrefix)
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	e2 := tmp.Close()
+	if err == nil {
+		err = e2
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
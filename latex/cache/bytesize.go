@@ -16,13 +16,36 @@
 
 package cache
 
-import "fmt"
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
 
-type byteSize int64
+var pruneLimit = ByteSize(256 * 1024)
 
-func (x byteSize) String() string {
+func init() {
+	flag.Var(&pruneLimit, "cache-limit",
+		"size each image cache is reduced to after use, e.g. \"256K\" or \"10M\"")
+}
+
+// PruneLimit returns the size limit for pruning caches, as set by the
+// -cache-limit command line option.  The value is intended to be used
+// as the argument of Cache.Close().
+func PruneLimit() int64 {
+	return int64(pruneLimit)
+}
+
+// ByteSize is a size in bytes.  When formatted or parsed, the prefixes
+// K, M, G, ... stand for powers of 1024.
+type ByteSize int64
+
+var prefixes = []string{"", "K", "M", "G", "T", "P"}
+
+func (x ByteSize) String() string {
 	val := float64(x)
-	prefixes := []string{"", "K", "M", "G", "T", "P"}
 	var pfx string
 	for _, pfx = range prefixes {
 		if val <= 1000.0 {
@@ -32,3 +55,23 @@ func (x byteSize) String() string {
 	}
 	return fmt.Sprintf("%.3g%sB", val, pfx)
 }
+
+// Set parses sizes like "1000", "256K" or "1.5MB".  Set implements
+// the flag.Value interface.
+func (x *ByteSize) Set(s string) error {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	factor := 1.0
+	for i := len(prefixes) - 1; i > 0; i-- {
+		if strings.HasSuffix(num, prefixes[i]) {
+			num = strings.TrimSuffix(num, prefixes[i])
+			factor = math.Pow(1024, float64(i))
+			break
+		}
+	}
+	val, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || val < 0 {
+		return fmt.Errorf("invalid size %q", s)
+	}
+	*x = ByteSize(val * factor)
+	return nil
+}
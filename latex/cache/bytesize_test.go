@@ -0,0 +1,53 @@
+// bytesize_test.go - unit tests for bytesize.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cache
+
+import "testing"
+
+func TestByteSize(t *testing.T) {
+	testCases := []struct {
+		in  string
+		out ByteSize
+	}{
+		{"0", 0},
+		{"1000", 1000},
+		{"256K", 256 * 1024},
+		{"256kB", 256 * 1024},
+		{"1.5M", 1536 * 1024},
+		{"2G", 2 << 30},
+	}
+	for _, test := range testCases {
+		var x ByteSize
+		err := x.Set(test.in)
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err)
+		} else if x != test.out {
+			t.Errorf("%q: expected %d, got %d", test.in, test.out, x)
+		}
+	}
+
+	for _, in := range []string{"", "K", "-1", "12Q"} {
+		var x ByteSize
+		if x.Set(in) == nil {
+			t.Errorf("invalid size %q accepted", in)
+		}
+	}
+
+	if s := ByteSize(256 * 1024).String(); s != "256KB" {
+		t.Errorf("wrong string %q", s)
+	}
+}
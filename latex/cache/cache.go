@@ -17,8 +17,10 @@
 package cache
 
 import (
+	"bytes"
 	"encoding/base64"
 	"flag"
+	"fmt"
 	"image"
 	"image/png"
 	"log"
@@ -26,27 +28,71 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/sha3"
 )
 
-var cacheDir = flag.String("cache-dir", "",
-	"cache directory for rendered images")
+var (
+	cacheDir = flag.String("cache-dir", "",
+		"cache directory for rendered images")
+	cacheURL = flag.String("cache-url", "",
+		"base URL of a shared HTTP cache for rendered images")
+)
+
+const (
+	metaExt   = ".meta"
+	tmpPrefix = ".tmp-"
+	lockName  = ".lock"
+	tmpMaxAge = time.Hour
+)
 
 // Cache provides a facility to temporarily store images on disk for
-// later retrival.
+// later retrival.  The methods of a Cache can be used concurrently
+// from different goroutines, and several processes can share the same
+// cache directory: files are written atomically, a metadata file with
+// a checksum is stored next to every file and is verified when the
+// file is read, and the cache directory is locked while old files are
+// removed.
+//
+// Optionally, a second Backend can be used to share files with other
+// machines.  Files not found in the cache directory are looked up in
+// this backend, and new files are stored in both places.
 type Cache struct {
+	// Engine and Resolution describe how the images were produced.
+	// The values are recorded in the metadata of new entries and
+	// must be set before the first call to .Put() or .PutData().
+	Engine     string
+	Resolution int
+
 	cacheDir string
-	entries  map[string]*entry
+	ext      string
 	start    time.Time
+	local    *DirBackend
+
+	mutex   sync.Mutex
+	entries map[string]*entry
+	remote  Backend
 }
 
 // NewCache creates a new cache, backed by subdirectory 'subdir'
 // inside the cache directory.  The cache is pre-populated with any
 // images found in this directory.
 func NewCache(subdir string) (*Cache, error) {
+	return NewDataCache(subdir, ".png")
+}
+
+// NewDataCache creates a new cache for files with the given file name
+// extension, like ".svg".  The methods .PutData() and .GetData() can
+// be used to store and retrieve the file contents.
+//
+// If the -cache-url command line option or the environment variable
+// EPUBLATEX_CACHE_URL is set, an HTTPBackend for the URL given there,
+// with 'subdir' appended, is used to share files with other machines.
+func NewDataCache(subdir, ext string) (*Cache, error) {
 	c := &Cache{
+		ext:     ext,
 		entries: make(map[string]*entry),
 		start:   time.Now(),
 	}
@@ -64,53 +110,130 @@ func NewCache(subdir string) (*Cache, error) {
 	if err != nil {
 		return nil, err
 	}
+	c.local = &DirBackend{Dir: c.cacheDir}
+
+	url := *cacheURL
+	if len(url) == 0 {
+		url = os.Getenv("EPUBLATEX_CACHE_URL")
+	}
+	if len(url) > 0 {
+		c.remote = NewHTTPBackend(strings.TrimSuffix(url, "/") + "/" + subdir)
+	}
 
-	dir, err := os.Open(c.cacheDir)
+	all, other, err := c.scan()
 	if err != nil {
 		return nil, err
 	}
-	defer dir.Close()
-	files, err := dir.Readdir(0)
-	var total int64
-dirLoop:
-	for _, fi := range files {
+	for _, fi := range other {
 		name := fi.Name()
-		if fi.IsDir() || !strings.HasSuffix(name, ".png") {
+		if !strings.HasPrefix(name, ".") && !strings.Contains(name, c.ext) {
 			log.Printf("cache %s: unexpected file %q", c.cacheDir, name)
-			continue dirLoop
 		}
-		hash := name[:len(name)-4]
-		e := &entry{
-			Size: fi.Size(),
-			Time: fi.ModTime(),
+	}
+	var total int64
+	for _, e := range all {
+		if !e.Valid {
+			// stale or incomplete entry, will be removed by .Close()
+			continue
+		}
+		c.entries[e.Hash] = &entry{
+			Size: e.Size,
+			Time: e.LastUsed,
 		}
-		c.entries[hash] = e
 		total += e.Size
 	}
 	log.Printf("cache %s: %s (%d objects)",
-		c.cacheDir, byteSize(total), len(c.entries))
+		c.cacheDir, ByteSize(total), len(c.entries))
 
 	return c, nil
 }
 
+// Dir returns the directory used to store the cached files.
+func (c *Cache) Dir() string {
+	return c.cacheDir
+}
+
+// SetRemote sets the Backend used to share files with other machines.
+// If b is nil, only the local cache directory is used.
+func (c *Cache) SetRemote(b Backend) {
+	c.mutex.Lock()
+	c.remote = b
+	c.mutex.Unlock()
+}
+
+func (c *Cache) getRemote() Backend {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.remote
+}
+
+// remoteFailed disables the remote backend after an error, so that
+// an unreachable server does not slow down the conversion.
+func (c *Cache) remoteFailed(b Backend, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.remote == b {
+		log.Printf("cache %s: disabling shared cache: %s", c.cacheDir, err)
+		c.remote = nil
+	}
+}
+
 // Close must be called when the cache is no longer needed.  Up to
 // 'pruneLimit' bytes of images may be left behind in the cache
 // directory; these files will be used to pre-populate future Cache
-// instances.
+// instances.  Entries with missing metadata, or metadata from a
+// different version of the cache format, are always removed.
 //
-// If pruneLimit >= 0, images added using the current Cache instance
-// will always be retained, even if their total size exceeds
-// pruneLimit.  If pruneLimit < 0, all cached data is removed.
+// If pruneLimit >= 0, images added or used since the current Cache
+// instance was created will always be retained, even if their total
+// size exceeds pruneLimit.  This includes images added or used by
+// other processes.  If pruneLimit < 0, all cached data is removed.
 func (c *Cache) Close(pruneLimit int64) error {
+	c.mutex.Lock()
+	c.entries = nil
+	c.mutex.Unlock()
+
+	unlock, err := lockDir(c.cacheDir)
+	if err != nil {
+		return err
+	}
+
+	// Other processes may have changed the directory since the cache
+	// was opened, so we need to read the directory again.
+	all, other, err := c.scan()
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	var remove []string
+	for _, fi := range other {
+		// Temporary files and metadata left over from crashed
+		// processes, and files from old versions of the cache.
+		if fi.IsDir() {
+			continue
+		}
+		if pruneLimit < 0 || time.Since(fi.ModTime()) > tmpMaxAge {
+			remove = append(remove, fi.Name())
+		}
+	}
+
 	var of oldestFirst
 	var total int64
-	for hash, e := range c.entries {
-		of = append(of, pruneEntry{key: hash, entry: e})
+	for _, e := range all {
+		if !e.Valid {
+			remove = append(remove, e.Hash+c.ext, e.Hash+c.ext+metaExt)
+			continue
+		}
+		pe := pruneEntry{
+			key:   e.Hash,
+			entry: &entry{Size: e.Size, Time: e.LastUsed},
+		}
+		of = append(of, pe)
 		total += e.Size
 	}
 	sort.Sort(of)
 
-	var err error
 	var pruneCount int
 	var pruneBytes int64
 	for _, pe := range of {
@@ -120,89 +243,178 @@ func (c *Cache) Close(pruneLimit int64) error {
 		if pruneLimit >= 0 && c.start.Before(pe.Time) {
 			break
 		}
-		e2 := os.Remove(c.filePath(pe.key))
-		if err == nil {
-			err = e2
-		}
+		remove = append(remove, pe.key+c.ext, pe.key+c.ext+metaExt)
 		pruneCount++
 		pruneBytes += pe.Size
 		total -= pe.Size
 	}
+	for _, name := range remove {
+		e2 := os.Remove(filepath.Join(c.cacheDir, name))
+		if err == nil && !os.IsNotExist(e2) {
+			err = e2
+		}
+	}
 	if pruneCount > 0 {
 		log.Printf("cache %s: removed %s (%d objects)",
-			c.cacheDir, byteSize(pruneBytes), pruneCount)
+			c.cacheDir, ByteSize(pruneBytes), pruneCount)
 	}
 
+	e2 := unlock()
+	if err == nil {
+		err = e2
+	}
 	if pruneLimit < 0 {
+		_ = os.Remove(filepath.Join(c.cacheDir, lockName))
 		_ = os.Remove(c.cacheDir)
 	}
 
-	c.entries = nil
 	return err
 }
 
 // Has returns true, if the cache contains an image which has
 // previously been stored for the given key.  The image can be
-// retrieved using the .Get() method.
+// retrieved using the .Get() method.  If the image is not found in
+// the cache directory, but in the shared cache, the image is copied
+// into the cache directory.
 func (c *Cache) Has(key string) bool {
 	hash := hashKey(key)
+	c.mutex.Lock()
 	entry, ok := c.entries[hash]
 	if ok {
 		entry.Time = time.Now()
 	}
+	c.mutex.Unlock()
+	if !ok {
+		ok = c.fetch(key, hash) == nil
+	}
 	return ok
 }
 
 // Put stores a new image in the cache.  The image can later be
 // retrieved using the given key.  Any preexisting image using the
 // same key is overwritten by subsequent calls to .Put().
-func (c *Cache) Put(key string, img image.Image) (err error) {
-	hash := hashKey(key)
-	path := c.filePath(hash)
-	w, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		e2 := w.Close()
-		if err == nil {
-			err = e2
-		}
-	}()
-	err = png.Encode(w, img)
+func (c *Cache) Put(key string, img image.Image) error {
+	buf := &bytes.Buffer{}
+	err := png.Encode(buf, img)
 	if err != nil {
 		return err
 	}
+	return c.PutData(key, buf.Bytes())
+}
+
+// PutData stores the contents of a file in the cache.  The data can
+// later be retrieved using the given key.
+func (c *Cache) PutData(key string, data []byte) error {
+	hash := hashKey(key)
+	name := hash + c.ext
 
-	fi, err := w.Stat()
+	now := time.Now()
+	meta := &Meta{
+		Format:     formatVersion,
+		Key:        key,
+		Checksum:   checksum(data),
+		Engine:     c.Engine,
+		Resolution: c.Resolution,
+		Created:    now,
+	}
+	err := c.local.Put(name, data, meta)
 	if err != nil {
 		return err
 	}
-	e := &entry{
-		Size: fi.Size(),
-		Time: time.Now(),
+	c.addEntry(hash, int64(len(data)), now)
+
+	if remote := c.getRemote(); remote != nil {
+		err = remote.Put(name, data, meta)
+		if err != nil {
+			c.remoteFailed(remote, err)
+			return err
+		}
 	}
-	c.entries[hash] = e
 	return nil
 }
 
 // Get returns an image which has previously been stored in the cache
 // for the given key.
 func (c *Cache) Get(key string) (image.Image, error) {
+	data, err := c.GetData(key)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(data))
+}
+
+// GetData returns file contents which have previously been stored in
+// the cache for the given key.  An error is returned if the file
+// contents do not match the checksum stored in the metadata, for
+// example because the file has been damaged.
+func (c *Cache) GetData(key string) ([]byte, error) {
 	hash := hashKey(key)
-	path := c.filePath(hash)
-	in, err := os.Open(path)
+	name := hash + c.ext
+	data, meta, err := c.local.Get(name)
+	if os.IsNotExist(err) && c.fetch(key, hash) == nil {
+		data, meta, err = c.local.Get(name)
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer in.Close()
+	err = check(key, data, meta)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.filePath(hash), err)
+	}
+
+	c.mutex.Lock()
+	if e, ok := c.entries[hash]; ok {
+		e.Time = time.Now()
+	}
+	c.mutex.Unlock()
+	return data, nil
+}
+
+// fetch copies a file from the remote backend into the cache
+// directory.
+func (c *Cache) fetch(key, hash string) error {
+	remote := c.getRemote()
+	if remote == nil {
+		return errNoRemote
+	}
 
-	c.entries[hash].Time = time.Now()
-	return png.Decode(in)
+	name := hash + c.ext
+	data, meta, err := remote.Get(name)
+	if os.IsNotExist(err) {
+		return err
+	} else if err != nil {
+		c.remoteFailed(remote, err)
+		return err
+	}
+	err = check(key, data, meta)
+	if err != nil {
+		// The shared cache may contain damaged files, or different
+		// files for the same hash.  These are not copied.
+		return err
+	}
+
+	err = c.local.Put(name, data, meta)
+	if err != nil {
+		return err
+	}
+	c.addEntry(hash, int64(len(data)), time.Now())
+	return nil
+}
+
+func (c *Cache) addEntry(hash string, size int64, t time.Time) {
+	e := &entry{
+		Size: size,
+		Time: t,
+	}
+	c.mutex.Lock()
+	if c.entries != nil {
+		c.entries[hash] = e
+	}
+	c.mutex.Unlock()
 }
 
 func (c *Cache) filePath(hash string) string {
-	return filepath.Join(c.cacheDir, hash+".png")
+	return filepath.Join(c.cacheDir, hash+c.ext)
 }
 
 func hashKey(key string) string {
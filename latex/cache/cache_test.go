@@ -18,9 +18,16 @@ package cache
 
 import (
 	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"image"
+	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCache(t *testing.T) {
@@ -59,3 +66,305 @@ func TestCache(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestDataCache(t *testing.T) {
+	c, err := NewDataCache("test-data", ".svg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("<svg></svg>")
+	err = c.PutData("A", data)
+	if err != nil {
+		t.Error(err)
+	}
+	if !c.Has("A") {
+		t.Error("key A not found")
+	}
+	d2, err := c.GetData("A")
+	if err != nil {
+		t.Error(err)
+	} else if string(d2) != string(data) {
+		t.Errorf("key A yielded wrong data %q", d2)
+	}
+
+	err = c.Close(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	c, err := NewDataCache("test-concurrent", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				key := fmt.Sprintf("%d", j)
+				data := []byte(strings.Repeat(key, 100))
+				err := c.PutData(key, data)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				d2, err := c.GetData(key)
+				if err != nil {
+					t.Error(err)
+				} else if string(d2) != string(data) {
+					t.Errorf("key %s yielded wrong data", key)
+				}
+				c.Has(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	files, err := ioutil.ReadDir(c.cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if strings.HasPrefix(fi.Name(), tmpPrefix) {
+			t.Errorf("temporary file %s left behind", fi.Name())
+		}
+	}
+
+	err = c.Close(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCorrupted(t *testing.T) {
+	c, err := NewDataCache("test-corrupted", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(-1)
+
+	err = c.PutData("A", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(c.filePath(hashKey("A")), []byte("hellO"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.GetData("A")
+	if !errors.Is(err, errChecksum) {
+		t.Errorf("damaged file not detected, err = %v", err)
+	}
+}
+
+func TestShared(t *testing.T) {
+	c0, err := NewDataCache("test-shared", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c0.PutData("old", []byte("old data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c0.Close(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// c1 and c2 stand for two processes sharing the cache directory
+	c1, err := NewDataCache("test-shared", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := NewDataCache("test-shared", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c2.Has("old") {
+		t.Error("key old not found")
+	}
+	time.Sleep(10 * time.Millisecond)
+	err = c2.PutData("new", []byte("new data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = c1.Close(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.GetData("old"); !os.IsNotExist(err) {
+		t.Error("old entry not pruned", err)
+	}
+	if _, err := c2.GetData("new"); err != nil {
+		t.Error("entry of other cache instance pruned", err)
+	}
+
+	err = c2.Close(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	c, err := NewDataCache("test-meta", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(-1)
+	c.Engine = "TeX 3.14"
+	c.Resolution = 300
+
+	err = c.PutData("A", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	all, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 1 {
+		t.Fatalf("wrong number of entries %d", len(all))
+	}
+	e := all[0]
+	if !e.Valid || e.Key != "A" || e.Engine != "TeX 3.14" ||
+		e.Resolution != 300 || e.Format != formatVersion || e.Size != 5 {
+		t.Errorf("wrong entry %#v", e)
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	c, err := NewDataCache("test-format", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.PutData("A", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Close(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replace the metadata by metadata from a future cache format
+	path := c.filePath(hashKey("A")) + metaExt
+	meta, err := readMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta.Format = formatVersion + 1
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.local.writeFile(path, metaData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = NewDataCache("test-format", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Has("A") {
+		t.Error("entry from different cache format found")
+	}
+	if _, err := c.GetData("A"); err == nil {
+		t.Error("entry from different cache format used")
+	}
+	err = c.Close(1 << 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("entry from different cache format not removed", err)
+	}
+
+	c, err = NewDataCache("test-format", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close(-1)
+}
+
+func TestVerify(t *testing.T) {
+	c, err := NewDataCache("test-verify", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(-1)
+
+	for _, key := range []string{"A", "B", "C"} {
+		err = c.PutData(key, []byte("data for "+key))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(c.filePath(hashKey("B")), []byte("damaged"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bad, err := c.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bad) != 1 || bad[0].Key != "B" {
+		t.Errorf("wrong damaged entries %v", bad)
+	}
+	if c.Has("B") || !c.Has("A") || !c.Has("C") {
+		t.Error("wrong entries removed")
+	}
+	all, err := c.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 {
+		t.Errorf("wrong number of entries %d after verify", len(all))
+	}
+}
+
+func TestVerifyLocked(t *testing.T) {
+	c, err := NewDataCache("test-verify-locked", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(-1)
+
+	err = c.PutData("A", []byte("data for A"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// While another process holds the lock, Verify must wait.
+	unlock, err := lockDir(c.cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := c.Verify()
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Error("Verify did not wait for the cache lock")
+	case <-time.After(50 * time.Millisecond):
+		// pass
+	}
+	err = unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-done
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,116 @@
+// http.go - share cached files between machines using HTTP
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	httpTimeout  = 30 * time.Second
+	httpMaxValue = 64 << 20
+)
+
+// HTTPBackend is a Backend which stores files on an HTTP server.  A
+// file with name NAME is stored using a PUT request to the URL
+// BASE/NAME and is retrieved using a GET request to the same URL,
+// where BASE is the value of the URL field.  The server must respond
+// to GET requests for unknown names with status 404.
+//
+// The body of the requests consists of the metadata in JSON format,
+// followed by a newline character and the file contents.  Any server
+// which stores and returns the request bodies unchanged, for example
+// a web server with WebDAV support, can be used.
+type HTTPBackend struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewHTTPBackend returns a new HTTPBackend for the given base URL.
+func NewHTTPBackend(baseURL string) *HTTPBackend {
+	return &HTTPBackend{
+		URL:    strings.TrimSuffix(baseURL, "/"),
+		Client: &http.Client{Timeout: httpTimeout},
+	}
+}
+
+// Get implements the Backend interface.
+func (b *HTTPBackend) Get(name string) ([]byte, *Meta, error) {
+	url := b.URL + "/" + name
+	resp, err := b.Client.Get(url)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil, &os.PathError{Op: "get", Path: url, Err: os.ErrNotExist}
+	case resp.StatusCode != http.StatusOK:
+		return nil, nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, httpMaxValue))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	i := bytes.IndexByte(body, '\n')
+	if i < 0 {
+		return nil, nil, fmt.Errorf("%s: missing metadata", url)
+	}
+	meta, err := parseMeta(body[:i])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", url, err)
+	}
+	return body[i+1:], meta, nil
+}
+
+// Put implements the Backend interface.
+func (b *HTTPBackend) Put(name string, data []byte, meta *Meta) error {
+	metaData, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	body := make([]byte, 0, len(metaData)+1+len(data))
+	body = append(body, metaData...)
+	body = append(body, '\n')
+	body = append(body, data...)
+
+	url := b.URL + "/" + name
+	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := b.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
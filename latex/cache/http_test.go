@@ -0,0 +1,180 @@
+// http_test.go - unit tests for http.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memServer is a minimal key-value server for use with HTTPBackend.
+type memServer struct {
+	sync.Mutex
+	values map[string][]byte
+}
+
+func newMemServer() *memServer {
+	return &memServer{values: make(map[string][]byte)}
+}
+
+func (s *memServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.Lock()
+	defer s.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		val, ok := s.values[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(val)
+	case http.MethodPut:
+		val, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.values[r.URL.Path] = val
+		w.WriteHeader(http.StatusCreated)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func TestHTTPBackend(t *testing.T) {
+	server := httptest.NewServer(newMemServer())
+	defer server.Close()
+	b := NewHTTPBackend(server.URL + "/maths/")
+
+	_, _, err := b.Get("A.png")
+	if !os.IsNotExist(err) {
+		t.Errorf("wrong error for missing value: %v", err)
+	}
+
+	data := []byte("line 1\nline 2\n")
+	meta := &Meta{
+		Format:   formatVersion,
+		Key:      "A",
+		Checksum: checksum(data),
+	}
+	err = b.Put("A.png", data, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d2, m2, err := b.Get("A.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(d2) != string(data) {
+		t.Errorf("wrong data %q", d2)
+	}
+	if m2.Key != "A" || m2.Checksum != meta.Checksum {
+		t.Errorf("wrong metadata %#v", m2)
+	}
+}
+
+func TestSharedCache(t *testing.T) {
+	mem := newMemServer()
+	server := httptest.NewServer(mem)
+	defer server.Close()
+
+	// c1 and c2 stand for two machines sharing the same HTTP cache
+	c1, err := NewDataCache("test-http-1", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close(-1)
+	c1.SetRemote(NewHTTPBackend(server.URL + "/test"))
+	c2, err := NewDataCache("test-http-2", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close(-1)
+	c2.SetRemote(NewHTTPBackend(server.URL + "/test"))
+
+	err = c1.PutData("A", []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mem.values) != 1 {
+		t.Fatalf("%d values stored in shared cache", len(mem.values))
+	}
+
+	if !c2.Has("A") {
+		t.Fatal("key A not found in shared cache")
+	}
+	data, err := c2.GetData("A")
+	if err != nil {
+		t.Fatal(err)
+	} else if string(data) != "hello" {
+		t.Errorf("wrong data %q", data)
+	}
+	if _, err := os.Stat(c2.filePath(hashKey("A"))); err != nil {
+		t.Error("file not copied into the cache directory:", err)
+	}
+	if c2.Has("B") {
+		t.Error("non-existent key B found")
+	}
+
+	// damaged files in the shared cache must not be used
+	for name, val := range mem.values {
+		mem.values[name] = []byte(strings.Replace(string(val), "hello", "hellO", 1))
+	}
+	c3, err := NewDataCache("test-http-3", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c3.Close(-1)
+	c3.SetRemote(NewHTTPBackend(server.URL + "/test"))
+	if c3.Has("A") {
+		t.Error("damaged file from shared cache used")
+	}
+}
+
+func TestSharedCacheDown(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "out of order", http.StatusInternalServerError)
+		}))
+	defer server.Close()
+
+	c, err := NewDataCache("test-http-down", ".txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close(-1)
+	c.SetRemote(NewHTTPBackend(server.URL))
+
+	if c.Has("A") {
+		t.Error("key A found")
+	}
+	if c.getRemote() != nil {
+		t.Error("failed shared cache not disabled")
+	}
+	err = c.PutData("A", []byte("hello"))
+	if err != nil {
+		t.Error(err)
+	}
+	if !c.Has("A") {
+		t.Error("key A not found")
+	}
+}
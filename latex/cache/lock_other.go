@@ -0,0 +1,69 @@
+// lock_other.go - lock the cache directory on systems without flock
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build !unix
+// +build !unix
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// lockRetry is the time to wait before trying again to create
+	// the lock file.
+	lockRetry = 50 * time.Millisecond
+
+	// staleLockAge is the age after which a lock file is assumed to
+	// be left over from a crashed process.
+	staleLockAge = 10 * time.Minute
+)
+
+// lockDir obtains an exclusive lock on the cache directory `dir`,
+// which is respected by all epublatex processes using the same
+// directory.  The returned function releases the lock.  Without
+// flock(2), the lock is held by creating the lock file, and is
+// released by removing the file again.
+func lockDir(dir string) (func() error, error) {
+	name := filepath.Join(dir, lockName)
+	for {
+		fd, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			err = fd.Close()
+			if err != nil {
+				os.Remove(name)
+				return nil, err
+			}
+			unlock := func() error {
+				return os.Remove(name)
+			}
+			return unlock, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		fi, err := os.Stat(name)
+		if err == nil && time.Since(fi.ModTime()) > staleLockAge {
+			os.Remove(name)
+			continue
+		}
+		time.Sleep(lockRetry)
+	}
+}
@@ -0,0 +1,50 @@
+// lock_unix.go - lock the cache directory against other processes
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+//go:build unix
+// +build unix
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// lockDir obtains an exclusive lock on the cache directory `dir`,
+// which is respected by all epublatex processes using the same
+// directory.  The returned function releases the lock.
+func lockDir(dir string) (func() error, error) {
+	fd, err := os.OpenFile(filepath.Join(dir, lockName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(fd.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+	unlock := func() error {
+		err := syscall.Flock(int(fd.Fd()), syscall.LOCK_UN)
+		e2 := fd.Close()
+		if err == nil {
+			err = e2
+		}
+		return err
+	}
+	return unlock, nil
+}
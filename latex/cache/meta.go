@@ -0,0 +1,219 @@
+// meta.go - metadata stored next to the cached files
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package cache
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// formatVersion must be changed whenever the layout of the cache
+// directories or of the metadata changes.  Entries using a different
+// format are ignored and are removed when the cache is closed.
+const formatVersion = 1
+
+var (
+	errChecksum = errors.New("checksum mismatch")
+	errWrongKey = errors.New("wrong key")
+	errNoRemote = errors.New("no shared cache")
+)
+
+// Meta is the metadata stored for every file in the cache.
+type Meta struct {
+	Format   int    `json:"format"`
+	Key      string `json:"key"`
+	Checksum string `json:"checksum"`
+
+	// Engine and Resolution are copied from the Cache fields of the
+	// same name when the entry is stored.
+	Engine     string `json:"engine,omitempty"`
+	Resolution int    `json:"resolution,omitempty"`
+
+	Created time.Time `json:"created"`
+}
+
+// Entry describes a file found in a cache directory.
+type Entry struct {
+	Meta
+
+	Hash     string
+	Size     int64
+	LastUsed time.Time
+
+	// Valid is false if the metadata for the entry is missing or uses
+	// a different version of the cache format.  In this case, only
+	// Hash, Size and LastUsed are set.
+	Valid bool
+}
+
+func readMeta(path string) (*Meta, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := parseMeta(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return meta, nil
+}
+
+func parseMeta(data []byte) (*Meta, error) {
+	meta := &Meta{}
+	err := json.Unmarshal(data, meta)
+	if err != nil {
+		return nil, err
+	}
+	if meta.Format != formatVersion {
+		return nil, fmt.Errorf("unsupported cache format %d", meta.Format)
+	}
+	return meta, nil
+}
+
+// check verifies that the given data was stored in the cache for
+// the given key and has not been damaged.
+func check(key string, data []byte, meta *Meta) error {
+	if meta.Key != key {
+		return errWrongKey
+	}
+	if meta.Checksum != checksum(data) {
+		return errChecksum
+	}
+	return nil
+}
+
+// scan reads the cache directory.  The entries are returned in
+// arbitrary order; 'other' lists all files which do not belong to an
+// entry, except for the lock file.
+func (c *Cache) scan() (all []*Entry, other []os.FileInfo, err error) {
+	files, err := ioutil.ReadDir(c.cacheDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	isFile := make(map[string]bool)
+	for _, fi := range files {
+		isFile[fi.Name()] = !fi.IsDir()
+	}
+
+	for _, fi := range files {
+		name := fi.Name()
+		switch {
+		case name == lockName:
+			// pass
+		case isFile[name] && strings.HasSuffix(name, c.ext) &&
+			!strings.HasPrefix(name, "."):
+			e := &Entry{
+				Hash:     strings.TrimSuffix(name, c.ext),
+				Size:     fi.Size(),
+				LastUsed: fi.ModTime(),
+			}
+			if isFile[name+metaExt] {
+				meta, err := readMeta(filepath.Join(c.cacheDir, name+metaExt))
+				if err == nil {
+					e.Meta = *meta
+					e.Valid = true
+				}
+			}
+			all = append(all, e)
+		case strings.HasSuffix(name, c.ext+metaExt) &&
+			isFile[strings.TrimSuffix(name, metaExt)]:
+			// metadata for an entry
+		default:
+			other = append(other, fi)
+		}
+	}
+	return all, other, nil
+}
+
+// List returns all entries in the cache directory, including invalid
+// entries, sorted by the time of last use, oldest first.
+func (c *Cache) List() ([]*Entry, error) {
+	all, _, err := c.scan()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].LastUsed.Before(all[j].LastUsed)
+	})
+	return all, nil
+}
+
+// Verify reads all files in the cache directory and compares the
+// contents to the checksums stored in the metadata.  Damaged and
+// invalid entries are removed from the cache and are returned.  The
+// cache directory is locked while the files are checked, so that
+// other processes cannot prune the cache at the same time.
+func (c *Cache) Verify() (bad []*Entry, err error) {
+	unlock, err := lockDir(c.cacheDir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		e2 := unlock()
+		if err == nil {
+			err = e2
+		}
+	}()
+
+	all, err := c.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range all {
+		path := c.filePath(e.Hash)
+		if e.Valid {
+			data, err := ioutil.ReadFile(path)
+			if err != nil {
+				return bad, err
+			}
+			if checksum(data) == e.Checksum {
+				continue
+			}
+		}
+		bad = append(bad, e)
+
+		c.mutex.Lock()
+		delete(c.entries, e.Hash)
+		c.mutex.Unlock()
+		err = os.Remove(path)
+		if err == nil {
+			err = os.Remove(path + metaExt)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return bad, err
+		}
+	}
+	return bad, nil
+}
+
+// checksum returns the checksum stored in the metadata of every file
+// in the cache, as a hexadecimal string prefixed by the name of the
+// checksum algorithm.
+func checksum(data []byte) string {
+	sum := sha3.Sum256(data)
+	return "sha3-256:" + hex.EncodeToString(sum[:])
+}
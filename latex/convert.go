@@ -17,11 +17,15 @@
 package latex
 
 import (
+	"context"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 
 	"github.com/seehuhn/epublatex/epub"
+	"github.com/seehuhn/epublatex/latex/render"
+	"github.com/seehuhn/epublatex/latex/scanner"
 )
 
 type converter struct {
@@ -34,11 +38,40 @@ type converter struct {
 	Images map[string]string
 	Labels []*xRef
 
-	Section  epub.SecNo
+	// ImageErrors records the images which could not be rendered.
+	// These errors are reported when the images are used in pass 2.
+	ImageErrors map[string]error
+
+	// RenderErrors lists the rendering failures, which are returned
+	// by Convert once the book is complete.
+	RenderErrors render.Errors
+
+	Section epub.SecNo
+	// Part counts the lists of figures and tables since the start
+	// of the current chapter.  Each of these lists starts a new
+	// file in the book, see epub.ContentPath.
+	Part int
+
 	Counters map[string]*counterInfo
 	Macros   map[string]macro
 	Envs     map[string]*environment
 	EnvStack []string
+	Lists    []*listInfo
+	Floats   []*floatInfo
+
+	// GraphicsPath lists the directories set by \graphicspath.
+	GraphicsPath []string
+
+	// MathOutput is either mathImages or mathMathML.
+	MathOutput string
+
+	// Pos is the position of the macro currently being converted.
+	Pos scanner.Pos
+
+	// NoFootnotes is set while text is converted a second time, like
+	// the captions shown in a list of figures.  Footnotes are omitted
+	// from such text.
+	NoFootnotes bool
 
 	PkgState map[string]string
 
@@ -46,6 +79,10 @@ type converter struct {
 }
 
 func newConverter(book *epub.Book) (*converter, error) {
+	if *mathOutput != mathImages && *mathOutput != mathMathML {
+		return nil, fmt.Errorf("invalid value %q for -latex-math", *mathOutput)
+	}
+
 	workDir, err := ioutil.TempDir("", "jvepla")
 	if err != nil {
 		return nil, err
@@ -59,6 +96,8 @@ func newConverter(book *epub.Book) (*converter, error) {
 		Counters: make(map[string]*counterInfo),
 
 		PkgState: make(map[string]string),
+
+		MathOutput: *mathOutput,
 	}
 	conv.addBuiltinMacros()
 	return conv, nil
@@ -69,8 +108,13 @@ func (conv *converter) Close() error {
 }
 
 // Convert read the given LaTeX input file, converts the contents to
-// EPUB format and writes the result to `book`.
-func Convert(book *epub.Book, inputFileName string) (err error) {
+// EPUB format and writes the result to `book`.  Cancelling ctx aborts
+// the conversion; in this case the returned error is ctx.Err(), and
+// the book is incomplete and should not be closed.  If some images
+// could not be rendered, the book is still written, with the TeX
+// source shown in place of the missing images, and the returned error
+// is of type render.Errors.
+func Convert(ctx context.Context, book *epub.Book, inputFileName string) (err error) {
 	conv, err := newConverter(book)
 	if err != nil {
 		return err
@@ -89,11 +133,15 @@ func Convert(book *epub.Book, inputFileName string) (err error) {
 	}
 
 	log.Println("pass 1 ...")
-	err = conv.Pass1()
+	err = conv.Pass1(ctx)
 	if err != nil {
 		return err
 	}
 
 	log.Println("pass 2 ...")
-	return conv.Pass2()
+	err = conv.Pass2()
+	if err != nil {
+		return err
+	}
+	return conv.RenderErrors.Err()
 }
@@ -0,0 +1,214 @@
+// convert_test.go - end-to-end tests for the LaTeX to EPUB conversion
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/seehuhn/epublatex/epub"
+)
+
+// convertString converts the LaTeX document `src` into an XHTML
+// book, using the fake TeX engine to render images.  The result maps
+// the names of the XHTML files to their contents.
+func convertString(t *testing.T, src string) (map[string]string, error) {
+	return convertStringContext(context.Background(), t, src)
+}
+
+// convertStringContext is like convertString, but uses the given
+// context for the conversion.
+func convertStringContext(ctx context.Context, t *testing.T, src string) (map[string]string, error) {
+	tmp, err := ioutil.TempDir("", "epublatex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for name, value := range map[string]string{
+		"latex-engine": "fake",
+		"cache-dir":    filepath.Join(tmp, "cache"),
+	} {
+		old := flag.Lookup(name).Value.String()
+		flag.Set(name, value)
+		defer flag.Set(name, old)
+	}
+
+	inName := filepath.Join(tmp, "doc.tex")
+	err = ioutil.WriteFile(inName, []byte(src), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outDir := filepath.Join(tmp, "out")
+	book, err := epub.NewXhtmlWriter(outDir, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	convErr := Convert(ctx, book, inName)
+	if convErr == nil {
+		err = book.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(outDir, "*.xhtml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := make(map[string]string)
+	for _, fileName := range files {
+		body, err := ioutil.ReadFile(fileName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res[filepath.Base(fileName)] = string(body)
+	}
+	return res, convErr
+}
+
+func TestFootnoteMaths(t *testing.T) {
+	src := `\documentclass{article}
+\begin{document}
+Text.\footnote{A note about $x$.}
+\end{document}
+`
+	files, err := convertString(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := files["front.xhtml"]
+	if !strings.Contains(body, `class="imath" alt="x"`) {
+		t.Errorf("formula in footnote not rendered:\n%s", body)
+	}
+	if strings.Contains(body, "latex-texerror") {
+		t.Errorf("formula in footnote failed:\n%s", body)
+	}
+}
+
+func TestCrossReferenceFiles(t *testing.T) {
+	src := `\documentclass{article}
+\begin{document}
+See section~\ref{two} and figure~\ref{fig}.
+\listoffigures
+Text after the list.
+\section{One}\label{one}
+\listoftables
+\begin{figure}
+\caption{A figure}\label{fig}
+\end{figure}
+\section{Two}\label{two}
+Back to section~\ref{one}.
+\end{document}
+`
+	files, err := convertString(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := regexp.MustCompile(`href="([^"#]*)#([^"]*)"`)
+	count := 0
+	for name, body := range files {
+		for _, m := range link.FindAllStringSubmatch(body, -1) {
+			target, ok := files[m[1]]
+			if !ok {
+				t.Errorf("%s: link to missing file %s", name, m[1])
+			} else if !strings.Contains(target, `id="`+m[2]+`"`) {
+				t.Errorf("%s: link target %s#%s not found", name, m[1], m[2])
+			}
+			count++
+		}
+	}
+	if count < 4 {
+		t.Errorf("only %d links found", count)
+	}
+}
+
+func TestConvertCancel(t *testing.T) {
+	src := `\documentclass{article}
+\begin{document}
+\section{One}
+Text with a formula $x$.
+\end{document}
+`
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	files, err := convertStringContext(ctx, t, src)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("wrong error %v", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("book written after cancellation")
+	}
+}
+
+func TestCaptionFootnote(t *testing.T) {
+	src := `\documentclass{article}
+\begin{document}
+\section{One}
+\begin{figure}
+\caption{A figure\footnote{caption note}}
+\end{figure}
+\listoffigures
+Text\footnote{text note}.
+\end{document}
+`
+	files, err := convertString(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, body := range files {
+		count += strings.Count(body, "caption note")
+	}
+	if count != 1 {
+		t.Errorf("caption footnote shown %d times", count)
+	}
+	if !strings.Contains(files["ch1-1.xhtml"], `>2</a> text note`) {
+		t.Errorf("wrong footnote number:\n%s", files["ch1-1.xhtml"])
+	}
+}
+
+func TestTitleFootnote(t *testing.T) {
+	src := `\documentclass{article}
+\begin{document}
+\section{One}
+Text\footnote{first note}.
+\section{Two\footnote{title note}}
+\subsection{Three\footnote{subsection note}}
+More text.
+\end{document}
+`
+	files, err := convertString(t, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(files["ch1.xhtml"], "title note") {
+		t.Error("title footnote added to the previous chapter")
+	}
+	for _, note := range []string{`>1</a> title note`, `>2</a> subsection note`} {
+		if !strings.Contains(files["ch2.xhtml"], note) {
+			t.Errorf("%q not found:\n%s", note, files["ch2.xhtml"])
+		}
+	}
+}
@@ -22,7 +22,9 @@ func (conv *converter) resetCounters(level int, name string) {
 	for _, ctr := range conv.Counters {
 		if ctr.Parent == name {
 			ctr.Value = 0
-			ctr.Prefix = conv.Section[:level].String() + "."
+			if !ctr.NoPrefix {
+				ctr.Prefix = conv.Section[:level].String() + "."
+			}
 		}
 	}
 }
@@ -31,6 +33,10 @@ type counterInfo struct {
 	Value  int
 	Parent string
 	Prefix string
+
+	// NoPrefix indicates that the counter is reset together with
+	// the parent counter, but the number of the parent is not shown.
+	NoPrefix bool
 }
 
 func (ci *counterInfo) Inc() string {
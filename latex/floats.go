@@ -0,0 +1,84 @@
+// floats.go - figure and table environments
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"strings"
+
+	"github.com/seehuhn/epublatex/epub"
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+// floatTypes gives the prefix used in captions and cross-references
+// for each kind of LaTeX float.  The float type is also the name of
+// the counter used to number the floats.
+var floatTypes = map[string]string{
+	"figure": "Figure",
+	"table":  "Table",
+}
+
+// floatType returns the kind of float started by the environment
+// `envName`, or the empty string if the environment is not a float.
+func floatType(envName string) string {
+	name := strings.TrimSuffix(envName, "*")
+	if floatTypes[name] == "" {
+		return ""
+	}
+	return name
+}
+
+// floatInfo describes a float which has a caption.  The information
+// is collected in pass 1.
+type floatInfo struct {
+	Type    string
+	Pos     int
+	Chapter int
+	Part    int
+	Name    string
+	Caption tokenizer.TokenList
+}
+
+// findFloat returns the float which starts at token position `pos`.
+func (conv *converter) findFloat(pos int) *floatInfo {
+	for _, info := range conv.Floats {
+		if info.Pos == pos {
+			return info
+		}
+	}
+	return nil
+}
+
+// floatList returns the entries for a list of figures or tables.
+// Footnotes in the captions are only shown next to the floats.
+func (conv *converter) floatList(floatType string) []epub.ListEntry {
+	conv.NoFootnotes = true
+	defer func() { conv.NoFootnotes = false }()
+
+	var res []epub.ListEntry
+	for _, info := range conv.Floats {
+		if info.Type != floatType {
+			continue
+		}
+		res = append(res, epub.ListEntry{
+			Label: info.Name,
+			Title: conv.convertHTML(info.Caption),
+			Path:  epub.ContentPath(info.Chapter, info.Part),
+			ID:    conv.posID(info.Pos),
+		})
+	}
+	return res
+}
@@ -0,0 +1,77 @@
+// floats_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"context"
+	"testing"
+
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+func TestFloats(t *testing.T) {
+	src := `\documentclass{article}
+\begin{document}
+\section{A}
+\begin{figure}\caption{x}\label{a}\end{figure}
+\begin{table}\caption[short]{y}\label{b}\end{table}
+\begin{figure}\caption{z}\end{figure}
+\section{B}
+\begin{figure}\caption{w}\label{c}\end{figure}
+\end{document}`
+
+	conv, err := newConverter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conv.Close()
+
+	toks := tokenizer.NewTokenizer()
+	defer toks.Close()
+	toks.Prepend([]byte(src), "test")
+	err = conv.runTokenizer(toks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = conv.Pass1(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{"1.1", "1.1", "1.2", "2.1"}
+	if len(conv.Floats) != len(names) {
+		t.Fatalf("wrong number of floats: %d", len(conv.Floats))
+	}
+	for i, info := range conv.Floats {
+		if info.Name != names[i] {
+			t.Errorf("float %d: wrong number %q", i, info.Name)
+		}
+	}
+	if caption := conv.Floats[1].Caption.FormatText(); caption != "short" {
+		t.Errorf("wrong short caption %q", caption)
+	}
+
+	refs := map[string]string{"a": "1.1", "b": "1.1", "c": "2.1"}
+	for _, label := range conv.Labels {
+		if refs[label.Label] != label.Name {
+			t.Errorf("label %s: wrong reference %q", label.Label, label.Name)
+		}
+		if label.Label == "b" && label.Type != "Table" {
+			t.Errorf("label b: wrong type %q", label.Type)
+		}
+	}
+}
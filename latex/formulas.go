@@ -0,0 +1,75 @@
+// formulas.go - choose between images and MathML for formulas
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"flag"
+
+	"github.com/seehuhn/epublatex/latex/mathml"
+	"github.com/seehuhn/epublatex/latex/scanner"
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+// Values for the -latex-math command line option.
+const (
+	mathImages = "images"
+	mathMathML = "mathml"
+)
+
+var mathOutput = flag.String("latex-math", mathImages,
+	"how to show formulas in the book, either \""+mathImages+
+		"\" or \""+mathMathML+"\"")
+
+// needsImage reports whether the formula must be rendered as an
+// image.  This is called in pass 1; formulas which cannot be
+// translated into MathML cause a warning.
+func (conv *converter) needsImage(pos scanner.Pos, env string, formula tokenizer.TokenList) bool {
+	if conv.MathOutput != mathMathML {
+		return true
+	}
+	_, err := mathml.Convert(env, formula)
+	if err != nil {
+		warn(pos, "%s, using an image instead", err)
+		return true
+	}
+	return false
+}
+
+// formulaHTML returns the HTML code for a formula.  This is called in
+// pass 2.
+func (conv *converter) formulaHTML(pos scanner.Pos, env string, formula tokenizer.TokenList) string {
+	if conv.MathOutput == mathMathML {
+		res, err := mathml.Convert(env, formula)
+		if err == nil {
+			return res
+		}
+	}
+	return conv.GetImage(pos, env, renderSource(formula))
+}
+
+// renderSource returns the TeX code used to render a formula as an
+// image.  This is the formula as written by the author, unless the
+// formula uses macros defined in the document or conditionals: the
+// renderer does not know about these, so the expanded form is used
+// instead.  The result is also used as the image body, to find the
+// image in pass 2.
+func renderSource(formula tokenizer.TokenList) string {
+	if formula.HasExpansions() {
+		return formula.FormatMaths()
+	}
+	return formula.Source()
+}
@@ -0,0 +1,81 @@
+// graphics.go - locate the files included by \includegraphics
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"html"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/seehuhn/epublatex/latex/graphics"
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+// findGraphics locates the file for \includegraphics{name}.  Like
+// LaTeX, the method tries the directory of the main document, followed
+// by the directories given by \graphicspath.  For names without an
+// extension, the extensions from graphics.Extensions are tried in
+// order.
+func (conv *converter) findGraphics(name string) (string, error) {
+	exts := []string{""}
+	if filepath.Ext(name) == "" {
+		exts = graphics.Extensions
+	}
+	dirs := append([]string{""}, conv.GraphicsPath...)
+	for _, ext := range exts {
+		for _, dir := range dirs {
+			path := filepath.Join(dir, name+ext)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(conv.SourceDir, path)
+			}
+			fi, err := os.Stat(path)
+			if err == nil && !fi.IsDir() {
+				return path, nil
+			}
+		}
+	}
+	return "", &os.PathError{
+		Op:   "\\includegraphics",
+		Path: name,
+		Err:  os.ErrNotExist,
+	}
+}
+
+func mGraphicspath(args []*tokenizer.Arg, conv *converter) string {
+	var dirs []string
+	rest := args[0].String()
+	for strings.TrimSpace(rest) != "" {
+		var dir string
+		dir, rest = nextGroup(rest)
+		dirs = append(dirs, dir)
+	}
+	conv.GraphicsPath = dirs
+	return ""
+}
+
+func mIncludegraphics(args []*tokenizer.Arg, conv *converter) string {
+	options := args[0].String()
+	name := args[1].String()
+	path, err := conv.findGraphics(name)
+	if err != nil {
+		warn(conv.Pos, "%s", err)
+		return `<span class="error">` + html.EscapeString(name) + `</span>`
+	}
+	return conv.GetImage(conv.Pos, "includegraphics",
+		graphics.Key(path, options))
+}
@@ -0,0 +1,115 @@
+// options.go - interpret the options of \includegraphics
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// imageSize holds the size requested in the options of
+// \includegraphics.  Width and Height are CSS lengths.
+type imageSize struct {
+	Width  string
+	Height string
+	Scale  float64
+}
+
+var (
+	relLength = regexp.MustCompile(
+		`^([0-9]*\.?[0-9]*)\s*\\(textwidth|linewidth|columnwidth|hsize)$`)
+	absLength = regexp.MustCompile(
+		`^([0-9]*\.?[0-9]+)\s*(cm|mm|in|pt|bp|pc|em|ex)$`)
+)
+
+// parseOptions interprets the key=value options of \includegraphics.
+// Options which do not affect the size of the image are ignored.
+func parseOptions(options string) (*imageSize, error) {
+	size := &imageSize{}
+	for _, option := range strings.Split(options, ",") {
+		kv := strings.SplitN(option, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		var value string
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+
+		var err error
+		switch key {
+		case "width":
+			size.Width, err = cssLength(value)
+		case "height", "totalheight":
+			size.Height, err = cssLength(value)
+		case "scale":
+			size.Scale, err = strconv.ParseFloat(value, 64)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid \\includegraphics option %q",
+				option)
+		}
+	}
+	return size, nil
+}
+
+// cssLength converts a LaTeX length into a CSS length.  Lengths
+// relative to the text width are converted into percentages.
+func cssLength(length string) (string, error) {
+	if m := relLength.FindStringSubmatch(length); m != nil {
+		factor := 1.0
+		if m[1] != "" {
+			var err error
+			factor, err = strconv.ParseFloat(m[1], 64)
+			if err != nil {
+				return "", err
+			}
+		}
+		return formatFloat(100*factor) + "%", nil
+	}
+	if m := absLength.FindStringSubmatch(length); m != nil {
+		unit := m[2]
+		if unit == "bp" {
+			// TeX big points are the same as CSS points
+			unit = "pt"
+		}
+		return m[1] + unit, nil
+	}
+	return "", fmt.Errorf("unsupported length %q", length)
+}
+
+// style returns the CSS style for an image with the given natural
+// width (in inches).  If no size is requested, the natural width is
+// used only if `useNatural` is set.
+func (size *imageSize) style(naturalWidth float64, useNatural bool) string {
+	switch {
+	case size.Width != "":
+		return "width: " + size.Width
+	case size.Height != "":
+		return "height: " + size.Height
+	case size.Scale > 0:
+		return "width: " + formatFloat(size.Scale*naturalWidth) + "in"
+	case useNatural:
+		return "width: " + formatFloat(naturalWidth) + "in"
+	}
+	return ""
+}
+
+func formatFloat(x float64) string {
+	s := strconv.FormatFloat(x, 'f', 2, 64)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
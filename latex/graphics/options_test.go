@@ -0,0 +1,70 @@
+// options_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package graphics
+
+import "testing"
+
+func TestCSSLength(t *testing.T) {
+	testCases := []struct {
+		in, out string
+	}{
+		{`\textwidth`, "100%"},
+		{`0.5\linewidth`, "50%"},
+		{`.25\columnwidth`, "25%"},
+		{"3cm", "3cm"},
+		{"2.5 in", "2.5in"},
+		{"10bp", "10pt"},
+	}
+	for _, test := range testCases {
+		out, err := cssLength(test.in)
+		if err != nil {
+			t.Errorf("%q: %s", test.in, err)
+		} else if out != test.out {
+			t.Errorf("%q: expected %q, got %q", test.in, test.out, out)
+		}
+	}
+
+	_, err := cssLength(`\foo`)
+	if err == nil {
+		t.Error("invalid length not detected")
+	}
+}
+
+func TestStyle(t *testing.T) {
+	testCases := []struct {
+		options    string
+		useNatural bool
+		style      string
+	}{
+		{"", false, ""},
+		{"", true, "width: 2in"},
+		{"width=3cm", true, "width: 3cm"},
+		{"height=1in, keepaspectratio", false, "height: 1in"},
+		{"scale=0.5", false, "width: 1in"},
+	}
+	for _, test := range testCases {
+		size, err := parseOptions(test.options)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		style := size.style(2, test.useNatural)
+		if style != test.style {
+			t.Errorf("%q: expected %q, got %q", test.options, test.style, style)
+		}
+	}
+}
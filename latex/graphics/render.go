@@ -0,0 +1,216 @@
+// render.go - include graphics files in the book
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package graphics converts the files included by \includegraphics
+// into images which can be shown by e-readers.  PNG and JPEG files
+// are copied unchanged, PDF and EPS files are rasterised using
+// pdflatex and Ghostscript.
+package graphics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the JPEG decoder
+	_ "image/png"  // register the PNG decoder
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/seehuhn/epublatex/latex/render"
+)
+
+const (
+	renderRes = 300 // render resolution [pixels / inch]
+
+	// rasterRes is the resolution assumed by pdflatex for raster
+	// images [pixels / inch].
+	rasterRes = 72
+)
+
+// Extensions lists the file name extensions tried by \includegraphics
+// for file names without an extension, in the order used by pdflatex.
+var Extensions = []string{
+	".pdf", ".png", ".jpg", ".mps", ".jpeg",
+	".PDF", ".PNG", ".JPG", ".JPEG", ".eps",
+}
+
+// Renderer converts graphics files into images for the book.
+type Renderer struct {
+	out chan<- *render.BookImage
+
+	seen map[string]bool
+
+	queue    *render.Queue
+	children *sync.WaitGroup
+
+	mutex    sync.Mutex
+	failures render.Errors
+
+	tmpl *template.Template
+}
+
+// NewRenderer creates a new Renderer.  Files are converted using the
+// given queue, which may be shared with other renderers, and the
+// images are written to the channel `out`.
+func NewRenderer(out chan<- *render.BookImage, queue *render.Queue) (*Renderer, error) {
+	r := &Renderer{
+		out:      out,
+		seen:     make(map[string]bool),
+		queue:    queue,
+		children: &sync.WaitGroup{},
+	}
+
+	tmpl, err := template.New("graphics").Parse(graphicsTemplate)
+	if err != nil {
+		return nil, err
+	}
+	r.tmpl = tmpl
+
+	return r, nil
+}
+
+// Finish must be called after the last image has been added.  The
+// method waits until all images have been delivered.  The queue is
+// not shut down, since it may be used by other renderers.  If some
+// files could not be converted, the returned error is of type
+// render.Errors and lists all these files.
+func (r *Renderer) Finish() error {
+	r.children.Wait()
+	return r.failures.Err()
+}
+
+// Key returns the string used as the image body for the graphics
+// file `path`, included with the given \includegraphics options.
+func Key(path, options string) string {
+	return options + "%" + path
+}
+
+// AddImage adds the graphics file `path` to the book.  The argument
+// `options` gives the optional argument of \includegraphics, which
+// determines the size of the image.  The context is used for the
+// conversion job started by the call, if any.
+func (r *Renderer) AddImage(ctx context.Context, path, options string) error {
+	key := Key(path, options)
+	if r.seen[key] {
+		// avoid including the same image twice
+		return nil
+	}
+	r.seen[key] = true
+
+	size, err := parseOptions(options)
+	if err != nil {
+		return err
+	}
+
+	info := &imageInfo{
+		key:  key,
+		path: path,
+		size: size,
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		return r.copyFile(info, render.BookImageTypePNG)
+	case ".jpg", ".jpeg":
+		return r.copyFile(info, render.BookImageTypeJPG)
+	}
+
+	in, errc := r.queue.Submit(ctx, renderRes, r.tmpl, filepath.ToSlash(path))
+
+	r.children.Add(1)
+	go func(info *imageInfo) {
+		img := <-in
+		for range in {
+			// Submit delivers at most one image
+		}
+		err := <-errc
+		if img == nil || err != nil {
+			if err == nil {
+				err = errors.New("cannot convert " + info.path)
+			}
+			r.mutex.Lock()
+			r.failures = append(r.failures,
+				fmt.Errorf("graphics file %q: %w", info.path, err))
+			r.mutex.Unlock()
+			r.out <- &render.BookImage{
+				Env:  "includegraphics",
+				Body: info.key,
+
+				CssClass: "includegraphics",
+
+				Err:    err,
+				Source: filepath.Base(info.path),
+			}
+		} else {
+			dx := img.Bounds().Dx()
+			r.out <- &render.BookImage{
+				Env:  "includegraphics",
+				Body: info.key,
+
+				Alt:      filepath.Base(info.path),
+				CssClass: "includegraphics",
+				Style:    info.size.style(float64(dx)/renderRes, true),
+
+				Image: img,
+				Type:  render.BookImageTypePNG,
+			}
+		}
+		r.children.Done()
+	}(info)
+	return nil
+}
+
+func (r *Renderer) copyFile(info *imageInfo, imgType render.BookImageType) error {
+	data, err := ioutil.ReadFile(info.path)
+	if err != nil {
+		return err
+	}
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%s: %s", info.path, err)
+	}
+
+	r.out <- &render.BookImage{
+		Env:  "includegraphics",
+		Body: info.key,
+
+		Alt:      filepath.Base(info.path),
+		CssClass: "includegraphics",
+		Style:    info.size.style(float64(cfg.Width)/rasterRes, false),
+
+		Type: imgType,
+		Data: data,
+	}
+	return nil
+}
+
+type imageInfo struct {
+	key  string
+	path string
+	size *imageSize
+}
+
+const graphicsTemplate = `\documentclass{standalone}
+\usepackage{graphicx}
+\begin{document}
+\includegraphics{ {{- . -}} }
+\end{document}
+`
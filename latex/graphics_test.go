@@ -0,0 +1,70 @@
+// graphics_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindGraphics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epubtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := []string{"a.png", "a.pdf", "b.png", filepath.Join("pics", "c.jpg")}
+	err = os.Mkdir(filepath.Join(dir, "pics"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conv := &converter{
+		SourceDir:    dir,
+		GraphicsPath: []string{"pics/"},
+	}
+	testCases := []struct {
+		name, path string
+	}{
+		{"a", "a.pdf"},
+		{"a.png", "a.png"},
+		{"b", "b.png"},
+		{"c", filepath.Join("pics", "c.jpg")},
+	}
+	for _, test := range testCases {
+		path, err := conv.findGraphics(test.name)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if path != filepath.Join(dir, test.path) {
+			t.Errorf("%s: wrong path %q", test.name, path)
+		}
+	}
+
+	_, err = conv.findGraphics("d")
+	if !os.IsNotExist(err) {
+		t.Error("missing file not detected", err)
+	}
+}
@@ -23,19 +23,22 @@ import (
 	"image/jpeg"
 	"image/png"
 	"io"
-	"log"
 	"strings"
 
 	"golang.org/x/crypto/sha3"
 
 	"github.com/seehuhn/epublatex/latex/render"
+	"github.com/seehuhn/epublatex/latex/scanner"
 )
 
-func (conv *converter) GetImage(env, body string) string {
+func (conv *converter) GetImage(pos scanner.Pos, env, body string) string {
 	key := env + "%" + body
 	res, ok := conv.Images[key]
 	if !ok {
-		log.Printf("missing image for body %q", body)
+		warn(pos, "missing image for body %q", body)
+	}
+	if err := conv.ImageErrors[key]; err != nil {
+		warn(pos, "cannot render %s: %s", env, err)
 	}
 	return res
 }
@@ -44,12 +47,25 @@ func (conv *converter) GetImage(env, body string) string {
 func (conv *converter) imageAdder(in <-chan *render.BookImage, res chan<- error) {
 	var firstError error
 	for job := range in {
+		if job.Err != nil {
+			// show the TeX source instead of the image
+			key := job.Env + "%" + job.Body
+			conv.ImageErrors[key] = job.Err
+			conv.Images[key] = `<code class="latex-texerror ` +
+				html.EscapeString(job.CssClass) + `">` +
+				html.EscapeString(job.Source) + `</code>`
+			continue
+		}
+
 		var mime string
 		var enc func(w io.Writer, m image.Image) error
 		switch job.Type {
 		case render.BookImageTypePNG:
 			mime = "image/png"
 			enc = png.Encode
+		case render.BookImageTypeSVG:
+			// SVG images are always given as Data
+			mime = "image/svg+xml"
 		default:
 			mime = "image/jpeg"
 			enc = func(w io.Writer, m image.Image) error {
@@ -72,7 +88,11 @@ func (conv *converter) imageAdder(in <-chan *render.BookImage, res chan<- error)
 			firstError = firstOf(firstError, err)
 			continue
 		}
-		err = enc(w, job.Image)
+		if job.Data != nil {
+			_, err = w.Write(job.Data)
+		} else {
+			err = enc(w, job.Image)
+		}
 		if err != nil {
 			firstError = firstOf(firstError, err)
 			continue
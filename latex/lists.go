@@ -0,0 +1,120 @@
+// lists.go - itemize, enumerate and description lists
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"strconv"
+	"strings"
+)
+
+// listTags gives the HTML element used for each LaTeX list
+// environment.
+var listTags = map[string]string{
+	"itemize":     "ul",
+	"enumerate":   "ol",
+	"description": "dl",
+}
+
+type listInfo struct {
+	Env   string
+	Count int
+}
+
+func (conv *converter) startList(env string) {
+	conv.Lists = append(conv.Lists, &listInfo{Env: env})
+}
+
+func (conv *converter) endList() {
+	n := len(conv.Lists)
+	if n > 0 {
+		conv.Lists = conv.Lists[:n-1]
+	}
+}
+
+// nextItem advances the counter of the innermost list.  The method
+// returns the label to show for the item, and the text used by \ref
+// to refer to the item.  Items with a custom label do not advance the
+// counter, and the returned reference text is empty for these, as
+// well as for items outside enumerate lists.
+func (conv *converter) nextItem(customLabel bool) (string, string) {
+	n := len(conv.Lists)
+	if n == 0 || customLabel {
+		return "", ""
+	}
+	list := conv.Lists[n-1]
+	if list.Env != "enumerate" {
+		return "", ""
+	}
+	list.Count++
+
+	var counts []int
+	for _, l := range conv.Lists {
+		if l.Env == "enumerate" {
+			counts = append(counts, l.Count)
+		}
+	}
+	return enumLabel(counts)
+}
+
+// enumLabel returns the label and the \ref text for an item in nested
+// enumerate lists, using the same conventions as LaTeX.  The argument
+// lists the item numbers, starting with the outermost enumerate list.
+func enumLabel(counts []int) (string, string) {
+	var label string
+	var ref []string
+	for i, count := range counts {
+		switch i {
+		case 0:
+			label = strconv.Itoa(count)
+			ref = append(ref, label)
+			label = label + "."
+		case 1:
+			label = string(rune('a' + (count-1)%26))
+			ref = append(ref, label)
+			label = "(" + label + ")"
+		case 2:
+			label = romanNumeral(count)
+			ref[1] = "(" + ref[1] + ")"
+			ref = append(ref, label)
+			label = label + "."
+		default:
+			label = string(rune('A' + (count-1)%26))
+			ref = append(ref, label)
+			label = label + "."
+		}
+	}
+	return label, strings.Join(ref, "")
+}
+
+func romanNumeral(n int) string {
+	digits := []struct {
+		value int
+		text  string
+	}{
+		{1000, "m"}, {900, "cm"}, {500, "d"}, {400, "cd"},
+		{100, "c"}, {90, "xc"}, {50, "l"}, {40, "xl"},
+		{10, "x"}, {9, "ix"}, {5, "v"}, {4, "iv"}, {1, "i"},
+	}
+	var res []string
+	for _, d := range digits {
+		for n >= d.value {
+			res = append(res, d.text)
+			n -= d.value
+		}
+	}
+	return strings.Join(res, "")
+}
@@ -0,0 +1,54 @@
+// lists_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import "testing"
+
+func TestEnumLabel(t *testing.T) {
+	testCases := []struct {
+		counts     []int
+		label, ref string
+	}{
+		{[]int{1}, "1.", "1"},
+		{[]int{12}, "12.", "12"},
+		{[]int{1, 2}, "(b)", "1b"},
+		{[]int{3, 1, 4}, "iv.", "3(a)iv"},
+		{[]int{1, 1, 9, 2}, "B.", "1(a)ixB"},
+	}
+	for _, testCase := range testCases {
+		label, ref := enumLabel(testCase.counts)
+		if label != testCase.label || ref != testCase.ref {
+			t.Errorf("%v: expected %q/%q, got %q/%q", testCase.counts,
+				testCase.label, testCase.ref, label, ref)
+		}
+	}
+}
+
+func TestRomanNumeral(t *testing.T) {
+	testCases := map[int]string{
+		1:    "i",
+		4:    "iv",
+		9:    "ix",
+		14:   "xiv",
+		1994: "mcmxciv",
+	}
+	for n, expected := range testCases {
+		if got := romanNumeral(n); got != expected {
+			t.Errorf("%d: expected %q, got %q", n, expected, got)
+		}
+	}
+}
@@ -18,9 +18,8 @@ package latex
 
 import (
 	"html"
-	"log"
-	"strconv"
 
+	"github.com/seehuhn/epublatex/epub"
 	"github.com/seehuhn/epublatex/latex/tokenizer"
 )
 
@@ -47,10 +46,13 @@ func (conv *converter) addBuiltinMacros() {
 	conv.Macros["\\epubtitle"] = funcMacro(mEpubTitle)
 
 	// built-in special macros
+	conv.Macros["%tabular%"] = funcMacro(mTabular)
 	conv.Macros["%verbatim%"] = funcMacro(mVerbatim)
 
 	// TeX/LaTeX macros
+	conv.Macros["\\centering"] = mIgnore
 	conv.Macros["\\documentclass"] = mIgnore
+	conv.Macros["\\footnote"] = funcMacro(mFootnote)
 	conv.Macros["\\label"] = mIgnore // handled during pass 1
 	conv.Macros["\\ref"] = funcMacro(mRef)
 	conv.Macros["\\usepackage"] = funcMacro(mUsePackage)
@@ -68,6 +70,12 @@ func (conv *converter) addBuiltinMacros() {
 	}
 
 	conv.Counters["base@equation"] = &counterInfo{}
+	conv.Counters["figure"] = &counterInfo{Parent: "section"}
+	conv.Counters["table"] = &counterInfo{Parent: "section"}
+	conv.Counters["footnote"] = &counterInfo{
+		Parent:   "section",
+		NoPrefix: true,
+	}
 	conv.Envs["equation"] = &environment{
 		Prefix:     "Equation",
 		Counter:    "base@equation",
@@ -95,14 +103,33 @@ func mRef(args []*tokenizer.Arg, conv *converter) string {
 	target := args[0].String()
 	for _, label := range conv.Labels {
 		if label.Label == target {
-			// TODO(voss): make this more robust
-			fname := "ch" + strconv.Itoa(label.Chapter) + ".xhtml"
+			fname := epub.ContentPath(label.Chapter, label.Part)
 			return `<a href="` + fname + `#` + label.ID + `">` + label.Name + `</a>`
 		}
 	}
 	return `<span class="error">` + html.EscapeString(target) + `</span>`
 }
 
+func mFootnote(args []*tokenizer.Arg, conv *converter) string {
+	if conv.NoFootnotes {
+		return ""
+	}
+	var label string
+	if len(args[0].Value) > 0 {
+		label = conv.convertHTML(args[0].Value)
+	} else {
+		label = conv.Counters["footnote"].Inc()
+	}
+	pos := conv.Pos
+	body := conv.convertHTML(args[1].Value)
+	ref, err := conv.Book.AddFootnote(label, body)
+	if err != nil {
+		warn(pos, "cannot add footnote: %s", err)
+		return ""
+	}
+	return ref
+}
+
 func mVerb(args []*tokenizer.Arg, conv *converter) string {
 	body := args[0].String()
 	return `<span class="latex-verb">` + html.EscapeString(body) + `</span>`
@@ -115,7 +142,7 @@ func mUsePackage(args []*tokenizer.Arg, conv *converter) string {
 	if installFn != nil {
 		installFn(conv, options)
 	} else {
-		log.Printf("unknown package %q (options %q)", pkgName, options)
+		warn(conv.Pos, "unknown package %q (options %q)", pkgName, options)
 	}
 	return ""
 }
@@ -3,9 +3,16 @@ package math
 import (
 	"image"
 	"image/draw"
+
+	"github.com/seehuhn/epublatex/latex/render"
 )
 
-func cropInline(imgIn image.Image) image.Image {
+// cropInline crops the image of an inline formula.  Vertically, the
+// result covers both the visible parts of the formula and the TeX box
+// `box` (which may be nil).  The baseline marker on the left is kept,
+// so that the baseline can be located by splitMarker(), also for
+// images retrieved from the cache.
+func cropInline(imgIn image.Image, box *render.Box) image.Image {
 	b := imgIn.Bounds()
 	imgOut := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
 	draw.Draw(imgOut, imgOut.Bounds(), imgIn, b.Min, draw.Src)
@@ -25,51 +32,53 @@ func cropInline(imgIn image.Image) image.Image {
 		}
 		y1++
 	}
-	yMid := (y0 + y1) / 2
+	baseline := y1 + 1
 
-	// find the width of the marker
-	xMin := 0
-	for imgOut.Pix[imgOut.PixOffset(xMin, yMid)+3] != 0 {
-		xMin++
+	// find the width of the marker, and the start of the formula
+	xMarker := 0
+	for imgOut.Pix[imgOut.PixOffset(xMarker, y1)+3] != 0 {
+		xMarker++
 	}
 
-	// find the top-most row of pixels used
-	idx := 0
-	for imgOut.Pix[idx+3] == 0 {
-		idx += 4
+	// find the top-most and bottom-most rows used by the formula
+	yMin := baseline - 1
+	yMax := baseline
+	for y := 0; y < imgOut.Rect.Max.Y; y++ {
+		for x := xMarker; x < imgOut.Rect.Max.X; x++ {
+			if imgOut.Pix[imgOut.PixOffset(x, y)+3] != 0 {
+				if y < yMin {
+					yMin = y
+				}
+				if y >= yMax {
+					yMax = y + 1
+				}
+				break
+			}
+		}
 	}
-	yMin := idx / imgOut.Stride
 
-	// find the bottom-most row of pixels used
-	idx = imgOut.Rect.Max.Y*imgOut.Stride - 4
-	for imgOut.Pix[idx+3] == 0 {
-		idx -= 4
+	// extend to the TeX box
+	if box != nil {
+		top := baseline - int(box.Height*pixPerPt+0.5)
+		if top < yMin {
+			yMin = top
+		}
+		bottom := baseline + int(box.Depth*pixPerPt+0.5)
+		if bottom > yMax {
+			yMax = bottom
+		}
 	}
-	yMax := idx/imgOut.Stride + 1
-
-	// Centre the crop window vertically.
-	if y0-yMin > yMax-1-y1 {
-		yMax = y0 + y1 - yMin + 1
-	} else {
-		yMin = y0 + y1 - yMax + 1
+	if yMin < 0 {
+		yMin = 0
 	}
-
-	// crop left
-leftLoop:
-	for xMin < imgOut.Rect.Max.X {
-		for y := yMin; y < yMax; y++ {
-			idx := imgOut.PixOffset(xMin, y)
-			if imgOut.Pix[idx+3] != 0 {
-				break leftLoop
-			}
-		}
-		xMin++
+	if yMax > imgOut.Rect.Max.Y {
+		yMax = imgOut.Rect.Max.Y
 	}
 
 	// crop right
 	xMax := imgOut.Rect.Max.X
 rightLoop:
-	for xMax > xMin {
+	for xMax > xMarker {
 		for y := yMin; y < yMax; y++ {
 			idx := imgOut.PixOffset(xMax-1, y)
 			if imgOut.Pix[idx+3] != 0 {
@@ -80,12 +89,51 @@ rightLoop:
 	}
 
 	crop := image.Rectangle{
-		Min: image.Point{xMin, yMin},
+		Min: image.Point{0, yMin},
 		Max: image.Point{xMax, yMax},
 	}
 	return imgOut.SubImage(crop)
 }
 
+// splitMarker removes the baseline marker from an image produced by
+// cropInline().  The function returns the image of the formula,
+// together with the distance of the bottom edge of the image below
+// the baseline, in pixels.
+func splitMarker(imgIn image.Image) (image.Image, int) {
+	b := imgIn.Bounds()
+	img := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(img, img.Bounds(), imgIn, b.Min, draw.Src)
+
+	// the baseline is at the bottom of the marker
+	baseline := img.Rect.Max.Y
+	for baseline > 0 && img.Pix[img.PixOffset(0, baseline-1)+3] == 0 {
+		baseline--
+	}
+	if baseline == 0 {
+		// no marker found
+		return img, 0
+	}
+
+	// skip the marker and the space after the marker
+	xMin := 0
+	for xMin < img.Rect.Max.X &&
+		img.Pix[img.PixOffset(xMin, baseline-1)+3] != 0 {
+		xMin++
+	}
+leftLoop:
+	for xMin < img.Rect.Max.X {
+		for y := 0; y < img.Rect.Max.Y; y++ {
+			if img.Pix[img.PixOffset(xMin, y)+3] != 0 {
+				break leftLoop
+			}
+		}
+		xMin++
+	}
+
+	crop := image.Rect(xMin, 0, img.Rect.Max.X, img.Rect.Max.Y)
+	return img.SubImage(crop), img.Rect.Max.Y - baseline
+}
+
 func cropDisplayed(imgIn image.Image) image.Image {
 	b := imgIn.Bounds()
 	imgOut := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
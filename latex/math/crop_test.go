@@ -0,0 +1,53 @@
+// crop_test.go - unit tests for crop.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package math
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/seehuhn/epublatex/latex/render"
+)
+
+func TestCropInline(t *testing.T) {
+	page := image.NewNRGBA(image.Rect(0, 0, 200, 200))
+	black := &image.Uniform{color.Black}
+	// baseline marker, with the baseline at y=60
+	draw.Draw(page, image.Rect(0, 43, 24, 60), black, image.ZP, draw.Src)
+	// a formula which extends 8 pixels below the baseline
+	draw.Draw(page, image.Rect(48, 50, 90, 68), black, image.ZP, draw.Src)
+
+	img, depth := splitMarker(cropInline(page, nil))
+	if depth != 8 {
+		t.Errorf("wrong depth %d, expected 8", depth)
+	}
+	if b := img.Bounds(); b.Dx() != 42 || b.Dy() != 18 {
+		t.Errorf("wrong size %dx%d, expected 42x18", b.Dx(), b.Dy())
+	}
+
+	// the TeX box extends further below the baseline than the ink
+	box := &render.Box{Width: 10, Height: 2, Depth: 12 / pixPerPt}
+	img, depth = splitMarker(cropInline(page, box))
+	if depth != 12 {
+		t.Errorf("wrong depth %d, expected 12", depth)
+	}
+	if b := img.Bounds(); b.Dx() != 42 || b.Dy() != 22 {
+		t.Errorf("wrong size %dx%d, expected 42x22", b.Dx(), b.Dy())
+	}
+}
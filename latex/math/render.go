@@ -17,10 +17,12 @@
 package math
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"log"
+	"os/exec"
 	"strings"
 	"sync"
 	"text/template"
@@ -32,49 +34,77 @@ import (
 var noCache = flag.Bool("latex-math-no-cache", false,
 	"whether to disable the maths rendering cache")
 
+var errMissing = errors.New("missing image")
+
 const (
 	renderRes = 3 * 96  // render resolution [pixels / inch]
 	exHeight  = 4.30554 // x-height of cmi10 [TeX pt / ex]
 	exPerPix  = 72.27 / exHeight / float64(renderRes)
+	pixPerPt  = float64(renderRes) / 72.27
+	exPerPt   = 1 / exHeight
+
+	// cropVersion must be changed whenever the cropped images stored
+	// in the cache change.
+	cropVersion = 2
 
-	batchSize           = 10
-	mathCachePruneLimit = 256 * 1024
+	batchSize = 10
 )
 
 type Renderer struct {
 	out chan<- *render.BookImage
 
-	preamble []string
-	seen     map[string]bool
-	cache    *cache.Cache
+	preamble    []string
+	preambleKey string
+	seen        map[string]bool
+	cache       *cache.Cache
+	engine      string
 
-	batch    []*formulaInfo
-	queue    *render.Queue
-	children *sync.WaitGroup
+	batch         []*formulaInfo
+	batchPreamble []string
+	queue         *render.Queue
+	children      *sync.WaitGroup
 
+	mutex    sync.Mutex
+	failures render.Errors
+
+	svg  bool
 	tmpl *template.Template
 }
 
-func NewRenderer(out chan<- *render.BookImage) (*Renderer, error) {
+// NewRenderer creates a new Renderer.  The formulas are rendered
+// using the given queue, which may be shared with other renderers,
+// and the images are written to the channel `out`.
+func NewRenderer(out chan<- *render.BookImage, queue *render.Queue) (*Renderer, error) {
 	r := &Renderer{
 		out:      out,
 		seen:     make(map[string]bool),
+		queue:    queue,
+		engine:   queue.EngineVersion(),
 		children: &sync.WaitGroup{},
+		svg:      render.UseSVG(),
 	}
 
-	queue, err := render.NewQueue(renderRes)
-	if err != nil {
-		return nil, err
+	var c *cache.Cache
+	var err error
+	if r.svg {
+		c, err = cache.NewDataCache("maths-svg", ".svg")
+	} else {
+		c, err = cache.NewCache("maths")
 	}
-	r.queue = queue
-
-	cache, err := cache.NewCache("maths")
 	if err != nil {
 		return nil, err
 	}
-	r.cache = cache
+	c.Engine = r.engine
+	if !r.svg {
+		c.Resolution = renderRes
+	}
+	r.cache = c
 
-	tmpl, err := template.New("maths").Parse(texTemplate)
+	tmplText := texTemplate
+	if r.svg {
+		tmplText = svgTemplate
+	}
+	tmpl, err := template.New("maths").Parse(tmplText)
 	if err != nil {
 		return nil, err
 	}
@@ -83,25 +113,37 @@ func NewRenderer(out chan<- *render.BookImage) (*Renderer, error) {
 	return r, nil
 }
 
-func (r *Renderer) Finish() error {
+// Finish renders all remaining formulas, using ctx for the rendering
+// jobs, and waits until all images have been delivered.  The queue is
+// not shut down, since it may be used by other renderers.  If some
+// formulas could not be rendered, the returned error is of type
+// render.Errors and lists all these formulas.
+func (r *Renderer) Finish(ctx context.Context) error {
 	if len(r.batch) > 0 {
-		r.runBatch()
+		r.runBatch(ctx)
 	}
 	r.children.Wait()
-	err := r.queue.Finish()
-	e2 := r.cache.Close(mathCachePruneLimit)
+	err := r.cache.Close(cache.PruneLimit())
 	if err == nil {
-		err = e2
+		err = r.failures.Err()
 	}
-
 	return err
 }
 
+// AddPreamble adds a line to the preamble of the TeX files used to
+// render the formulas.  Formulas added before the call are rendered
+// without the new line.
 func (r *Renderer) AddPreamble(line string) {
 	r.preamble = append(r.preamble, line)
+	r.preambleKey = render.PreambleKey(r.preamble)
 }
 
-func (r *Renderer) AddFormula(env, formula string) {
+// AddFormula schedules a formula for rendering.  The argument
+// `formula` gives the TeX code passed to LaTeX, and `alt` gives the
+// formula as written by the author, for use as the alternative text
+// of the image.  The context is used for the
+// rendering jobs started by the call.
+func (r *Renderer) AddFormula(ctx context.Context, env, formula, alt string) {
 	if strings.Contains(env, "%") {
 		panic("invalid math environment " + env)
 	}
@@ -116,12 +158,29 @@ func (r *Renderer) AddFormula(env, formula string) {
 		key:     key,
 		Env:     env,
 		Formula: formula,
+		Alt:     strings.TrimSpace(alt),
 	}
 
 	if !*noCache && r.cache.Has(key) {
+		if r.svg {
+			data, err := r.cache.GetData(key)
+			var svg *render.SVG
+			if err == nil {
+				svg, err = render.ParseSVG(data)
+			}
+			if err != nil {
+				// render the image again, replacing the damaged
+				// cache entry
+				goto render
+			}
+			r.submitSVG(info, svg)
+			return
+		}
+
 		img, err := r.cache.Get(key)
 		if err != nil {
-			log.Println("cache failure:", err)
+			// render the image again, replacing the damaged
+			// cache entry
 			goto render
 		}
 		r.submit(info, img)
@@ -129,32 +188,66 @@ func (r *Renderer) AddFormula(env, formula string) {
 	}
 
 render:
+	if len(r.batch) > 0 && len(r.batchPreamble) != len(r.preamble) {
+		// the preamble has changed since the batch was started
+		r.runBatch(ctx)
+	}
+	if len(r.batch) == 0 {
+		r.batchPreamble = r.preamble
+	}
 	r.batch = append(r.batch, info)
 	if len(r.batch) >= batchSize {
-		r.runBatch()
+		r.runBatch(ctx)
 	}
 }
 
-func (r *Renderer) runBatch() {
+func (r *Renderer) runBatch(ctx context.Context) {
 	all := r.batch
 	r.batch = nil
+	r.renderBatch(ctx, r.batchPreamble, all)
+}
 
+// renderBatch renders a batch of formulas using a single LaTeX run.
+// If this fails, the batch is split into halves which are rendered
+// separately, so that errors in one formula only affect this formula.
+func (r *Renderer) renderBatch(ctx context.Context, preamble []string, all []*formulaInfo) {
 	data := map[string]interface{}{
-		"Preamble": r.preamble,
+		"Preamble": preamble,
 		"Formulas": all,
 	}
-	in := r.queue.Submit(r.tmpl, data)
+	if r.svg {
+		r.renderSVGBatch(ctx, preamble, all, data)
+		return
+	}
+	in, errc := r.queue.SubmitPages(ctx, renderRes, r.tmpl, data)
 
 	r.children.Add(1)
 	go func() {
-		for _, info := range all {
-			img := <-in
-			if img == nil {
-				log.Println("missing image", info.Env, info.Formula)
-				continue
+		defer r.children.Done()
+
+		var pages []*render.Page
+		for page := range in {
+			pages = append(pages, page)
+		}
+		err := <-errc
+		if err == nil {
+			err = checkCount(len(pages), len(all))
+		}
+		for i := 0; i < len(pages) && err == nil; i++ {
+			if pages[i] == nil || pages[i].Image == nil {
+				err = errMissing
 			}
+		}
+		if err != nil {
+			r.batchFailed(ctx, preamble, all, err)
+			return
+		}
+
+		for i, info := range all {
+			page := pages[i]
+			img := page.Image
 			if info.Env == "$" {
-				img = cropInline(img)
+				img = cropInline(img, page.Box)
 			} else {
 				img = cropDisplayed(img)
 			}
@@ -162,27 +255,102 @@ func (r *Renderer) runBatch() {
 			r.cache.Put(info.key, img)
 			r.submit(info, img)
 		}
-		r.children.Done()
+	}()
+}
+
+func (r *Renderer) renderSVGBatch(ctx context.Context, preamble []string, all []*formulaInfo, data interface{}) {
+	in, errc := r.queue.SubmitSVG(ctx, r.tmpl, data)
+
+	r.children.Add(1)
+	go func() {
+		defer r.children.Done()
+
+		var images []*render.SVG
+		for svg := range in {
+			images = append(images, svg)
+		}
+		err := <-errc
+		if err == nil {
+			err = checkCount(len(images), len(all))
+		}
+		for i := 0; i < len(images) && err == nil; i++ {
+			if images[i] == nil {
+				err = errMissing
+			}
+		}
+		if err != nil {
+			r.batchFailed(ctx, preamble, all, err)
+			return
+		}
 
-		for range in {
-			log.Println("error: received unexpected image from renderer")
+		for i, info := range all {
+			svg := images[i]
+			r.cache.PutData(info.key, svg.Data)
+			r.submitSVG(info, svg)
 		}
 	}()
 }
 
-func (r *Renderer) submit(info *formulaInfo, img image.Image) {
-	alt := "[formula]"
-	if len(info.Formula) <= 60 {
-		alt = info.Formula
+// batchFailed is called when a batch of formulas could not be
+// rendered.  Larger batches are split to find the broken formulas,
+// broken formulas are replaced by their TeX source.  If the external
+// programs cannot be run at all, or if rendering has been cancelled,
+// splitting the batch does not help.
+func (r *Renderer) batchFailed(ctx context.Context, preamble []string, all []*formulaInfo, err error) {
+	if len(all) > 1 && !errors.Is(err, exec.ErrNotFound) && ctx.Err() == nil {
+		mid := len(all) / 2
+		r.renderBatch(ctx, preamble, all[:mid])
+		r.renderBatch(ctx, preamble, all[mid:])
+		return
+	}
+
+	for _, info := range all {
+		r.mutex.Lock()
+		r.failures = append(r.failures,
+			fmt.Errorf("formula %s: %w", oneLine(info.source()), err))
+		r.mutex.Unlock()
+
+		_, cssClass := info.altAndClass()
+		job := &render.BookImage{
+			Env:  info.Env,
+			Body: info.Formula,
+
+			CssClass: cssClass,
+
+			Err:    err,
+			Source: info.source(),
+		}
+		r.out <- job
 	}
-	var cssClass string
+}
+
+// oneLine collapses all white space in s, for use in error messages.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func checkCount(got, expected int) error {
+	if got != expected {
+		return fmt.Errorf("got %d images for %d formulas", got, expected)
+	}
+	return nil
+}
+
+// submit delivers a PNG image.  Images of inline formulas still
+// contain the baseline marker, which is used to align the baseline of
+// the formula with the baseline of the surrounding text.
+func (r *Renderer) submit(info *formulaInfo, img image.Image) {
+	alt, cssClass := info.altAndClass()
+	depth := 0
 	if info.Env == "$" {
-		cssClass = "imath"
-	} else {
-		cssClass = "dmath"
+		img, depth = splitMarker(img)
 	}
 	exWidth := float64(img.Bounds().Dx()) * exPerPix
 	style := fmt.Sprintf("width: %.2fex", exWidth)
+	if info.Env == "$" {
+		style += fmt.Sprintf("; vertical-align: %.2fex",
+			-float64(depth)*exPerPix)
+	}
 	job := &render.BookImage{
 		Env:  info.Env,
 		Body: info.Formula,
@@ -197,18 +365,70 @@ func (r *Renderer) submit(info *formulaInfo, img image.Image) {
 	r.out <- job
 }
 
+// submitSVG delivers an SVG image.  Inline formulas are shifted down
+// by their depth, so that the baseline of the formula lines up with
+// the baseline of the surrounding text.
+func (r *Renderer) submitSVG(info *formulaInfo, svg *render.SVG) {
+	alt, cssClass := info.altAndClass()
+	style := fmt.Sprintf("width: %.2fex", svg.Width*exPerPt)
+	if info.Env == "$" {
+		style += fmt.Sprintf("; vertical-align: %.2fex", -svg.Depth*exPerPt)
+	}
+	job := &render.BookImage{
+		Env:  info.Env,
+		Body: info.Formula,
+
+		Alt:      alt,
+		CssClass: cssClass,
+		Style:    style,
+
+		Type: render.BookImageTypeSVG,
+		Data: svg.Data,
+	}
+	r.out <- job
+}
+
 func (r *Renderer) makeKey(env, formula string) string {
-	// TODO(voss): should the preamble affect the key?
 	// TODO(voss): would hashing be beneficial?
-	return fmt.Sprintf("%d%%%f%%%s%%%s", renderRes, exHeight, env, formula)
+	if r.svg {
+		return fmt.Sprintf("svg%%%s%%%f%%%s%%%s%%%s",
+			r.engine, exHeight, r.preambleKey, env, formula)
+	}
+	return fmt.Sprintf("%d%%%s%%%f%%%d%%%s%%%s%%%s",
+		renderRes, r.engine, exHeight, cropVersion, r.preambleKey, env, formula)
 }
 
 type formulaInfo struct {
 	key     string
 	Env     string
 	Formula string
+	Alt     string
+}
+
+func (info *formulaInfo) altAndClass() (string, string) {
+	alt := "[formula]"
+	if len(info.Alt) <= 60 {
+		alt = info.Alt
+	}
+	if info.Env == "$" {
+		return alt, "imath"
+	}
+	return alt, "dmath"
+}
+
+// source returns the formula as written by the author, including the
+// surrounding maths delimiters.
+func (info *formulaInfo) source() string {
+	if info.Env == "$" {
+		return "$" + info.Alt + "$"
+	}
+	return "\\begin{" + info.Env + "}\n" + info.Alt + "\n\\end{" + info.Env + "}"
 }
 
+// texTemplate is used for PNG output.  The amsmath package is always
+// loaded, since display formulas are rendered using the equation* and
+// align* environments.  It is loaded after the document preamble, so
+// that options given by the document do not cause an option clash.
 const texTemplate = `\documentclass{minimal}
 
 \usepackage[paperwidth=6in,paperheight=9in,margin=0pt]{geometry}
@@ -216,9 +436,13 @@ const texTemplate = `\documentclass{minimal}
 \usepackage{pdfrender}
 \pdfrender{TextRenderingMode=2,LineWidth=0.05pt}
 
+` + render.MeasureMacro + `
+
 {{range .Preamble -}}
 {{.}}
-{{end}}
+{{end -}}
+\usepackage{amsmath}
+
 \parindent0pt
 \parskip0pt
 
@@ -227,8 +451,9 @@ const texTemplate = `\documentclass{minimal}
 
 {{range .Formulas -}}
 {{if eq .Env "$" -}}
+\sbox0{${{.Formula}}$}\epublatexmeasure0
 \vrule width6bp height4.3pt depth0pt \kern6bp
-${{.Formula}}$
+\usebox0
 {{else -}}
 \begin{ {{- .Env -}} }
   {{.Formula}}
@@ -239,3 +464,42 @@ ${{.Formula}}$
 {{end -}}
 \end{document}
 `
+
+// svgTemplate is used for SVG output.  Each formula is placed in a
+// "preview" box, so that dvisvgm can report the size and the depth of
+// the formula.  As for texTemplate, the amsmath package is always
+// loaded.
+const svgTemplate = `\documentclass{minimal}
+
+\usepackage[active,tightpage]{preview}
+
+{{range .Preamble -}}
+{{.}}
+{{end -}}
+\usepackage{amsmath}
+
+\begin{document}
+\fontsize{10}{12}\selectfont
+
+{{range .Formulas -}}
+\begin{preview}
+{{- if eq .Env "$" -}}
+${{.Formula}}$
+{{- else if eq .Env "equation*" -}}
+$\displaystyle {{.Formula}}$
+{{- else if eq .Env "align*" -}}
+$\displaystyle\begin{aligned}
+  {{.Formula}}
+\end{aligned}$
+{{- else -}}
+\begin{minipage}{6in}
+\begin{ {{- .Env -}} }
+  {{.Formula}}
+\end{ {{- .Env -}} }
+\end{minipage}
+{{- end -}}
+\end{preview}
+
+{{end -}}
+\end{document}
+`
@@ -0,0 +1,73 @@
+// render_test.go - unit tests for render.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package math
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestTemplateAmsmath(t *testing.T) {
+	const amsmath = `\usepackage{amsmath}`
+	for _, tmplText := range []string{texTemplate, svgTemplate} {
+		tmpl := template.Must(template.New("maths").Parse(tmplText))
+		for _, preamble := range [][]string{nil, {`\usepackage[fleqn]{amsmath}`}} {
+			data := map[string]interface{}{
+				"Preamble": preamble,
+				"Formulas": []*formulaInfo{
+					{Env: "equation*", Formula: "x"},
+					{Env: "align*", Formula: "x &= y"},
+				},
+			}
+			buf := &bytes.Buffer{}
+			err := tmpl.Execute(buf, data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tex := buf.String()
+
+			// Options given in the document must come first, to
+			// avoid an option clash.
+			idx := strings.LastIndex(tex, amsmath)
+			if idx < 0 {
+				t.Errorf("amsmath not loaded:\n%s", tex)
+			} else if len(preamble) > 0 && strings.Index(tex, preamble[0]) > idx {
+				t.Errorf("amsmath loaded before the document preamble:\n%s", tex)
+			}
+		}
+	}
+}
+
+func TestMakeKey(t *testing.T) {
+	renderers := []*Renderer{
+		{engine: "pdfTeX 3.14; gs"},
+		{engine: "pdfTeX 3.14; mutool"},
+		{engine: "XeTeX 3.14; gs"},
+		{engine: "pdfTeX 3.14; gs", svg: true},
+		{engine: "XeTeX 3.14; gs", svg: true},
+	}
+	seen := make(map[string]int)
+	for i, r := range renderers {
+		key := r.makeKey("$", "x^2")
+		if j, ok := seen[key]; ok {
+			t.Errorf("renderers %d and %d use the same key %q", j, i, key)
+		}
+		seen[key] = i
+	}
+}
@@ -0,0 +1,581 @@
+// mathml.go - translate TeX formulas into MathML
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+// Package mathml translates TeX formulas into MathML.  Only a subset
+// of TeX is supported; for other formulas, Convert returns an error
+// and the caller can fall back to rendering the formula as an image.
+package mathml
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+// ErrUnsupported indicates that a formula uses constructs which
+// cannot be translated into MathML.
+var ErrUnsupported = errors.New("unsupported construct")
+
+func unsupported(what string) error {
+	return fmt.Errorf("%w: %s", ErrUnsupported, what)
+}
+
+// Convert translates a formula into MathML.  The argument `env` gives
+// the maths environment: "$" for inline maths, "equation*" and
+// "align*" for displayed formulas.  The tokens must not include the
+// delimiters of the formula.
+func Convert(env string, formula tokenizer.TokenList) (string, error) {
+	p := &parser{toks: flatten(formula)}
+	var body string
+	var err error
+	switch env {
+	case "$":
+		body, err = p.parseRow(nil)
+	case "equation*":
+		p.display = true
+		body, err = p.parseRow(nil)
+	case "align*":
+		p.display = true
+		body, err = p.parseTable()
+	default:
+		return "", unsupported("environment " + env)
+	}
+	if err != nil {
+		return "", err
+	}
+	if p.pos < len(p.toks) {
+		return "", unsupported("unbalanced braces")
+	}
+
+	res := []string{`<math xmlns="http://www.w3.org/1998/Math/MathML"`}
+	if p.display {
+		res = append(res, ` display="block"`)
+	}
+	alt := strings.TrimSpace(formula.Source())
+	if alt != "" {
+		res = append(res, ` alttext="`+html.EscapeString(alt)+`"`)
+	}
+	res = append(res, ">", body, "</math>")
+	return strings.Join(res, ""), nil
+}
+
+// flatten removes white space and comments, which are ignored in
+// maths mode, and splits words into individual letters.
+func flatten(formula tokenizer.TokenList) tokenizer.TokenList {
+	var res tokenizer.TokenList
+	for _, tok := range formula {
+		switch tok.Type {
+		case tokenizer.TokenSpace, tokenizer.TokenComment,
+			tokenizer.TokenEmptyLine:
+			// pass
+		case tokenizer.TokenWord:
+			for _, c := range tok.Name {
+				res = append(res, &tokenizer.Token{
+					Type: tokenizer.TokenWord,
+					Name: string(c),
+				})
+			}
+		default:
+			res = append(res, tok)
+		}
+	}
+	return res
+}
+
+type parser struct {
+	toks    tokenizer.TokenList
+	pos     int
+	display bool
+}
+
+func (p *parser) peek() *tokenizer.Token {
+	if p.pos >= len(p.toks) {
+		return nil
+	}
+	return p.toks[p.pos]
+}
+
+func isOther(tok *tokenizer.Token, name string) bool {
+	return tok != nil && tok.Type == tokenizer.TokenOther && tok.Name == name
+}
+
+func isMacro(tok *tokenizer.Token, name string) bool {
+	return tok != nil && tok.Type == tokenizer.TokenMacro && tok.Name == name
+}
+
+// parseRow translates tokens until the end of input, until a closing
+// brace, or until `stop` returns true.  The token which ends the row
+// is not consumed.
+func (p *parser) parseRow(stop func(*tokenizer.Token) bool) (string, error) {
+	var items []string
+	for {
+		tok := p.peek()
+		if tok == nil || isOther(tok, "}") || stop != nil && stop(tok) {
+			break
+		}
+		item, err := p.parseScripted()
+		if err != nil {
+			return "", err
+		}
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return mrow(items), nil
+}
+
+func mrow(items []string) string {
+	if len(items) == 1 {
+		return items[0]
+	}
+	return "<mrow>" + strings.Join(items, "") + "</mrow>"
+}
+
+// parseTable translates the rows of an align* environment.
+func (p *parser) parseTable() (string, error) {
+	isSep := func(tok *tokenizer.Token) bool {
+		return isOther(tok, "&") || isMacro(tok, "\\\\")
+	}
+	var rows [][]string
+	var row []string
+	cols := 0
+	for {
+		cell, err := p.parseRow(isSep)
+		if err != nil {
+			return "", err
+		}
+		row = append(row, cell)
+
+		tok := p.peek()
+		if isOther(tok, "&") {
+			p.pos++
+			continue
+		}
+		if len(row) > cols {
+			cols = len(row)
+		}
+		rows = append(rows, row)
+		row = nil
+		if !isMacro(tok, "\\\\") {
+			break
+		}
+		p.pos++
+		if p.peek() == nil {
+			break
+		}
+	}
+
+	var align []string
+	for i := 0; i < cols; i++ {
+		if i%2 == 0 {
+			align = append(align, "right")
+		} else {
+			align = append(align, "left")
+		}
+	}
+	res := []string{`<mtable displaystyle="true" columnalign="` +
+		strings.Join(align, " ") + `">`}
+	for _, row := range rows {
+		res = append(res, "<mtr>")
+		for _, cell := range row {
+			res = append(res, "<mtd>"+cell+"</mtd>")
+		}
+		res = append(res, "</mtr>")
+	}
+	res = append(res, "</mtable>")
+	return strings.Join(res, ""), nil
+}
+
+// parseScripted translates an atom together with its subscript and
+// superscript, if any.
+func (p *parser) parseScripted() (string, error) {
+	var base string
+	limits := false
+	tok := p.peek()
+	if isOther(tok, "^") || isOther(tok, "_") {
+		base = "<mrow></mrow>"
+	} else {
+		var err error
+		base, limits, err = p.parseAtom(false)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var sub, sup string
+	primes := ""
+	for {
+		tok := p.peek()
+		switch {
+		case isOther(tok, "'"):
+			p.pos++
+			primes += "′"
+			continue
+		case isOther(tok, "^") && sup == "":
+			p.pos++
+			arg, _, err := p.parseAtom(true)
+			if err != nil {
+				return "", err
+			}
+			sup = arg
+			continue
+		case isOther(tok, "_") && sub == "":
+			p.pos++
+			arg, _, err := p.parseAtom(true)
+			if err != nil {
+				return "", err
+			}
+			sub = arg
+			continue
+		case isOther(tok, "^") || isOther(tok, "_"):
+			return "", unsupported("double script")
+		}
+		break
+	}
+	if primes != "" {
+		primes = "<mo>" + primes + "</mo>"
+		if sup != "" {
+			sup = "<mrow>" + primes + sup + "</mrow>"
+		} else {
+			sup = primes
+		}
+	}
+
+	under, over := "msub", "msup"
+	both := "msubsup"
+	if limits && p.display {
+		under, over, both = "munder", "mover", "munderover"
+	}
+	switch {
+	case sub != "" && sup != "":
+		return "<" + both + ">" + base + sub + sup + "</" + both + ">", nil
+	case sub != "":
+		return "<" + under + ">" + base + sub + "</" + under + ">", nil
+	case sup != "":
+		return "<" + over + ">" + base + sup + "</" + over + ">", nil
+	}
+	return base, nil
+}
+
+// parseAtom translates a single token, a braced group, or a macro
+// together with its arguments.  Inside scripts, numbers are split
+// into single digits, like in TeX.  The returned flag indicates
+// whether the atom takes limits in displayed formulas.
+func (p *parser) parseAtom(script bool) (string, bool, error) {
+	tok := p.peek()
+	if tok == nil {
+		return "", false, unsupported("missing argument")
+	}
+	p.pos++
+
+	switch tok.Type {
+	case tokenizer.TokenWord:
+		return "<mi>" + tok.Name + "</mi>", false, nil
+	case tokenizer.TokenMacro:
+		return p.parseMacro(tok)
+	case tokenizer.TokenOther:
+		// handled below
+	default:
+		return "", false, unsupported("token " + tok.Name)
+	}
+
+	name := tok.Name
+	switch {
+	case name == "{":
+		body, err := p.parseRow(nil)
+		if err != nil {
+			return "", false, err
+		}
+		if !isOther(p.peek(), "}") {
+			return "", false, unsupported("unbalanced braces")
+		}
+		p.pos++
+		return body, false, nil
+	case isDigit(name):
+		number := name
+		for !script {
+			next := p.peek()
+			if next == nil || next.Type != tokenizer.TokenOther {
+				break
+			}
+			if isDigit(next.Name) {
+				number += next.Name
+				p.pos++
+			} else if next.Name == "." && p.pos+1 < len(p.toks) &&
+				p.toks[p.pos+1].Type == tokenizer.TokenOther &&
+				isDigit(p.toks[p.pos+1].Name) {
+				number += "."
+				p.pos++
+			} else {
+				break
+			}
+		}
+		return "<mn>" + number + "</mn>", false, nil
+	case name == "'":
+		return "<mo>′</mo>", false, nil
+	case name == "~":
+		return `<mspace width="0.278em"/>`, false, nil
+	}
+	if op, ok := otherOperators[name]; ok {
+		return "<mo>" + op + "</mo>", false, nil
+	}
+	return "", false, unsupported("character " + name)
+}
+
+func isDigit(s string) bool {
+	return len(s) == 1 && s[0] >= '0' && s[0] <= '9'
+}
+
+func (p *parser) parseMacro(tok *tokenizer.Token) (string, bool, error) {
+	name := tok.Name
+	if c, ok := identifiers[name]; ok {
+		return "<mi>" + c + "</mi>", false, nil
+	}
+	if c, ok := uprightIdentifiers[name]; ok {
+		return `<mi mathvariant="normal">` + c + "</mi>", false, nil
+	}
+	if c, ok := operators[name]; ok {
+		return "<mo>" + c + "</mo>", false, nil
+	}
+	if c, ok := largeOperators[name]; ok {
+		return "<mo>" + c + "</mo>", !integrals[name], nil
+	}
+	if limits, ok := functions[name]; ok {
+		fn := "<mi>" + name[1:] + "</mi>"
+		if limits {
+			return fn, true, nil
+		}
+		return fn + "<mo>&#x2061;</mo>", false, nil
+	}
+	if width, ok := spaces[name]; ok {
+		return `<mspace width="` + width + `"/>`, false, nil
+	}
+	if ignored[name] {
+		return "", false, nil
+	}
+	if c, ok := accents[name]; ok {
+		arg, err := p.parseArg(tok, 0)
+		if err != nil {
+			return "", false, err
+		}
+		return `<mover accent="true">` + arg + "<mo>" + c + "</mo></mover>",
+			false, nil
+	}
+	if variant, ok := fonts[name]; ok {
+		arg, err := p.argTokens(tok, 0)
+		if err != nil {
+			return "", false, err
+		}
+		text := flatten(arg).FormatText()
+		for _, c := range text {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+				c >= '0' && c <= '9') {
+				return "", false, unsupported(name + " with complex argument")
+			}
+		}
+		return `<mi mathvariant="` + variant + `">` + text + "</mi>",
+			name == "\\operatorname", nil
+	}
+
+	switch name {
+	case "\\frac", "\\dfrac", "\\tfrac":
+		num, err := p.parseArg(tok, 0)
+		if err != nil {
+			return "", false, err
+		}
+		den, err := p.parseArg(tok, 1)
+		if err != nil {
+			return "", false, err
+		}
+		return "<mfrac>" + num + den + "</mfrac>", false, nil
+	case "\\binom":
+		top, err := p.parseArg(tok, 0)
+		if err != nil {
+			return "", false, err
+		}
+		bottom, err := p.parseArg(tok, 1)
+		if err != nil {
+			return "", false, err
+		}
+		return `<mrow><mo>(</mo><mfrac linethickness="0">` + top + bottom +
+			"</mfrac><mo>)</mo></mrow>", false, nil
+	case "\\sqrt":
+		var index string
+		if len(tok.Args) > 0 && tok.Args[0].Optional &&
+			len(tok.Args[0].Value) > 0 {
+			var err error
+			index, err = subParser(tok.Args[0].Value).parseRow(nil)
+			if err != nil {
+				return "", false, err
+			}
+		}
+		arg, err := p.parseArg(tok, 0)
+		if err != nil {
+			return "", false, err
+		}
+		if index != "" {
+			return "<mroot>" + arg + index + "</mroot>", false, nil
+		}
+		return "<msqrt>" + arg + "</msqrt>", false, nil
+	case "\\underline":
+		arg, err := p.parseArg(tok, 0)
+		if err != nil {
+			return "", false, err
+		}
+		return `<munder accentunder="true">` + arg + "<mo>_</mo></munder>",
+			false, nil
+	case "\\text", "\\textrm", "\\mbox":
+		arg, err := p.argTokens(tok, 0)
+		if err != nil {
+			return "", false, err
+		}
+		for _, t := range arg {
+			if t.Type == tokenizer.TokenOther && t.Name == "$" ||
+				t.Type == tokenizer.TokenMacro {
+				return "", false, unsupported(name + " with complex argument")
+			}
+		}
+		return "<mtext>" + html.EscapeString(arg.FormatText()) + "</mtext>",
+			false, nil
+	case "\\left":
+		return p.parseLeftRight()
+	case "\\big", "\\Big", "\\bigg", "\\Bigg",
+		"\\bigl", "\\Bigl", "\\biggl", "\\Biggl",
+		"\\bigr", "\\Bigr", "\\biggr", "\\Biggr",
+		"\\bigm", "\\Bigm", "\\biggm", "\\Biggm":
+		delim, err := p.parseDelimiter()
+		if err != nil {
+			return "", false, err
+		}
+		return delim, false, nil
+	}
+	return "", false, unsupported("macro " + name)
+}
+
+// parseLeftRight translates a \left ... \right group.  The \left has
+// already been consumed.
+func (p *parser) parseLeftRight() (string, bool, error) {
+	left, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	body, err := p.parseRow(func(tok *tokenizer.Token) bool {
+		return isMacro(tok, "\\right")
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if !isMacro(p.peek(), "\\right") {
+		return "", false, unsupported("\\left without \\right")
+	}
+	p.pos++
+	right, err := p.parseDelimiter()
+	if err != nil {
+		return "", false, err
+	}
+	return "<mrow>" + left + body + right + "</mrow>", false, nil
+}
+
+// parseDelimiter translates the delimiter following \left, \right or
+// \big.  The null delimiter "." gives the empty string.
+func (p *parser) parseDelimiter() (string, error) {
+	tok := p.peek()
+	if tok == nil {
+		return "", unsupported("missing delimiter")
+	}
+	p.pos++
+	var c string
+	switch tok.Type {
+	case tokenizer.TokenOther:
+		if tok.Name == "." {
+			return "", nil
+		}
+		c = otherOperators[tok.Name]
+	case tokenizer.TokenMacro:
+		c = operators[tok.Name]
+	}
+	if c == "" {
+		return "", unsupported("delimiter " + tok.Name)
+	}
+	return `<mo fence="true">` + c + "</mo>", nil
+}
+
+// argTokens returns the k-th mandatory argument of the macro `tok`.
+// If the tokenizer has not attached the arguments to the macro token,
+// the next atom of the input is used.
+func (p *parser) argTokens(tok *tokenizer.Token, k int) (tokenizer.TokenList, error) {
+	var args []*tokenizer.Arg
+	for _, arg := range tok.Args {
+		if !arg.Optional {
+			args = append(args, arg)
+		}
+	}
+	if len(args) > 0 {
+		if k >= len(args) {
+			return nil, unsupported("missing argument for " + tok.Name)
+		}
+		return args[k].Value, nil
+	}
+
+	next := p.peek()
+	if next == nil {
+		return nil, unsupported("missing argument for " + tok.Name)
+	}
+	if !isOther(next, "{") {
+		p.pos++
+		return tokenizer.TokenList{next}, nil
+	}
+	start := p.pos + 1
+	level := 0
+	for p.pos < len(p.toks) {
+		t := p.toks[p.pos]
+		p.pos++
+		if isOther(t, "{") {
+			level++
+		} else if isOther(t, "}") {
+			level--
+			if level == 0 {
+				return p.toks[start : p.pos-1], nil
+			}
+		}
+	}
+	return nil, unsupported("unbalanced braces")
+}
+
+// parseArg translates the k-th mandatory argument of the macro `tok`.
+func (p *parser) parseArg(tok *tokenizer.Token, k int) (string, error) {
+	arg, err := p.argTokens(tok, k)
+	if err != nil {
+		return "", err
+	}
+	sub := subParser(arg)
+	sub.display = p.display
+	res, err := sub.parseRow(nil)
+	if err != nil {
+		return "", err
+	}
+	if sub.pos < len(sub.toks) {
+		return "", unsupported("unbalanced braces")
+	}
+	return res, nil
+}
+
+func subParser(toks tokenizer.TokenList) *parser {
+	return &parser{toks: flatten(toks)}
+}
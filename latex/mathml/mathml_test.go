@@ -0,0 +1,133 @@
+// mathml_test.go - unit tests for mathml.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mathml
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+func tokenize(t *testing.T, formula string) tokenizer.TokenList {
+	toks := tokenizer.NewTokenizer()
+	defer toks.Close()
+	src := "\\usepackage{amsmath}\\usepackage{amsfonts}" + formula
+	toks.Prepend([]byte(src), "test")
+	c := make(chan *tokenizer.Token)
+	go func() {
+		err := toks.ParseTex(c)
+		if err != nil {
+			t.Error(err)
+		}
+		close(c)
+	}()
+	var res tokenizer.TokenList
+	for tok := range c {
+		if tok.Type == tokenizer.TokenMacro && tok.Name == "\\usepackage" {
+			continue
+		}
+		res = append(res, tok)
+	}
+	return res
+}
+
+func TestConvert(t *testing.T) {
+	testCases := []struct {
+		env, in, out string
+	}{
+		{"$", "x+1", "<mrow><mi>x</mi><mo>+</mo><mn>1</mn></mrow>"},
+		{"$", "3.14-ab", "<mrow><mn>3.14</mn><mo>−</mo><mi>a</mi><mi>b</mi></mrow>"},
+		{"$", "x_i^2", "<msubsup><mi>x</mi><mi>i</mi><mn>2</mn></msubsup>"},
+		{"$", "x^{10}", "<msup><mi>x</mi><mn>10</mn></msup>"},
+		{"$", "x^10", "<mrow><msup><mi>x</mi><mn>1</mn></msup><mn>0</mn></mrow>"},
+		{"$", "f'", "<msup><mi>f</mi><mo>′</mo></msup>"},
+		{"$", "\\frac12", "<mfrac><mn>1</mn><mn>2</mn></mfrac>"},
+		{"$", "\\sqrt[3]{\\alpha}", "<mroot><mi>α</mi><mn>3</mn></mroot>"},
+		{"$", "\\mathbb{R}", `<mi mathvariant="double-struck">R</mi>`},
+		{"$", "\\mathcal{A}", `<mi mathvariant="script">A</mi>`},
+		{"$", "\\sin x", "<mrow><mi>sin</mi><mo>&#x2061;</mo><mi>x</mi></mrow>"},
+		{"$", "\\text{if }n<0",
+			"<mrow><mtext>if </mtext><mi>n</mi><mo>&lt;</mo><mn>0</mn></mrow>"},
+		{"$", "\\left(x\\right]",
+			`<mrow><mo fence="true">(</mo><mi>x</mi><mo fence="true">]</mo></mrow>`},
+		{"$", "\\sum_{i=1}^n",
+			"<msubsup><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></msubsup>"},
+		{"equation*", "\\sum_{i=1}^n",
+			"<munderover><mo>∑</mo><mrow><mi>i</mi><mo>=</mo><mn>1</mn></mrow><mi>n</mi></munderover>"},
+		{"equation*", "\\int_0^1",
+			"<msubsup><mo>∫</mo><mn>0</mn><mn>1</mn></msubsup>"},
+		{"align*", "a&=b\\\\c&=d\\\\",
+			`<mtable displaystyle="true" columnalign="right left">` +
+				"<mtr><mtd><mi>a</mi></mtd><mtd><mrow><mo>=</mo><mi>b</mi></mrow></mtd></mtr>" +
+				"<mtr><mtd><mi>c</mi></mtd><mtd><mrow><mo>=</mo><mi>d</mi></mrow></mtd></mtr>" +
+				"</mtable>"},
+	}
+	for i, test := range testCases {
+		res, err := Convert(test.env, tokenize(t, test.in))
+		if err != nil {
+			t.Errorf("%d: %q: %s", i, test.in, err)
+			continue
+		}
+		start := strings.Index(res, ">") + 1
+		body := strings.TrimSuffix(res[start:], "</math>")
+		if body != test.out {
+			t.Errorf("%d: %q: wrong output\n  %s\nexpected\n  %s",
+				i, test.in, body, test.out)
+		}
+	}
+}
+
+func TestConvertAttributes(t *testing.T) {
+	res, err := Convert("$", tokenize(t, "a<b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<math xmlns="http://www.w3.org/1998/Math/MathML" alttext="a&lt;b">`
+	if !strings.HasPrefix(res, expected) {
+		t.Errorf("wrong start tag: %s", res)
+	}
+
+	res, err = Convert("equation*", tokenize(t, "x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(res, ` display="block"`) {
+		t.Errorf("display formula not marked as block: %s", res)
+	}
+}
+
+func TestUnsupported(t *testing.T) {
+	testCases := []struct {
+		env, in string
+	}{
+		{"$", "\\unknownmacro x"},
+		{"$", "x^2^3"},
+		{"$", "\\left( x"},
+		{"$", "\\mathbb{x+y}"},
+		{"equation*", "a \\\\ b"},
+		{"gather*", "x"},
+	}
+	for i, test := range testCases {
+		_, err := Convert(test.env, tokenize(t, test.in))
+		if !errors.Is(err, ErrUnsupported) {
+			t.Errorf("%d: %q: expected ErrUnsupported, got %v",
+				i, test.in, err)
+		}
+	}
+}
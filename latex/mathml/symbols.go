@@ -0,0 +1,282 @@
+// symbols.go - the TeX maths symbols known to the MathML translator
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package mathml
+
+// identifiers maps macros to the characters used in <mi> elements.
+var identifiers = map[string]string{
+	"\\alpha":      "α",
+	"\\beta":       "β",
+	"\\gamma":      "γ",
+	"\\delta":      "δ",
+	"\\epsilon":    "ϵ",
+	"\\varepsilon": "ε",
+	"\\zeta":       "ζ",
+	"\\eta":        "η",
+	"\\theta":      "θ",
+	"\\vartheta":   "ϑ",
+	"\\iota":       "ι",
+	"\\kappa":      "κ",
+	"\\lambda":     "λ",
+	"\\mu":         "μ",
+	"\\nu":         "ν",
+	"\\xi":         "ξ",
+	"\\pi":         "π",
+	"\\varpi":      "ϖ",
+	"\\rho":        "ρ",
+	"\\varrho":     "ϱ",
+	"\\sigma":      "σ",
+	"\\varsigma":   "ς",
+	"\\tau":        "τ",
+	"\\upsilon":    "υ",
+	"\\phi":        "ϕ",
+	"\\varphi":     "φ",
+	"\\chi":        "χ",
+	"\\psi":        "ψ",
+	"\\omega":      "ω",
+
+	"\\ell":      "ℓ",
+	"\\hbar":     "ℏ",
+	"\\infty":    "∞",
+	"\\emptyset": "∅",
+	"\\partial":  "∂",
+	"\\nabla":    "∇",
+}
+
+// uprightIdentifiers maps macros to the characters used in <mi>
+// elements with mathvariant="normal".
+var uprightIdentifiers = map[string]string{
+	"\\Gamma":   "Γ",
+	"\\Delta":   "Δ",
+	"\\Theta":   "Θ",
+	"\\Lambda":  "Λ",
+	"\\Xi":      "Ξ",
+	"\\Pi":      "Π",
+	"\\Sigma":   "Σ",
+	"\\Upsilon": "Υ",
+	"\\Phi":     "Φ",
+	"\\Psi":     "Ψ",
+	"\\Omega":   "Ω",
+}
+
+// operators maps macros to the characters used in <mo> elements.
+var operators = map[string]string{
+	"\\pm":       "±",
+	"\\mp":       "∓",
+	"\\times":    "×",
+	"\\div":      "÷",
+	"\\cdot":     "⋅",
+	"\\ast":      "∗",
+	"\\star":     "⋆",
+	"\\circ":     "∘",
+	"\\cup":      "∪",
+	"\\cap":      "∩",
+	"\\setminus": "∖",
+	"\\wedge":    "∧",
+	"\\vee":      "∨",
+	"\\oplus":    "⊕",
+	"\\otimes":   "⊗",
+
+	"\\le":             "≤",
+	"\\leq":            "≤",
+	"\\ge":             "≥",
+	"\\geq":            "≥",
+	"\\ne":             "≠",
+	"\\neq":            "≠",
+	"\\ll":             "≪",
+	"\\gg":             "≫",
+	"\\approx":         "≈",
+	"\\equiv":          "≡",
+	"\\sim":            "∼",
+	"\\simeq":          "≃",
+	"\\cong":           "≅",
+	"\\propto":         "∝",
+	"\\perp":           "⊥",
+	"\\in":             "∈",
+	"\\notin":          "∉",
+	"\\ni":             "∋",
+	"\\subset":         "⊂",
+	"\\subseteq":       "⊆",
+	"\\supset":         "⊃",
+	"\\supseteq":       "⊇",
+	"\\mid":            "∣",
+	"\\to":             "→",
+	"\\rightarrow":     "→",
+	"\\leftarrow":      "←",
+	"\\mapsto":         "↦",
+	"\\Rightarrow":     "⇒",
+	"\\Leftarrow":      "⇐",
+	"\\Leftrightarrow": "⇔",
+	"\\iff":            "⟺",
+	"\\implies":        "⟹",
+
+	"\\forall": "∀",
+	"\\exists": "∃",
+	"\\neg":    "¬",
+	"\\lnot":   "¬",
+
+	"\\ldots": "…",
+	"\\dots":  "…",
+	"\\cdots": "⋯",
+	"\\vdots": "⋮",
+	"\\ddots": "⋱",
+	"\\colon": ":",
+	"\\prime": "′",
+
+	"\\{":      "{",
+	"\\}":      "}",
+	"\\langle": "⟨",
+	"\\rangle": "⟩",
+	"\\lvert":  "|",
+	"\\rvert":  "|",
+	"\\vert":   "|",
+	"\\lVert":  "‖",
+	"\\rVert":  "‖",
+	"\\Vert":   "‖",
+	"\\|":      "‖",
+	"\\lfloor": "⌊",
+	"\\rfloor": "⌋",
+	"\\lceil":  "⌈",
+	"\\rceil":  "⌉",
+}
+
+// largeOperators maps macros to the characters used for large
+// operators.  In displayed formulas, the scripts of these operators
+// are placed above and below, except for integrals.
+var largeOperators = map[string]string{
+	"\\sum":      "∑",
+	"\\prod":     "∏",
+	"\\coprod":   "∐",
+	"\\bigcup":   "⋃",
+	"\\bigcap":   "⋂",
+	"\\bigoplus": "⨁",
+	"\\int":      "∫",
+	"\\iint":     "∬",
+	"\\oint":     "∮",
+}
+
+// integrals lists the large operators which keep their scripts at
+// the side in displayed formulas.
+var integrals = map[string]bool{
+	"\\int":  true,
+	"\\iint": true,
+	"\\oint": true,
+}
+
+// functions lists the macros for named functions, like \sin.  The
+// value indicates whether the function takes limits, like \lim.
+var functions = map[string]bool{
+	"\\arccos": false,
+	"\\arcsin": false,
+	"\\arctan": false,
+	"\\arg":    false,
+	"\\cos":    false,
+	"\\cosh":   false,
+	"\\cot":    false,
+	"\\deg":    false,
+	"\\det":    true,
+	"\\dim":    false,
+	"\\exp":    false,
+	"\\gcd":    true,
+	"\\inf":    true,
+	"\\ker":    false,
+	"\\lim":    true,
+	"\\liminf": true,
+	"\\limsup": true,
+	"\\ln":     false,
+	"\\log":    false,
+	"\\max":    true,
+	"\\min":    true,
+	"\\Pr":     true,
+	"\\sec":    false,
+	"\\sin":    false,
+	"\\sinh":   false,
+	"\\sup":    true,
+	"\\tan":    false,
+	"\\tanh":   false,
+}
+
+// spaces maps the TeX spacing macros to widths in em.
+var spaces = map[string]string{
+	"\\,":     "0.167em",
+	"\\:":     "0.222em",
+	"\\>":     "0.222em",
+	"\\;":     "0.278em",
+	"\\!":     "-0.167em",
+	"\\quad":  "1em",
+	"\\qquad": "2em",
+}
+
+// accents maps the TeX accent macros to the characters placed above
+// the argument.
+var accents = map[string]string{
+	"\\hat":       "^",
+	"\\widehat":   "^",
+	"\\tilde":     "~",
+	"\\widetilde": "~",
+	"\\bar":       "¯",
+	"\\overline":  "‾",
+	"\\vec":       "→",
+	"\\dot":       "˙",
+	"\\ddot":      "¨",
+}
+
+// fonts maps the TeX font macros to MathML mathvariant values.
+var fonts = map[string]string{
+	"\\mathbb":       "double-struck",
+	"\\mathcal":      "script",
+	"\\mathfrak":     "fraktur",
+	"\\mathrm":       "normal",
+	"\\mathbf":       "bold",
+	"\\mathit":       "italic",
+	"\\mathsf":       "sans-serif",
+	"\\mathtt":       "monospace",
+	"\\boldsymbol":   "bold-italic",
+	"\\operatorname": "normal",
+}
+
+// otherOperators maps the characters which TeX treats as operators to
+// the characters used in <mo> elements.
+var otherOperators = map[string]string{
+	"+": "+",
+	"-": "−",
+	"*": "∗",
+	"=": "=",
+	"<": "&lt;",
+	">": "&gt;",
+	"(": "(",
+	")": ")",
+	"[": "[",
+	"]": "]",
+	",": ",",
+	";": ";",
+	":": ":",
+	"!": "!",
+	"?": "?",
+	"/": "/",
+	"|": "|",
+	".": ".",
+}
+
+// ignored lists the macros which do not affect the MathML output.
+var ignored = map[string]bool{
+	"\\displaystyle": true,
+	"\\textstyle":    true,
+	"\\limits":       true,
+	"\\nolimits":     true,
+	"\\nonumber":     true,
+	"\\notag":        true,
+}
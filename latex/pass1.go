@@ -17,15 +17,16 @@
 package latex
 
 import (
+	"context"
 	"encoding/gob"
 	"errors"
+	"fmt"
 	"io"
-	"log"
 	"os"
 
-	"github.com/seehuhn/epublatex/latex/math"
+	"github.com/seehuhn/epublatex/latex/graphics"
 	"github.com/seehuhn/epublatex/latex/render"
-	"github.com/seehuhn/epublatex/latex/tikz"
+	"github.com/seehuhn/epublatex/latex/scanner"
 	"github.com/seehuhn/epublatex/latex/tokenizer"
 )
 
@@ -34,9 +35,13 @@ import (
 var ErrUnterminatedMath = errors.New("maths environment not terminated")
 
 // Pass1 renders all formulas and tikz images, and extracts the
-// cross-references.
-func (conv *converter) Pass1() error {
+// cross-references.  Images which cannot be rendered are replaced by
+// their TeX source and are listed in conv.RenderErrors, without
+// stopping the conversion.  If ctx is cancelled, Pass1 stops and
+// returns ctx.Err().
+func (conv *converter) Pass1(ctx context.Context) error {
 	conv.Images = make(map[string]string)
+	conv.ImageErrors = make(map[string]error)
 	imageChan := make(chan *render.BookImage)
 	resChan := make(chan error)
 	go conv.imageAdder(imageChan, resChan)
@@ -45,21 +50,15 @@ func (conv *converter) Pass1() error {
 	ref := -1
 	refType := ""
 	refName := ""
+	var float *floatInfo
 
-	mathRenderer, err := math.NewRenderer(imageChan)
-	if err != nil {
-		return err
-	}
-	tikzRenderer, err := tikz.NewRenderer(imageChan)
-	if err != nil {
-		return err
-	}
-	// TODO(voss): handle this properly
-	mathRenderer.AddPreamble("\\usepackage{amsfonts}")
-	mathRenderer.AddPreamble("\\usepackage{amsmath}")
-	mathRenderer.AddPreamble("\\DeclareMathOperator*{\\argmax}{arg\\,max}")
+	// the renderers are only started if images are needed
+	rs := newRenderers(imageChan)
+	// packages next to the document are copied into the preamble
+	rs.searchPath = append([]string{conv.SourceDir}, searchPath()...)
 	var mathMode isEnd
 	var mathEnv string
+	var mathPos scanner.Pos
 	var mathTokens tokenizer.TokenList
 	var mathLabel string
 
@@ -72,7 +71,7 @@ func (conv *converter) Pass1() error {
 	defer tokFile.Close()
 	toks := gob.NewDecoder(tokFile)
 	pos := 0
-	for {
+	for ctx.Err() == nil {
 		var token *tokenizer.Token
 		err := toks.Decode(&token)
 		if err == io.EOF {
@@ -86,18 +85,26 @@ func (conv *converter) Pass1() error {
 			mathEnv, mathMode = conv.IsMathStart(token)
 			if mathMode != nil {
 				mathLabel = ""
+				mathPos = token.Pos
 				goto NextToken
 			}
 		} else {
 			// we only need to check this in once, in pass 1
 			if token.Type == tokenizer.TokenEmptyLine {
-				log.Println("maths environment not terminated\n" +
-					mathTokens.FormatMaths())
-				return ErrUnterminatedMath
+				warn(mathPos, "maths environment not terminated\n%s",
+					mathTokens.Source())
+				return fmt.Errorf("%s: %w", mathPos, ErrUnterminatedMath)
 			}
 
 			if mathMode(token) {
-				mathRenderer.AddFormula(mathEnv, mathTokens.FormatMaths())
+				if conv.needsImage(mathPos, mathEnv, mathTokens) {
+					mathRenderer, err := rs.Maths()
+					if err != nil {
+						return err
+					}
+					mathRenderer.AddFormula(ctx, mathEnv,
+						renderSource(mathTokens), mathTokens.Source())
+				}
 
 				mathMode = nil
 				mathTokens = nil
@@ -115,11 +122,14 @@ func (conv *converter) Pass1() error {
 			}
 		}
 
+		copyPreamble(token, &rs.mathsPreamble, &rs.tikzPreamble)
+
 		// handle cross-references
 		if token.Type == tokenizer.TokenMacro {
 			switch token.Name {
 			case "\\epubsection":
 				conv.Section.Inc(1)
+				conv.Part = 0
 				conv.resetCounters(1, "section")
 				ref = pos
 				refType = "Section"
@@ -130,21 +140,95 @@ func (conv *converter) Pass1() error {
 				ref = pos
 				refType = "Subsection"
 				refName = conv.Section.String()
+			case "\\documentclass":
+				// used to scale the TikZ pictures
+				rs.fontSize = fontSize(token.Args[0].String())
 			case "%tikz%":
+				options := token.Args[0].String()
 				picture := token.Args[1].String()
-				tikzRenderer.AddPicture(picture)
+				tikzRenderer, err := rs.Tikz()
+				if err != nil {
+					return err
+				}
+				tikzRenderer.AddPicture(ctx, options, picture)
+			case "\\includegraphics":
+				// missing files are reported during pass 2
+				path, err := conv.findGraphics(token.Args[1].String())
+				if err == nil {
+					var graphicsRenderer *graphics.Renderer
+					graphicsRenderer, err = rs.Graphics()
+					if err == nil {
+						err = graphicsRenderer.AddImage(ctx, path, token.Args[0].String())
+					}
+				}
+				if err != nil && !os.IsNotExist(err) {
+					warn(token.Pos, "%s", err)
+				}
 			case "\\begin":
 				name := token.Args[0].String()
-				if env, ok := conv.Envs[name]; ok {
+				if listTags[name] != "" {
+					conv.startList(name)
+				} else if t := floatType(name); t != "" {
+					float = &floatInfo{
+						Type:    t,
+						Pos:     pos,
+						Chapter: conv.currentChapter(),
+						Part:    conv.Part,
+					}
+				} else if env, ok := conv.Envs[name]; ok {
 					ref = pos
 					refType = env.Prefix
 					refName = conv.Counters[env.Counter].Inc()
 				}
+			case "\\end":
+				name := token.Args[0].String()
+				if listTags[name] != "" {
+					conv.endList()
+				} else if floatType(name) != "" {
+					float = nil
+				}
+			case "\\item":
+				customLabel := len(token.Args[0].Value) > 0
+				_, itemRef := conv.nextItem(customLabel)
+				if itemRef != "" {
+					ref = pos
+					refType = "Item"
+					refName = itemRef
+				}
+			case "\\caption":
+				if float != nil && float.Name == "" {
+					float.Name = conv.Counters[float.Type].Inc()
+					float.Caption = token.Args[0].Value
+					if len(float.Caption) == 0 {
+						float.Caption = token.Args[1].Value
+					}
+					conv.Floats = append(conv.Floats, float)
+					ref = float.Pos
+					refType = floatTypes[float.Type]
+					refName = float.Name
+				}
+				err = conv.addInlineMaths(ctx, rs, token.Args[0].Value)
+				if err == nil {
+					err = conv.addInlineMaths(ctx, rs, token.Args[1].Value)
+				}
+				if err != nil {
+					return err
+				}
+			case "%tabular%", "\\footnote":
+				// The text is converted during pass 2, but any
+				// inline maths must be rendered now.
+				err = conv.addInlineMaths(ctx, rs, token.Args[len(token.Args)-1].Value)
+				if err != nil {
+					return err
+				}
+			case "\\listoffigures", "\\listoftables":
+				conv.Part++
 			case "\\label":
 				label := token.Args[0].String()
 				target := &xRef{
 					Label:   label,
-					Chapter: conv.Section[0],
+					Chapter: conv.currentChapter(),
+					Part:    conv.Part,
 					ID:      xRefNormalise(label, labels),
 					Pos:     ref,
 					Type:    refType,
@@ -155,6 +239,7 @@ func (conv *converter) Pass1() error {
 			default:
 				m, ok := conv.Macros[token.Name]
 				if ok {
+					conv.Pos = token.Pos
 					// run for side-effects only, discard output
 					_ = m.HTMLOutput(token.Args, conv)
 				}
@@ -165,19 +250,55 @@ func (conv *converter) Pass1() error {
 		pos++
 	}
 
-	e1 := mathRenderer.Finish()
-	e2 := tikzRenderer.Finish()
+	failed, err := rs.Finish(ctx)
 	close(imageChan)
-	err = firstOf(e1, e2)
-	if err != nil {
-		return err
+	addErr := <-resChan
+	if ctx.Err() != nil {
+		// The images are incomplete, so the book cannot be written.
+		return ctx.Err()
 	}
-
-	err = <-resChan
 	if err != nil {
 		return err
 	}
+	conv.RenderErrors = append(conv.RenderErrors, failed...)
+	if addErr != nil {
+		return addErr
+	}
 
 	conv.Labels = labels
 	return nil
 }
+
+// addInlineMaths submits the inline formulas contained in `tokens` to
+// the maths renderer.  This is used for text which is only converted
+// during pass 2, for example the cells of a table.
+func (conv *converter) addInlineMaths(ctx context.Context, rs *renderers, tokens tokenizer.TokenList) error {
+	inMath := false
+	var mathPos scanner.Pos
+	var formula tokenizer.TokenList
+	for _, token := range tokens {
+		switch {
+		case token.Type == tokenizer.TokenOther && token.Name == "$":
+			if inMath && conv.needsImage(mathPos, "$", formula) {
+				r, err := rs.Maths()
+				if err != nil {
+					return err
+				}
+				r.AddFormula(ctx, "$", renderSource(formula), formula.Source())
+			}
+			formula = nil
+			mathPos = token.Pos
+			inMath = !inMath
+		case inMath:
+			formula = append(formula, token)
+		case token.Type == tokenizer.TokenMacro:
+			for _, arg := range token.Args {
+				err := conv.addInlineMaths(ctx, rs, arg.Value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -22,32 +22,35 @@ import (
 	"io"
 	"log"
 	"os"
-	"strconv"
 	"strings"
 
+	"github.com/seehuhn/epublatex/latex/scanner"
+	"github.com/seehuhn/epublatex/latex/tikz"
 	"github.com/seehuhn/epublatex/latex/tokenizer"
 )
 
 func (conv *converter) convertHTML(tokens tokenizer.TokenList) string {
 	var res []string
 	inMath := false
+	var mathPos scanner.Pos
 	var mathTokens tokenizer.TokenList
 	for _, token := range tokens {
 		switch {
 		case token.Type == tokenizer.TokenOther && token.Name == "$" && !inMath:
 			inMath = true
+			mathPos = token.Pos
 		case token.Type == tokenizer.TokenOther && token.Name == "$" && inMath:
 			inMath = false
-			body := mathTokens.FormatMaths()
-			res = append(res, conv.GetImage("$", body))
+			res = append(res, conv.formulaHTML(mathPos, "$", mathTokens))
 			mathTokens = nil
 		case inMath:
 			mathTokens = append(mathTokens, token)
 		case token.Type == tokenizer.TokenMacro:
 			if m, ok := conv.Macros[token.Name]; ok {
+				conv.Pos = token.Pos
 				res = append(res, m.HTMLOutput(token.Args, conv))
 			} else {
-				log.Printf("unknown macro %q", token.Name)
+				warn(token.Pos, "unknown macro %q", token.Name)
 			}
 		case token.Type == tokenizer.TokenSpace:
 			res = append(res, " ")
@@ -73,8 +76,10 @@ func (conv *converter) convertHTML(tokens tokenizer.TokenList) string {
 func (conv *converter) Pass2() (err error) {
 	var mathMode isEnd
 	var mathEnv string
+	var mathPos scanner.Pos
 	var mathTokens tokenizer.TokenList
 	var mathLabel string
+	floatPos := -1
 
 	w := newWriter(conv.Book, conv.SourceDir)
 	defer func() {
@@ -108,6 +113,7 @@ func (conv *converter) Pass2() (err error) {
 			mathEnv, mathMode = conv.IsMathStart(token)
 			if mathMode != nil {
 				mathLabel = ""
+				mathPos = token.Pos
 				goto NextToken
 			}
 		} else {
@@ -124,7 +130,7 @@ func (conv *converter) Pass2() (err error) {
 					w.WriteString(`<span class="latex-eqno" id="` + id +
 						`">(` + name + `)</span>`)
 				}
-				w.WriteString(conv.GetImage(mathEnv, mathTokens.FormatMaths()))
+				w.WriteString(conv.formulaHTML(mathPos, mathEnv, mathTokens))
 
 				mathMode = nil
 				mathTokens = nil
@@ -161,9 +167,14 @@ func (conv *converter) Pass2() (err error) {
 			case "\\epubsection":
 				conv.Section.Inc(1)
 				conv.resetCounters(1, "section")
+				// footnotes in the title belong to the new chapter
+				err := w.EndChapter()
+				if err != nil {
+					return err
+				}
 				title := conv.convertHTML(token.Args[1].Value)
 				id := conv.xRefLookup(pos)
-				err := w.AddSection(1, title, id)
+				err = w.AddSection(1, title, id)
 				if err != nil {
 					return err
 				}
@@ -177,17 +188,34 @@ func (conv *converter) Pass2() (err error) {
 					return err
 				}
 			case "%tikz%":
-				picture := token.Args[1].String()
-				w.WriteString(conv.GetImage("tikzpicture", picture))
+				key := tikz.Key(token.Args[0].String(), token.Args[1].String())
+				w.WriteString(conv.GetImage(token.Pos, "tikzpicture", key))
 
 			case "\\begin":
 				name := token.Args[0].String()
 
-				id := conv.xRefLookup(pos)
-				if id == "" {
-					id = "pos-" + strconv.Itoa(pos)
+				if tag := listTags[name]; tag != "" {
+					conv.startList(name)
+					conv.EnvStack = append(conv.EnvStack, name)
+					err := w.StartList(tag, cssPrefix+name)
+					if err != nil {
+						return err
+					}
+					break
+				}
+
+				if t := floatType(name); t != "" {
+					conv.EnvStack = append(conv.EnvStack, name)
+					floatPos = pos
+					err := w.StartFigure(conv.posID(pos), cssPrefix+t)
+					if err != nil {
+						return err
+					}
+					break
 				}
 
+				id := conv.posID(pos)
+
 				var classes []string
 				var pfx string
 				if env, ok := conv.Envs[name]; ok {
@@ -224,20 +252,78 @@ func (conv *converter) Pass2() (err error) {
 						}
 					}
 					if pos < 0 {
-						log.Println("environment", name, "was not open")
+						warn(token.Pos, "environment %s was not open", name)
 					} else {
-						log.Println("environment", conv.EnvStack[n-1],
-							"was not closed")
+						warn(token.Pos, "environment %s was not closed",
+							conv.EnvStack[n-1])
 						conv.EnvStack = conv.EnvStack[:pos]
 					}
 				}
 
-				if len(conv.EnvStack) > 0 {
+				if listTags[name] != "" {
+					conv.endList()
+					err := w.EndList()
+					if err != nil {
+						return err
+					}
+				} else if floatType(name) != "" {
+					floatPos = -1
+					err := w.EndFigure()
+					if err != nil {
+						return err
+					}
+				} else if len(conv.EnvStack) > 0 {
 					err := w.EndBlock()
 					if err != nil {
 						return err
 					}
 				}
+			case "\\item":
+				if len(conv.Lists) == 0 {
+					warn(token.Pos, "\\item outside a list")
+					break
+				}
+				var label string
+				customLabel := token.Args[0].Value
+				if len(customLabel) > 0 {
+					label = conv.convertHTML(customLabel)
+				} else {
+					label, _ = conv.nextItem(false)
+				}
+				err := w.StartItem(label, conv.xRefLookup(pos))
+				if err != nil {
+					return err
+				}
+			case "\\caption":
+				info := conv.findFloat(floatPos)
+				if info == nil {
+					warn(token.Pos, "\\caption outside figure or table")
+					break
+				}
+				label := floatTypes[info.Type] + noBreakSpace + info.Name + ":"
+				caption := `<span class="` + cssPrefix + `caption-label">` +
+					label + "</span> " + conv.convertHTML(token.Args[1].Value)
+				err := w.WriteCaption(caption)
+				if err != nil {
+					return err
+				}
+			case "\\listoffigures":
+				err := w.AddList("List of Figures", "lof",
+					conv.floatList("figure"))
+				if err != nil {
+					return err
+				}
+			case "\\listoftables":
+				err := w.AddList("List of Tables", "lot",
+					conv.floatList("table"))
+				if err != nil {
+					return err
+				}
+			case "\\clearpage", "\\newpage":
+				err = w.PageBreak()
+				if err != nil {
+					return err
+				}
 			case "\\bf":
 				if !w.state.isBold {
 					w.WriteString("<b>")
@@ -252,7 +338,8 @@ func (conv *converter) Pass2() (err error) {
 			default:
 				// TODO(voss): add a more general mechanism to select
 				// vertical mode.
-				verticalMode := token.Name == "%verbatim%"
+				verticalMode := token.Name == "%verbatim%" ||
+					token.Name == "%tabular%"
 				s := conv.convertHTML(tokenizer.TokenList{token})
 				if verticalMode {
 					err := w.WriteVertical(s)
@@ -17,7 +17,7 @@
 package latex
 
 func addAmsmathMacros(conv *converter, options string) {
-	// TODO(voss): copy this into the TeX file
+	// copied into the TeX files for rendering formulas during pass 1
 	conv.Macros["\\DeclareMathOperator"] = mIgnore
 
 	conv.Envs["equation*"] = &environment{
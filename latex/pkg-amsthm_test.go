@@ -17,6 +17,7 @@
 package latex
 
 import (
+	"context"
 	"testing"
 
 	"github.com/seehuhn/epublatex/latex/tokenizer"
@@ -46,7 +47,7 @@ func TestNewTheorem(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = conv.Pass1()
+	err = conv.Pass1(context.Background())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,24 @@
+// pkg-tables.go - handle the LaTeX packages used for tables
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+func init() {
+	// The table macros of these packages are handled by mTabular.
+	addPackage("booktabs", addNoMacros)
+	addPackage("multirow", addNoMacros)
+	addPackage("tabularx", addNoMacros)
+}
@@ -17,6 +17,10 @@
 package latex
 
 func addTikzMacros(conv *converter, options string) {
+	// copied into the TeX files for rendering pictures during pass 1
+	conv.Macros["\\tikzset"] = mIgnore
+	conv.Macros["\\usetikzlibrary"] = mIgnore
+
 	// TODO(voss): add this
 	// conv.Envs["tikzpicture"] = ...
 }
@@ -0,0 +1,101 @@
+// preamble.go - copy preamble material into the rendered TeX files
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+// preambleAdder is implemented by the renderers for formulas and TikZ
+// pictures.
+type preambleAdder interface {
+	AddPreamble(line string)
+}
+
+// skipPackages lists packages which are not loaded when formulas and
+// TikZ pictures are rendered, because they only affect the page
+// layout or would clash with the packages used by the renderers.
+var skipPackages = map[string]bool{
+	"fancyhdr": true,
+	"geometry": true,
+	"hyperref": true,
+	"preview":  true,
+	"titlesec": true,
+	"tocloft":  true,
+}
+
+// copyPreamble passes preamble material from the document on to the
+// renderers.  User-defined macros are not copied, since they are
+// expanded by the tokenizer before formulas and pictures reach the
+// renderers.
+func copyPreamble(token *tokenizer.Token, maths, tikz preambleAdder) {
+	if token.Type != tokenizer.TokenMacro {
+		return
+	}
+	switch token.Name {
+	case "\\usepackage":
+		options := token.Args[0].String()
+		var pkgNames []string
+		for _, pkgName := range strings.Split(token.Args[1].String(), ",") {
+			pkgName = strings.TrimSpace(pkgName)
+			if pkgName != "" && !skipPackages[pkgName] {
+				pkgNames = append(pkgNames, pkgName)
+			}
+		}
+		if len(pkgNames) == 0 {
+			return
+		}
+		line := "\\usepackage"
+		if options != "" {
+			line += "[" + options + "]"
+		}
+		line += "{" + strings.Join(pkgNames, ",") + "}"
+		maths.AddPreamble(line)
+		tikz.AddPreamble(line)
+	case "\\DeclareMathOperator":
+		line := "\\DeclareMathOperator"
+		if len(token.Args[0].Value) > 0 {
+			line += "*"
+		}
+		line += "{" + token.Args[1].String() + "}{" + token.Args[2].String() + "}"
+		maths.AddPreamble(line)
+		tikz.AddPreamble(line)
+	case "\\usetikzlibrary":
+		tikz.AddPreamble("\\usetikzlibrary{" + token.Args[0].String() + "}")
+	case "\\tikzset":
+		tikz.AddPreamble("\\tikzset{" + token.Args[0].String() + "}")
+	}
+}
+
+// fontSize returns the font size selected by the options of
+// \documentclass, in TeX points, or 0 if no size is given.
+func fontSize(options string) float64 {
+	for _, opt := range strings.Split(options, ",") {
+		opt = strings.TrimSpace(opt)
+		if !strings.HasSuffix(opt, "pt") {
+			continue
+		}
+		size, err := strconv.ParseFloat(strings.TrimSuffix(opt, "pt"), 64)
+		if err == nil && size > 0 {
+			return size
+		}
+	}
+	return 0
+}
@@ -0,0 +1,131 @@
+// preamble_test.go - unit tests for preamble.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+type preambleLines []string
+
+func (lines *preambleLines) AddPreamble(line string) {
+	*lines = append(*lines, line)
+}
+
+func TestCopyPreamble(t *testing.T) {
+	src := `\usepackage[utf8]{inputenc}
+\usepackage{amsmath,bm}
+\usepackage[margin=1in]{geometry}
+\usepackage{tikz}
+\usetikzlibrary{arrows,calc}
+\tikzset{every node/.style={font=\small}}
+\DeclareMathOperator*{\argmax}{arg\,max}
+\DeclareMathOperator{\tr}{tr}
+\newcommand{\R}{\mathbb{R}}
+`
+
+	toks := tokenizer.NewTokenizer()
+	defer toks.Close()
+	toks.Prepend([]byte(src), "test")
+	c := make(chan *tokenizer.Token)
+	go func() {
+		err := toks.ParseTex(c)
+		if err != nil {
+			t.Error(err)
+		}
+		close(c)
+	}()
+	var maths, tikz preambleLines
+	for tok := range c {
+		copyPreamble(tok, &maths, &tikz)
+	}
+
+	expected := preambleLines{
+		`\usepackage[utf8]{inputenc}`,
+		`\usepackage{amsmath}`,
+		`\usepackage{bm}`,
+		`\usepackage{tikz}`,
+		`\DeclareMathOperator*{\argmax}{arg\,max}`,
+		`\DeclareMathOperator{\tr}{tr}`,
+	}
+	if !reflect.DeepEqual(maths, expected) {
+		t.Errorf("wrong maths preamble:\n%q\nexpected\n%q", maths, expected)
+	}
+	expected = append(expected[:4:4], `\usetikzlibrary{arrows,calc}`,
+		`\tikzset{every node/.style={font=\small}}`)
+	expected = append(expected, maths[4:]...)
+	if !reflect.DeepEqual(tikz, expected) {
+		t.Errorf("wrong TikZ preamble:\n%q\nexpected\n%q", tikz, expected)
+	}
+}
+
+func TestCopyPreamblePackageList(t *testing.T) {
+	testCases := []struct {
+		options, packages string
+		expected          []string
+	}{
+		{"", "amsmath", []string{`\usepackage{amsmath}`}},
+		{"", "geometry", nil},
+		{"", "amsmath, geometry,bm", []string{`\usepackage{amsmath,bm}`}},
+		{"margin=1in", "geometry,hyperref", nil},
+		{"T1", "fontenc,", []string{`\usepackage[T1]{fontenc}`}},
+	}
+	for _, test := range testCases {
+		tok := &tokenizer.Token{
+			Type: tokenizer.TokenMacro,
+			Name: "\\usepackage",
+			Args: []*tokenizer.Arg{
+				{Optional: true, Value: tokenizer.TokenList{
+					{Type: tokenizer.TokenWord, Name: test.options},
+				}},
+				{Value: tokenizer.TokenList{
+					{Type: tokenizer.TokenWord, Name: test.packages},
+				}},
+			},
+		}
+		var maths, tikz preambleLines
+		copyPreamble(tok, &maths, &tikz)
+		if !reflect.DeepEqual([]string(maths), test.expected) ||
+			!reflect.DeepEqual([]string(tikz), test.expected) {
+			t.Errorf("%q: expected %q, got %q and %q",
+				test.packages, test.expected, maths, tikz)
+		}
+	}
+}
+
+func TestFontSize(t *testing.T) {
+	testCases := []struct {
+		options string
+		size    float64
+	}{
+		{"", 0},
+		{"a4paper", 0},
+		{"12pt", 12},
+		{"a4paper, 11pt,twoside", 11},
+		{"10.5pt", 10.5},
+		{"xpt", 0},
+	}
+	for _, test := range testCases {
+		size := fontSize(test.options)
+		if size != test.size {
+			t.Errorf("%q: expected %g, got %g", test.options, test.size, size)
+		}
+	}
+}
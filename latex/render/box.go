@@ -0,0 +1,72 @@
+// box.go - report the size of TeX boxes to the render queue
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"image"
+	"regexp"
+	"strconv"
+)
+
+// Box gives the dimensions of a TeX box in TeX points.  Height is the
+// extent above the baseline, Depth the extent below the baseline.
+type Box struct {
+	Width  float64
+	Height float64
+	Depth  float64
+}
+
+// Page is a page of LaTeX output, converted into an image.
+type Page struct {
+	Image image.Image
+
+	// Box gives the size reported for this page using the
+	// \epublatexmeasure macro, or nil if no size was reported.
+	Box *Box
+}
+
+// MeasureMacro defines the TeX macro \epublatexmeasure, which takes a
+// box register number as its argument.  The macro reports the size of
+// the box for the current page to the render queue, which passes the
+// size on as the Box field of the corresponding Page.  The definition
+// should be included in the preamble of the TeX file.
+const MeasureMacro = `\newcommand\epublatexmeasure[1]{\typeout{epublatex box \the\count0: width=\the\wd#1, height=\the\ht#1, depth=\the\dp#1}}`
+
+var boxLine = regexp.MustCompile(
+	`epublatex box ([0-9]+): width=([-0-9.]+)pt, height=([-0-9.]+)pt, depth=([-0-9.]+)pt`)
+
+// parseBoxes extracts the box sizes reported by \epublatexmeasure from
+// the output of a TeX run.  The result maps page numbers to sizes.
+func parseBoxes(output []byte) map[int]*Box {
+	res := make(map[int]*Box)
+	for _, m := range boxLine.FindAllSubmatch(output, -1) {
+		page, err := strconv.Atoi(string(m[1]))
+		if err != nil {
+			continue
+		}
+		res[page] = parseBox(m[2:])
+	}
+	return res
+}
+
+func parseBox(m [][]byte) *Box {
+	box := &Box{}
+	box.Width, _ = strconv.ParseFloat(string(m[0]), 64)
+	box.Height, _ = strconv.ParseFloat(string(m[1]), 64)
+	box.Depth, _ = strconv.ParseFloat(string(m[2]), 64)
+	return box
+}
@@ -0,0 +1,40 @@
+// box_test.go - unit tests for box.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import "testing"
+
+func TestParseBoxes(t *testing.T) {
+	output := `This is pdfTeX, Version 3.14159265-2.6-1.40.20 (TeX Live 2019)
+(./job.tex
+epublatex box 1: width=5.71527pt, height=4.30554pt, depth=0.0pt
+[1{/var/lib/texmf/fonts/map/pdftex/updmap/pdftex.map}]
+epublatex box 2: width=31.1111pt, height=7.5pt, depth=2.5pt
+[2] )
+Output written on job.pdf (2 pages, 11502 bytes).
+`
+	boxes := parseBoxes([]byte(output))
+	if len(boxes) != 2 {
+		t.Fatalf("wrong number of boxes: %d", len(boxes))
+	}
+	if b := boxes[1]; b.Width != 5.71527 || b.Height != 4.30554 || b.Depth != 0 {
+		t.Errorf("wrong box 1: %v", b)
+	}
+	if b := boxes[2]; b.Width != 31.1111 || b.Height != 7.5 || b.Depth != 2.5 {
+		t.Errorf("wrong box 2: %v", b)
+	}
+}
@@ -0,0 +1,54 @@
+// command.go - run external programs with a timeout
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+)
+
+// runCommand runs cmd and returns its output.  If combined is true,
+// the output includes the error output of the program.  When ctx is
+// cancelled before the program has finished, the program and all of
+// its child processes are killed.
+func runCommand(ctx context.Context, cmd *exec.Cmd, combined bool) ([]byte, error) {
+	out := &bytes.Buffer{}
+	cmd.Stdout = out
+	if combined {
+		cmd.Stderr = out
+	}
+	setProcessGroup(cmd)
+
+	err := cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		return out.Bytes(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return out.Bytes(), ctx.Err()
+	}
+}
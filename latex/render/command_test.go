@@ -0,0 +1,52 @@
+// command_test.go - unit tests for command.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"context"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestRunCommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("child processes are not killed on Windows")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The background process keeps the output open, so runCommand
+	// only returns early if the whole process group is killed.
+	cmd := exec.Command("sh", "-c", "sleep 10 & echo started; sleep 10")
+	start := time.Now()
+	output, err := runCommand(ctx, cmd, false)
+	if err != context.DeadlineExceeded {
+		t.Errorf("wrong error %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("process group not killed")
+	}
+	if string(output) != "started\n" {
+		t.Errorf("wrong output %q", output)
+	}
+}
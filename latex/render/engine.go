@@ -0,0 +1,296 @@
+// engine.go - the programs used to convert TeX files into images
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	texEngine = flag.String("latex-engine", "pdflatex",
+		"TeX engine used to render formulas and pictures: \"pdflatex\", "+
+			"\"lualatex\", \"xelatex\", or \"fake\" for testing")
+	rasteriser = flag.String("latex-rasteriser", "gs",
+		"program used to convert PDF pages to PNG images: \"gs\", "+
+			"\"pdftocairo\" or \"mutool\"")
+)
+
+// Engine converts the TeX file "job.tex" in a job directory into
+// images, one image per page of output.
+type Engine interface {
+	// MakePNG converts the pages into PNG images "img1.png",
+	// "img2.png", ..., in the job directory, using the given
+	// resolution in pixels per inch.  The returned map gives the
+	// box sizes reported using the macro defined in MeasureMacro.
+	// The conversion is aborted when ctx is cancelled.
+	MakePNG(ctx context.Context, dir string, resolution int) (map[int]*Box, error)
+
+	// MakeSVG converts the pages into SVG images "img1.svg",
+	// "img2.svg", ..., in the job directory.  The returned map gives
+	// the size of the preview box for pages which use the "preview"
+	// package with the "tightpage" option.  The conversion is
+	// aborted when ctx is cancelled.
+	MakeSVG(ctx context.Context, dir string) (map[int]*Box, error)
+
+	// Version describes the programs used by the engine, including
+	// the version of the TeX engine.  Cached images should be
+	// discarded when the version changes.
+	Version() string
+
+	// SetSearchPath sets the directories where the TeX engine looks
+	// for input files, like packages loaded by the preamble, before
+	// the directories of the TeX installation.  The method must be
+	// called before the first conversion.
+	SetSearchPath(dirs []string)
+}
+
+// NewEngine returns the Engine selected by the -latex-engine and
+// -latex-rasteriser command line options.
+func NewEngine() (Engine, error) {
+	if *texEngine == "fake" {
+		return FakeEngine{}, nil
+	}
+	return NewToolchain(*texEngine, *rasteriser)
+}
+
+// Toolchain is an Engine which runs a TeX engine and converts the
+// output into images using external programs.
+type Toolchain struct {
+	tex        string
+	rasteriser string
+	searchPath []string
+
+	versionOnce sync.Once
+	version     string
+}
+
+// NewToolchain returns an Engine which uses the given programs.  The
+// TeX engine must be one of "pdflatex", "lualatex" and "xelatex".
+// The rasteriser, used to convert PDF pages into PNG images, must be
+// one of "gs" (Ghostscript), "pdftocairo" and "mutool".  SVG images
+// are always produced using dvisvgm.
+func NewToolchain(tex, rasteriser string) (*Toolchain, error) {
+	switch tex {
+	case "pdflatex", "lualatex", "xelatex":
+		// pass
+	default:
+		return nil, fmt.Errorf("invalid TeX engine %q", tex)
+	}
+	switch rasteriser {
+	case "gs", "pdftocairo", "mutool":
+		// pass
+	default:
+		return nil, fmt.Errorf("invalid rasteriser %q", rasteriser)
+	}
+	return &Toolchain{
+		tex:        tex,
+		rasteriser: rasteriser,
+	}, nil
+}
+
+// MakePNG implements the Engine interface.
+func (tc *Toolchain) MakePNG(ctx context.Context, dir string, resolution int) (map[int]*Box, error) {
+	// convert TeX -> PDF
+	ltx := exec.Command(tc.tex, "-interaction=nonstopmode", "job.tex")
+	ltx.Dir = dir
+	// avoid line breaks in the box sizes written to the log
+	ltx.Env = append(tc.texEnv(), "max_print_line=1000")
+	output, err := runCommand(ctx, ltx, false)
+	if err != nil {
+		return nil, &Error{
+			Program: tc.tex,
+			Err:     err,
+			Log:     texLogExcerpt(output),
+		}
+	}
+	boxes := parseBoxes(output)
+
+	// convert PDF -> PNG
+	res := strconv.Itoa(resolution)
+	var cmd *exec.Cmd
+	switch tc.rasteriser {
+	case "gs":
+		cmd = exec.Command("gs", "-dSAFER", "-dBATCH", "-dNOPAUSE",
+			"-r"+res, "-sDEVICE=pngalpha", "-dTextAlphaBits=4",
+			"-sOutputFile="+imgNames, "job.pdf")
+	case "pdftocairo":
+		cmd = exec.Command("pdftocairo", "-png", "-transp", "-r", res,
+			"job.pdf", "img")
+	case "mutool":
+		cmd = exec.Command("mutool", "draw", "-q", "-r", res, "-c", "rgba",
+			"-o", imgNames, "job.pdf")
+	}
+	cmd.Dir = dir
+	output, err = runCommand(ctx, cmd, true)
+	if err != nil {
+		return nil, &Error{
+			Program: tc.rasteriser,
+			Err:     err,
+			Log:     tailExcerpt(output),
+		}
+	}
+	if tc.rasteriser == "pdftocairo" {
+		err = renamePdftocairo(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return boxes, nil
+}
+
+// MakeSVG implements the Engine interface.
+func (tc *Toolchain) MakeSVG(ctx context.Context, dir string) (map[int]*Box, error) {
+	// convert TeX -> DVI
+	var ltx *exec.Cmd
+	dviFile := "job.dvi"
+	switch tc.tex {
+	case "pdflatex":
+		ltx = exec.Command("latex", "-interaction=nonstopmode", "job.tex")
+	case "lualatex":
+		ltx = exec.Command("lualatex", "--output-format=dvi",
+			"-interaction=nonstopmode", "job.tex")
+	case "xelatex":
+		ltx = exec.Command("xelatex", "-no-pdf",
+			"-interaction=nonstopmode", "job.tex")
+		dviFile = "job.xdv"
+	}
+	ltx.Dir = dir
+	ltx.Env = tc.texEnv()
+	output, err := runCommand(ctx, ltx, false)
+	if err != nil {
+		return nil, &Error{
+			Program: ltx.Args[0],
+			Err:     err,
+			Log:     texLogExcerpt(output),
+		}
+	}
+
+	// convert DVI -> SVG
+	dvisvgm := exec.Command("dvisvgm", "--no-fonts",
+		"--page=1-", "--output="+strings.Replace(svgNames, "%d", "%p", 1),
+		dviFile)
+	dvisvgm.Dir = dir
+	output, err = runCommand(ctx, dvisvgm, true)
+	if err != nil {
+		return nil, &Error{
+			Program: "dvisvgm",
+			Err:     err,
+			Log:     tailExcerpt(output),
+		}
+	}
+	err = renameDvisvgm(dir)
+	if err != nil {
+		return nil, err
+	}
+	return parseExtents(output), nil
+}
+
+// SetSearchPath implements the Engine interface.
+func (tc *Toolchain) SetSearchPath(dirs []string) {
+	tc.searchPath = dirs
+}
+
+// texEnv returns the environment for running the TeX engine.  The
+// TEXINPUTS variable lists the job directory and the directories of
+// the search path; the empty entry at the end stands for the
+// directories of the TeX installation.
+func (tc *Toolchain) texEnv() []string {
+	dirs := []string{"."}
+	for _, dir := range tc.searchPath {
+		// the TeX engine runs in the job directory
+		abs, err := filepath.Abs(dir)
+		if err == nil {
+			dir = abs
+		}
+		dirs = append(dirs, dir)
+	}
+	dirs = append(dirs, "")
+	texInputs := strings.Join(dirs, string(os.PathListSeparator))
+	return append(os.Environ(), "TEXINPUTS="+texInputs)
+}
+
+// Version implements the Engine interface.  The result consists of
+// the first line of the output of "tex --version", followed by the
+// name of the rasteriser.
+func (tc *Toolchain) Version() string {
+	tc.versionOnce.Do(func() {
+		texVersion := tc.tex
+		out, err := exec.Command(tc.tex, "--version").Output()
+		if err == nil {
+			line := strings.SplitN(string(out), "\n", 2)[0]
+			if line = strings.TrimSpace(line); line != "" {
+				texVersion = line
+			}
+		}
+		tc.version = texVersion + "; " + tc.rasteriser
+	})
+	return tc.version
+}
+
+var (
+	pdftocairoName = regexp.MustCompile(`^img-0*([0-9]+)\.png$`)
+	dvisvgmName    = regexp.MustCompile(`^img0*([0-9]+)\.svg$`)
+)
+
+// renamePdftocairo renames the images written by pdftocairo, which
+// pads the page numbers with zeros, to the names used by the queue.
+func renamePdftocairo(dir string) error {
+	return renamePages(dir, "img-*.png", pdftocairoName, imgNames)
+}
+
+// renameDvisvgm renames the images written by dvisvgm, which pads the
+// page numbers with zeros to the width of the largest page number,
+// to the names used by the queue.
+func renameDvisvgm(dir string) error {
+	return renamePages(dir, "img*.svg", dvisvgmName, svgNames)
+}
+
+// renamePages renames the files in `dir` which match both `glob` and
+// `pattern` to the names given by the format string `names`.  The
+// first subexpression of `pattern` must match the page number.
+func renamePages(dir, glob string, pattern *regexp.Regexp, names string) error {
+	fileNames, err := filepath.Glob(filepath.Join(dir, glob))
+	if err != nil {
+		return err
+	}
+	for _, name := range fileNames {
+		m := pattern.FindStringSubmatch(filepath.Base(name))
+		if m == nil {
+			continue
+		}
+		pageNo, _ := strconv.Atoi(m[1])
+		newName := filepath.Join(dir, fmt.Sprintf(names, pageNo))
+		if newName == name {
+			continue
+		}
+		err = os.Rename(name, newName)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
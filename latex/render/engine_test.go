@@ -0,0 +1,185 @@
+// engine_test.go - unit tests for engine.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestNewToolchain(t *testing.T) {
+	for _, tex := range []string{"pdflatex", "lualatex", "xelatex"} {
+		for _, r := range []string{"gs", "pdftocairo", "mutool"} {
+			_, err := NewToolchain(tex, r)
+			if err != nil {
+				t.Errorf("%s/%s: %s", tex, r, err)
+			}
+		}
+	}
+	if _, err := NewToolchain("tex", "gs"); err == nil {
+		t.Error("invalid TeX engine not detected")
+	}
+	if _, err := NewToolchain("pdflatex", "convert"); err == nil {
+		t.Error("invalid rasteriser not detected")
+	}
+}
+
+func TestRenamePdftocairo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epublatex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"img-01.png", "img-02.png", "img-10.png"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = renamePdftocairo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"img1.png", "img2.png", "img10.png"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestRenameDvisvgm(t *testing.T) {
+	dir, err := ioutil.TempDir("", "epublatex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"img01.svg", "img02.svg", "img10.svg"} {
+		err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = renameDvisvgm(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"img1.svg", "img2.svg", "img10.svg"} {
+		_, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestToolchainVersion(t *testing.T) {
+	tc, err := NewToolchain("xelatex", "mutool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	version := tc.Version()
+	if !strings.HasSuffix(version, "; mutool") || len(version) <= len("; mutool") {
+		t.Errorf("wrong version string %q", version)
+	}
+	if tc.Version() != version {
+		t.Error("version changed between calls")
+	}
+}
+
+// fakeLatex is a shell script which stands in for pdflatex.  Like
+// TeX, the script looks for the package "mymacros.sty" in the
+// directories listed in TEXINPUTS.
+const fakeLatex = `#!/bin/sh
+IFS=:
+for dir in $TEXINPUTS; do
+	if [ -f "$dir/mymacros.sty" ]; then
+		exit 0
+	fi
+done
+echo "! LaTeX Error: File mymacros.sty not found."
+exit 1
+`
+
+func TestToolchainSearchPath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shell scripts cannot be run on Windows")
+	}
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+
+	tmp, err := ioutil.TempDir("", "epublatex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	binDir := filepath.Join(tmp, "bin")
+	srcDir := filepath.Join(tmp, "src")
+	jobDir := filepath.Join(tmp, "job")
+	files := map[string]string{
+		filepath.Join(binDir, "pdflatex"):     fakeLatex,
+		filepath.Join(binDir, "gs"):           "#!/bin/sh\n",
+		filepath.Join(srcDir, "mymacros.sty"): "\\ProvidesPackage{mymacros}\n",
+		filepath.Join(jobDir, "job.tex"):      "\\usepackage{mymacros}\n",
+	}
+	for name, body := range files {
+		err := os.MkdirAll(filepath.Dir(name), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(name, []byte(body), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", binDir+string(os.PathListSeparator)+oldPath)
+	defer os.Setenv("PATH", oldPath)
+
+	tc, err := NewToolchain("pdflatex", "gs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tc.MakePNG(context.Background(), jobDir, 72)
+	if err == nil {
+		t.Error("package found without search path")
+	}
+
+	// The search path is given relative to the current directory,
+	// but TeX runs in the job directory.
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relSrcDir, err := filepath.Rel(wd, srcDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc.SetSearchPath([]string{relSrcDir})
+	_, err = tc.MakePNG(context.Background(), jobDir, 72)
+	if err != nil {
+		t.Error(err)
+	}
+}
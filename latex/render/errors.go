@@ -0,0 +1,135 @@
+// errors.go - report failed rendering jobs
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxTeXErrors = 3
+	maxTailLines = 10
+)
+
+// Error describes a rendering job where one of the external programs
+// failed.
+type Error struct {
+	// Program is the name of the program which failed, for example
+	// "pdflatex".
+	Program string
+
+	// Err is the error returned when running the program.
+	Err error
+
+	// Log contains the part of the program output which explains the
+	// failure.
+	Log string
+}
+
+func (e *Error) Error() string {
+	msg := "running " + e.Program + " failed: " + e.Err.Error()
+	if e.Log != "" {
+		msg += "\n" + e.Log
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors lists the errors of several failed rendering jobs.
+type Errors []error
+
+// Err returns the list as an error value, or nil if the list is
+// empty.
+func (errs Errors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Error lists the first line of every error message.  Use the
+// elements of the list to get the complete messages.
+func (errs Errors) Error() string {
+	msg := fmt.Sprintf("%d rendering jobs failed:", len(errs))
+	if len(errs) == 1 {
+		msg = "1 rendering job failed:"
+	}
+	for _, err := range errs {
+		line := err.Error()
+		if i := strings.Index(line, "\n"); i >= 0 {
+			line = line[:i]
+		}
+		msg += "\n  " + line
+	}
+	return msg
+}
+
+var texLineNo = regexp.MustCompile(`^l\.[0-9]+ `)
+
+// texLogExcerpt extracts the error messages from the output of a TeX
+// run.  Each TeX error message starts with a line beginning with "!"
+// and ends with the two lines showing where in the input the error
+// was found.  If no error messages are found, the last lines of the
+// output are returned instead.
+func texLogExcerpt(output []byte) string {
+	lines := strings.Split(string(output), "\n")
+	var res []string
+	numErrors := 0
+	inError := false
+	for i := 0; i < len(lines) && numErrors < maxTeXErrors; i++ {
+		line := strings.TrimRight(lines[i], "\r")
+		if strings.HasPrefix(line, "!") {
+			inError = true
+		}
+		if !inError {
+			continue
+		}
+		res = append(res, line)
+		if texLineNo.MatchString(line) {
+			if i+1 < len(lines) {
+				res = append(res, strings.TrimRight(lines[i+1], "\r"))
+				i++
+			}
+			inError = false
+			numErrors++
+		}
+	}
+	if len(res) == 0 {
+		return tailExcerpt(output)
+	}
+	return strings.Join(res, "\n")
+}
+
+// tailExcerpt returns the last few non-empty lines of the output of a
+// program.
+func tailExcerpt(output []byte) string {
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return ""
+	}
+	lines := strings.Split(string(output), "\n")
+	if len(lines) > maxTailLines {
+		lines = lines[len(lines)-maxTailLines:]
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,87 @@
+// errors_test.go - unit tests for errors.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestTeXLogExcerpt(t *testing.T) {
+	output := `This is pdfTeX, Version 3.14159265-2.6-1.40.16 (TeX Live 2015/Debian)
+entering extended mode
+(./job.tex
+LaTeX2e <2016/02/01>
+! Undefined control sequence.
+l.17 \sbox0{$\foo
+                 x$}\epublatexmeasure0
+[1] [2]
+! Missing $ inserted.
+<inserted text>
+                $
+l.23 \end{document}
+
+Output written on job.pdf (2 pages, 12345 bytes).
+`
+	expected := `! Undefined control sequence.
+l.17 \sbox0{$\foo
+                 x$}\epublatexmeasure0
+! Missing $ inserted.
+<inserted text>
+                $
+l.23 \end{document}
+`
+	res := texLogExcerpt([]byte(output))
+	if res != expected {
+		t.Errorf("wrong excerpt:\n%s\nexpected\n%s", res, expected)
+	}
+
+	res = texLogExcerpt([]byte("line 1\nline 2\nno errors here\n\n"))
+	if res != "line 1\nline 2\nno errors here" {
+		t.Errorf("wrong fallback excerpt: %q", res)
+	}
+}
+
+func TestError(t *testing.T) {
+	cause := errors.New("exit status 1")
+	err := error(&Error{Program: "pdflatex", Err: cause, Log: "! Oops."})
+	if !errors.Is(err, cause) {
+		t.Error("cause of the error not found")
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "running pdflatex failed: exit status 1\n") ||
+		!strings.HasSuffix(msg, "! Oops.") {
+		t.Errorf("wrong error message %q", msg)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	if errs.Err() != nil {
+		t.Error("empty list is not nil")
+	}
+	errs = append(errs, &Error{Program: "pdflatex", Err: errors.New("exit status 1"),
+		Log: "! Undefined control sequence."})
+	errs = append(errs, errors.New("job 2: timeout"))
+	expected := "2 rendering jobs failed:\n" +
+		"  running pdflatex failed: exit status 1\n" +
+		"  job 2: timeout"
+	if msg := errs.Err().Error(); msg != expected {
+		t.Errorf("wrong message:\n%s\nexpected\n%s", msg, expected)
+	}
+}
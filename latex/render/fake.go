@@ -0,0 +1,205 @@
+// fake.go - an engine which works without a TeX installation
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FakeErrorMacro can be used in TeX code to make the FakeEngine fail,
+// like a real TeX engine fails on an undefined control sequence.
+const FakeErrorMacro = `\epublatexfakeerror`
+
+// FakeHangMacro can be used in TeX code to make the FakeEngine wait
+// until the job is aborted, like a TeX engine waiting for input.
+const FakeHangMacro = `\epublatexfakehang`
+
+// Sizes of the synthetic pages, in TeX points.
+const (
+	fakePageWidth  = 40
+	fakePageHeight = 20
+	fakeBaseline   = 14
+
+	fakeWidth  = 10
+	fakeHeight = 7
+	fakeDepth  = 2
+)
+
+var errFakeTeX = errors.New("exit status 1")
+
+// FakeEngine is an Engine which produces synthetic images, without
+// running any external programs.  The number of pages is determined
+// by the \newpage commands, or by the "preview" environments, in the
+// TeX file.  Every page shows a black box of 10pt width, 7pt height
+// and 2pt depth, preceded by the baseline marker used for inline
+// formulas if the page contains a \vrule.  This is intended for
+// testing the conversion without a TeX installation.
+type FakeEngine struct{}
+
+// MakePNG implements the Engine interface.
+func (FakeEngine) MakePNG(ctx context.Context, dir string, resolution int) (map[int]*Box, error) {
+	pages, err := fakePages(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	pix := func(pt float64) int {
+		return int(pt/72.27*float64(resolution) + 0.5)
+	}
+	black := image.NewUniform(color.Black)
+	boxes := make(map[int]*Box)
+	for i, page := range pages {
+		pageNo := i + 1
+		img := image.NewNRGBA(image.Rect(0, 0,
+			pix(fakePageWidth), pix(fakePageHeight)))
+		x0 := 0
+		if strings.Contains(page, `\vrule`) {
+			// the baseline marker
+			marker := image.Rect(0, pix(fakeBaseline-4.3),
+				pix(6), pix(fakeBaseline))
+			draw.Draw(img, marker, black, image.ZP, draw.Src)
+			x0 = pix(12)
+		}
+		ink := image.Rect(x0, pix(fakeBaseline-fakeHeight),
+			x0+pix(fakeWidth), pix(fakeBaseline+fakeDepth))
+		draw.Draw(img, ink, black, image.ZP, draw.Src)
+
+		fd, err := os.Create(filepath.Join(dir, fmt.Sprintf(imgNames, pageNo)))
+		if err != nil {
+			return nil, err
+		}
+		err = png.Encode(fd, img)
+		e2 := fd.Close()
+		if err == nil {
+			err = e2
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.Contains(page, `\epublatexmeasure`) {
+			boxes[pageNo] = fakeBox()
+		}
+	}
+	return boxes, nil
+}
+
+// MakeSVG implements the Engine interface.
+func (FakeEngine) MakeSVG(ctx context.Context, dir string) (map[int]*Box, error) {
+	pages, err := fakePages(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	box := fakeBox()
+	svg := fmt.Sprintf(`<?xml version='1.0' encoding='UTF-8'?>
+<svg version='1.1' xmlns='http://www.w3.org/2000/svg' width='%gpt' height='%gpt' viewBox='0 0 %g %g'>
+<rect x='0' y='0' width='%g' height='%g'/>
+</svg>
+`, box.Width, box.Height+box.Depth, box.Width, box.Height+box.Depth,
+		box.Width, box.Height+box.Depth)
+	// Like dvisvgm, pad the page numbers to the width of the largest
+	// page number.
+	width := len(strconv.Itoa(len(pages)))
+	boxes := make(map[int]*Box)
+	for i := range pages {
+		pageNo := i + 1
+		fileName := filepath.Join(dir, fmt.Sprintf("img%0*d.svg", width, pageNo))
+		err := ioutil.WriteFile(fileName, []byte(svg), 0666)
+		if err != nil {
+			return nil, err
+		}
+		boxes[pageNo] = fakeBox()
+	}
+	err = renameDvisvgm(dir)
+	if err != nil {
+		return nil, err
+	}
+	return boxes, nil
+}
+
+// Version implements the Engine interface.
+func (FakeEngine) Version() string {
+	return "fake"
+}
+
+// SetSearchPath implements the Engine interface.  The FakeEngine
+// reads no input files except "job.tex", so the search path is not
+// used.
+func (FakeEngine) SetSearchPath(dirs []string) {}
+
+func fakeBox() *Box {
+	return &Box{
+		Width:  fakeWidth,
+		Height: fakeHeight,
+		Depth:  fakeDepth,
+	}
+}
+
+// fakePages splits the body of the file "job.tex" into pages.
+func fakePages(ctx context.Context, dir string) ([]string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "job.tex"))
+	if err != nil {
+		return nil, err
+	}
+	body := string(data)
+	if i := strings.Index(body, `\begin{document}`); i >= 0 {
+		body = body[i+len(`\begin{document}`):]
+	}
+	if i := strings.Index(body, `\end{document}`); i >= 0 {
+		body = body[:i]
+	}
+
+	if strings.Contains(body, FakeHangMacro) {
+		<-ctx.Done()
+		return nil, &Error{
+			Program: "fake",
+			Err:     ctx.Err(),
+		}
+	}
+	if strings.Contains(body, FakeErrorMacro) {
+		return nil, &Error{
+			Program: "fake",
+			Err:     errFakeTeX,
+			Log:     "! Undefined control sequence.\nl.1 " + FakeErrorMacro,
+		}
+	}
+
+	var pages []string
+	if strings.Contains(body, `\begin{preview}`) {
+		parts := strings.Split(body, `\begin{preview}`)
+		pages = parts[1:]
+	} else {
+		for _, part := range strings.Split(body, `\newpage`) {
+			if strings.TrimSpace(part) != "" {
+				pages = append(pages, part)
+			}
+		}
+	}
+	return pages, nil
+}
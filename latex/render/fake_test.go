@@ -0,0 +1,236 @@
+// fake_test.go - unit tests for fake.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func useFakeEngine() func() {
+	oldEngine, oldFormat := *texEngine, *imageFormat
+	*texEngine = "fake"
+	return func() {
+		*texEngine, *imageFormat = oldEngine, oldFormat
+	}
+}
+
+func TestFakePages(t *testing.T) {
+	defer useFakeEngine()()
+
+	queue, err := NewQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Finish(context.Background())
+
+	tmpl := template.Must(template.New("tex").Parse(texTemplate))
+	body := "\\sbox0{$x$}\\epublatexmeasure0\\vrule\\usebox0\n\\newpage\n" +
+		"$$y$$\n\\newpage\n"
+	c, errc := queue.SubmitPages(context.Background(), 150, tmpl, body)
+	var pages []*Page
+	for page := range c {
+		pages = append(pages, page)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Fatalf("wrong number of pages, expected 2, got %d", len(pages))
+	}
+	for i, page := range pages {
+		if page.Image == nil {
+			t.Errorf("image %d is missing", i+1)
+		}
+	}
+	if pages[0].Box == nil || *pages[0].Box != *fakeBox() {
+		t.Errorf("wrong box size %v", pages[0].Box)
+	}
+	if pages[1].Box != nil {
+		t.Errorf("unexpected box size %v", pages[1].Box)
+	}
+}
+
+func TestFakeSVG(t *testing.T) {
+	defer useFakeEngine()()
+	*imageFormat = "svg"
+
+	queue, err := NewQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Finish(context.Background())
+
+	// Use more than nine pages, so that the page numbers in the file
+	// names are padded with zeros.
+	const numPages = 12
+	tmpl := template.Must(template.New("tex").Parse(texTemplate))
+	body := strings.Repeat("\\begin{preview}$x$\\end{preview}\n", numPages)
+	c, errc := queue.SubmitSVG(context.Background(), tmpl, body)
+	count := 0
+	for svg := range c {
+		count++
+		if svg == nil {
+			t.Errorf("image %d is missing", count)
+			continue
+		}
+		parsed, err := ParseSVG(svg.Data)
+		if err != nil {
+			t.Error(err)
+		} else if parsed.Box != *fakeBox() {
+			t.Errorf("wrong box size %v", parsed.Box)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if count != numPages {
+		t.Errorf("wrong number of images, expected %d, got %d",
+			numPages, count)
+	}
+}
+
+func TestFakeError(t *testing.T) {
+	defer useFakeEngine()()
+
+	queue, err := NewQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Finish(context.Background())
+
+	tmpl := template.Must(template.New("tex").Parse(texTemplate))
+	c, errc := queue.Submit(context.Background(), 150, tmpl, "$"+FakeErrorMacro+"$")
+	for range c {
+		t.Error("unexpected image")
+	}
+	err = <-errc
+	var renderErr *Error
+	if !errors.As(err, &renderErr) {
+		t.Fatalf("wrong error %v", err)
+	}
+	if renderErr.Log == "" {
+		t.Error("missing log excerpt")
+	}
+}
+
+func TestFakeTimeout(t *testing.T) {
+	defer useFakeEngine()()
+	oldTimeout := *jobTimeout
+	*jobTimeout = 50 * time.Millisecond
+	defer func() { *jobTimeout = oldTimeout }()
+
+	queue, err := NewQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := template.Must(template.New("tex").Parse(texTemplate))
+	c, errc := queue.Submit(context.Background(), 150, tmpl, FakeHangMacro)
+	for range c {
+		t.Error("unexpected image")
+	}
+	err = <-errc
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error %v", err)
+	}
+
+	err = queue.Finish(context.Background())
+	failed, ok := err.(Errors)
+	if !ok || len(failed) != 1 {
+		t.Errorf("wrong error from Finish: %v", err)
+	}
+}
+
+func TestFinishCancel(t *testing.T) {
+	defer useFakeEngine()()
+
+	queue, err := NewQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := template.Must(template.New("tex").Parse(texTemplate))
+	c, errc := queue.Submit(context.Background(), 150, tmpl, FakeHangMacro)
+	go func() {
+		for range c {
+			t.Error("unexpected image")
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = queue.Finish(ctx)
+	if err == nil {
+		t.Error("aborted job not reported")
+	}
+	if err := <-errc; err == nil {
+		t.Error("job not aborted")
+	}
+}
+
+func TestQueueWorkers(t *testing.T) {
+	defer useFakeEngine()()
+	oldWorkers := *numWorkers
+	defer func() { *numWorkers = oldWorkers }()
+
+	*numWorkers = 0
+	if _, err := NewQueue(); err == nil {
+		t.Error("invalid number of workers not detected")
+	}
+
+	*numWorkers = 2
+	queue, err := NewQueue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue.workDir != "" {
+		t.Error("working directory created before the first job")
+	}
+
+	// several users submit jobs to the same queue
+	tmpl := template.Must(template.New("tex").Parse(texTemplate))
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func(res int) {
+			defer wg.Done()
+			c, errc := queue.Submit(context.Background(), res, tmpl, "x")
+			count := 0
+			for range c {
+				count++
+			}
+			if err := <-errc; err != nil {
+				t.Error(err)
+			}
+			if count != 1 {
+				t.Errorf("wrong number of images %d", count)
+			}
+		}(100 + 10*i)
+	}
+	wg.Wait()
+
+	err = queue.Finish(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
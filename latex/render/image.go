@@ -7,6 +7,7 @@ type BookImageType int
 const (
 	BookImageTypePNG BookImageType = iota
 	BookImageTypeJPG
+	BookImageTypeSVG
 )
 
 type BookImage struct {
@@ -19,4 +20,15 @@ type BookImage struct {
 
 	Image image.Image
 	Type  BookImageType
+
+	// Data, if non-nil, is the contents of an image file in the
+	// format given by Type.  The file is copied into the book
+	// unchanged and Image is ignored.
+	Data []byte
+
+	// Err, if non-nil, indicates that the image could not be
+	// rendered.  In this case, Source is shown in the book instead
+	// of the image.
+	Err    error
+	Source string
 }
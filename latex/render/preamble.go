@@ -0,0 +1,36 @@
+// preamble.go - identify the preamble of the rendered TeX files
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// PreambleKey returns a short string which identifies the given
+// preamble lines.  Renderers include this string in their cache keys,
+// so that changes to the preamble cause the images to be rendered
+// again.  The empty preamble is identified by the empty string.
+func PreambleKey(preamble []string) string {
+	if len(preamble) == 0 {
+		return ""
+	}
+	hash := sha3.Sum224([]byte(strings.Join(preamble, "\n")))
+	return fmt.Sprintf("%x", hash[:12])
+}
@@ -0,0 +1,38 @@
+// preamble_test.go - unit tests for preamble.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import "testing"
+
+func TestPreambleKey(t *testing.T) {
+	if key := PreambleKey(nil); key != "" {
+		t.Errorf("wrong key for empty preamble: %q", key)
+	}
+
+	a := PreambleKey([]string{"\\usepackage{amsmath}"})
+	b := PreambleKey([]string{"\\usepackage{amsmath}", "\\usepackage{bm}"})
+	c := PreambleKey([]string{"\\usepackage{amsmath}\n\\usepackage{bm}"})
+	if a == "" || a == b {
+		t.Errorf("keys not distinct: %q %q", a, b)
+	}
+	if b != c {
+		t.Errorf("keys differ for the same TeX code: %q %q", b, c)
+	}
+	if PreambleKey([]string{"\\usepackage{amsmath}"}) != a {
+		t.Error("keys not deterministic")
+	}
+}
@@ -1,6 +1,7 @@
 package render
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"image"
@@ -8,53 +9,80 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"sync"
 	"text/template"
+	"time"
 )
 
 const (
 	imgNames = "img%d.png"
+	svgNames = "img%d.svg"
 
 	queueLength = 1
-	maxWorkers  = 4
 )
 
 var debugDir = flag.String("latex-render-debug", "",
 	"directory to store rendering debugging information in")
 
+var jobTimeout = flag.Duration("latex-render-timeout", 2*time.Minute,
+	"maximum time for a single rendering job")
+
+var numWorkers = flag.Int("latex-render-workers", runtime.NumCPU(),
+	"maximum number of rendering jobs which run at the same time")
+
 // Queue allows to run LaTeX and to convert the output into images.
+// A single Queue can be shared between several users, to limit the
+// total number of external programs running at the same time.
 type Queue struct {
-	resolution string
-	workDir    string
+	engine     Engine
+	numWorkers int
 	keepFiles  bool
 
-	jobs    chan *jobSpec
-	workers *sync.WaitGroup
+	workDirOnce sync.Once
+	workDir     string
+	workDirErr  error
+
+	jobs      chan *jobSpec
+	scheduled chan struct{}
+	abort     chan struct{}
+	workers   *sync.WaitGroup
+
+	mutex  sync.Mutex
+	failed Errors
 }
 
 // NewQueue creates a new rendering queue for converting .tex files to
-// images.  The argument 'resolution' specifies the image resolution
-// in pixels per inch.
-func NewQueue(resolution int) (*Queue, error) {
+// images.  The programs used for rendering are selected by NewEngine.
+// The number of jobs which are processed at the same time is given
+// by the -latex-render-workers command line option, and defaults to
+// the number of CPUs.
+func NewQueue() (*Queue, error) {
+	if *imageFormat != "png" && *imageFormat != "svg" {
+		return nil, fmt.Errorf("invalid image format %q", *imageFormat)
+	}
+	if *numWorkers < 1 {
+		return nil, fmt.Errorf("invalid number of workers %d", *numWorkers)
+	}
+	engine, err := NewEngine()
+	if err != nil {
+		return nil, err
+	}
+
 	q := &Queue{
-		resolution: strconv.Itoa(resolution),
+		engine:     engine,
+		numWorkers: *numWorkers,
 		jobs:       make(chan *jobSpec, queueLength),
+		scheduled:  make(chan struct{}),
+		abort:      make(chan struct{}),
 		workers:    &sync.WaitGroup{},
 	}
 
-	var workDir string
-	var err error
-	if *debugDir == "" {
-		workDir, err = ioutil.TempDir("", "epublatex")
-		if err != nil {
-			return nil, err
-		}
-	} else {
+	if *debugDir != "" {
 		q.keepFiles = true
-		workDir, err = filepath.Abs(*debugDir)
+		workDir, err := filepath.Abs(*debugDir)
 		if err != nil {
 			return nil, err
 		}
@@ -63,80 +91,205 @@ func NewQueue(resolution int) (*Queue, error) {
 		if err != nil {
 			return nil, err
 		}
+		q.workDirOnce.Do(func() { q.workDir = workDir })
 	}
-	q.workDir = workDir
 
 	go q.scheduler()
 
 	return q, nil
 }
 
+// EngineVersion returns the version of the programs used to render
+// the images.  The value should be included in cache keys for the
+// rendered images.
+func (q *Queue) EngineVersion() string {
+	return q.engine.Version()
+}
+
+// SetSearchPath sets the directories where the TeX engine looks for
+// input files, for example packages stored next to the document.
+// The method must be called before the first job is submitted.
+func (q *Queue) SetSearchPath(dirs []string) {
+	q.engine.SetSearchPath(dirs)
+}
+
 // Finish must be called after the last rendering job has been
 // submitted to the queue.  The function waits until all rendered
-// images have been delivered and then shuts down the queue.
-func (q *Queue) Finish() error {
+// images have been delivered and then shuts down the queue.  If ctx
+// is cancelled before this happens, all running jobs are aborted.
+// If any jobs failed, the returned error is of type Errors and lists
+// all failed jobs.
+func (q *Queue) Finish(ctx context.Context) error {
 	close(q.jobs)
-	q.workers.Wait()
+	done := make(chan struct{})
+	go func() {
+		<-q.scheduled
+		q.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		// pass
+	case <-ctx.Done():
+		close(q.abort)
+		<-done
+	}
 
 	q.jobs = nil
-	if !q.keepFiles {
+	if !q.keepFiles && q.workDir != "" {
 		err := os.RemoveAll(q.workDir)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return q.failed.Err()
 }
 
 func (q *Queue) scheduler() {
-	workers := make(chan int, maxWorkers)
-	for i := 0; i < maxWorkers; i++ {
+	workers := make(chan int, q.numWorkers)
+	for i := 0; i < q.numWorkers; i++ {
 		workers <- i
 	}
 
 	jobIdx := 1
 	for job := range q.jobs {
-		jobDir := filepath.Join(q.workDir, strconv.Itoa(jobIdx))
+		jobNo := jobIdx
 		jobIdx++
 
 		worker := <-workers
 		q.workers.Add(1)
 		go func(job *jobSpec) {
-			err := q.process(job, jobDir)
-			workers <- worker
+			err := q.process(job, jobNo)
 			if err != nil {
-				log.Println(err)
+				q.mutex.Lock()
+				q.failed = append(q.failed, fmt.Errorf("job %d: %w", jobNo, err))
+				q.mutex.Unlock()
 			}
+			job.Err <- err
+			workers <- worker
 			q.workers.Done()
 		}(job)
 	}
+	close(q.scheduled)
 }
 
 // Submit adds a new rendering job to the queue.  As part of the job,
 // the template tmpl is executed with the given data to obtain a TeX
-// file.  Pdflatex is run with this file as input, and Ghostscript is
-// used to convert each page of output into an image.  The resulting
-// images can be read from the channel returned by .Submit().
-func (q *Queue) Submit(tmpl *template.Template, data interface{}) <-chan image.Image {
+// file.  The engine, by default pdflatex and Ghostscript, is used to
+// convert each page of output into an image, using the given
+// resolution in pixels per inch.  The resulting
+// images can be read from the first channel returned by .Submit().
+// Once all images have been read and the first channel is closed,
+// the second channel delivers the outcome of the job: nil on success,
+// or an error describing the failure.  Errors caused by external
+// programs are of type *Error.  The job is aborted, and all programs
+// started for the job are killed, when ctx is cancelled or when the
+// job takes longer than allowed by the -latex-render-timeout option.
+func (q *Queue) Submit(ctx context.Context, resolution int, tmpl *template.Template, data interface{}) (<-chan image.Image, <-chan error) {
 	c := make(chan image.Image)
+	errc := make(chan error, 1)
+	job := &jobSpec{
+		Context:    ctx,
+		Resolution: resolution,
+		Template:   tmpl,
+		Data:       data,
+		Result:     c,
+		Err:        errc,
+	}
+	q.jobs <- job
+	return c, errc
+}
+
+// SubmitSVG adds a new rendering job to the queue, which converts
+// each page of output into an SVG image.  The template is executed
+// like for .Submit(), but the TeX engine is used to produce DVI
+// output, and dvisvgm is used to convert the pages to SVG.  For pages which use
+// the "preview" package with the "tightpage" option, the size of the
+// preview box is returned together with the image.  Errors are
+// reported like for .Submit().
+func (q *Queue) SubmitSVG(ctx context.Context, tmpl *template.Template, data interface{}) (<-chan *SVG, <-chan error) {
+	c := make(chan *SVG)
+	errc := make(chan error, 1)
+	job := &jobSpec{
+		Context:   ctx,
+		Template:  tmpl,
+		Data:      data,
+		SVGResult: c,
+		Err:       errc,
+	}
+	q.jobs <- job
+	return c, errc
+}
+
+// SubmitPages adds a new rendering job to the queue.  This works like
+// .Submit(), but each image is returned together with the box size
+// reported by the TeX code using the macro defined in MeasureMacro.
+// Errors are reported like for .Submit().
+func (q *Queue) SubmitPages(ctx context.Context, resolution int, tmpl *template.Template, data interface{}) (<-chan *Page, <-chan error) {
+	c := make(chan *Page)
+	errc := make(chan error, 1)
 	job := &jobSpec{
-		Template: tmpl,
-		Data:     data,
-		Result:   c,
+		Context:    ctx,
+		Resolution: resolution,
+		Template:   tmpl,
+		Data:       data,
+		PageResult: c,
+		Err:        errc,
 	}
 	q.jobs <- job
-	return c
+	return c, errc
 }
 
 type jobSpec struct {
-	Template *template.Template
-	Data     interface{}
-	Result   chan<- image.Image
+	Context    context.Context
+	Resolution int
+	Template   *template.Template
+	Data       interface{}
+	Result     chan<- image.Image
+	PageResult chan<- *Page
+	SVGResult  chan<- *SVG
+	Err        chan<- error
 }
 
-func (q *Queue) process(job *jobSpec, jobDir string) (err error) {
-	defer close(job.Result)
+func (q *Queue) process(job *jobSpec, jobNo int) (err error) {
+	switch {
+	case job.SVGResult != nil:
+		defer close(job.SVGResult)
+	case job.PageResult != nil:
+		defer close(job.PageResult)
+	default:
+		defer close(job.Result)
+	}
+
+	// The job is aborted after the timeout, when the context of the
+	// job is cancelled, or when Finish gives up waiting.
+	ctx, cancel := context.WithTimeout(job.Context, *jobTimeout)
+	defer cancel()
+	go func() {
+		select {
+		case <-q.abort:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	defer func() {
+		if err != nil && ctx.Err() == context.DeadlineExceeded &&
+			job.Context.Err() == nil {
+			err = fmt.Errorf("timeout after %s: %w", *jobTimeout, err)
+		}
+	}()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 
+	// The working directory is only created once it is needed.
+	q.workDirOnce.Do(func() {
+		q.workDir, q.workDirErr = ioutil.TempDir("", "epublatex")
+	})
+	if q.workDirErr != nil {
+		return q.workDirErr
+	}
+	jobDir := filepath.Join(q.workDir, strconv.Itoa(jobNo))
 	err = os.MkdirAll(jobDir, 0777)
 	if err != nil {
 		return err
@@ -165,33 +318,12 @@ func (q *Queue) process(job *jobSpec, jobDir string) (err error) {
 		return err
 	}
 
-	// convert to TeX -> PDF
-	ltx := exec.Command("pdflatex", "-interaction=nonstopmode", "job.tex")
-	ltx.Dir = jobDir
-	output, err := ltx.Output()
-	if err != nil {
-		if e2, ok := err.(*exec.ExitError); ok {
-			log.Println("Converting LaTeX to PDF failed:", e2)
-			log.Println("--- begin LaTeX output ---")
-			log.Println(string(output))
-			log.Println("--- end LaTeX output ---")
-		}
-		return err
+	if job.SVGResult != nil {
+		return q.processSVG(ctx, job, jobDir)
 	}
 
-	// convert to PDF -> PNG
-	gs := exec.Command("gs", "-dSAFER", "-dBATCH", "-dNOPAUSE",
-		"-r"+q.resolution, "-sDEVICE=pngalpha", "-dTextAlphaBits=4",
-		"-sOutputFile="+imgNames, "job.pdf")
-	gs.Dir = jobDir
-	output, err = gs.Output()
+	boxes, err := q.engine.MakePNG(ctx, jobDir, job.Resolution)
 	if err != nil {
-		if e2, ok := err.(*exec.ExitError); ok {
-			log.Println("Converting formulas to PNG failed:", e2)
-			log.Println("--- begin gs output ---")
-			log.Println(string(output))
-			log.Println("--- end gs output ---")
-		}
 		return err
 	}
 
@@ -207,7 +339,47 @@ func (q *Queue) process(job *jobSpec, jobDir string) (err error) {
 		if err != nil {
 			log.Println("decoding image", imageFileName, "failed:", err)
 		}
-		job.Result <- img
+		if job.PageResult != nil {
+			job.PageResult <- &Page{Image: img, Box: boxes[pageNo]}
+		} else {
+			job.Result <- img
+		}
+	}
+
+	return nil
+}
+
+// processSVG runs the second half of an SVG rendering job, after the
+// TeX file has been written.
+func (q *Queue) processSVG(ctx context.Context, job *jobSpec, jobDir string) error {
+	pageExtents, err := q.engine.MakeSVG(ctx, jobDir)
+	if err != nil {
+		return err
+	}
+
+	// read SVG, write to channel
+	pageNo := 0
+	for {
+		pageNo++
+		imageFileName := filepath.Join(jobDir, fmt.Sprintf(svgNames, pageNo))
+		data, err := ioutil.ReadFile(imageFileName)
+		if os.IsNotExist(err) {
+			break
+		}
+		var svg *SVG
+		if err == nil {
+			e := pageExtents[pageNo]
+			if e == nil {
+				e, err = svgExtents(data)
+			}
+			if err == nil {
+				svg = newSVG(data, e)
+			}
+		}
+		if err != nil {
+			log.Println("reading image", imageFileName, "failed:", err)
+		}
+		job.SVGResult <- svg
 	}
 
 	return nil
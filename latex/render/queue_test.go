@@ -1,6 +1,7 @@
 package render
 
 import (
+	"context"
 	"testing"
 	"text/template"
 )
@@ -18,14 +19,14 @@ const texTemplate = `\documentclass{minimal}
 `
 
 func TestQueue(t *testing.T) {
-	queue, err := NewQueue(150)
+	queue, err := NewQueue()
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer queue.Finish()
+	defer queue.Finish(context.Background())
 
 	tmpl := template.Must(template.New("tex").Parse(texTemplate))
-	c := queue.Submit(tmpl, "Hello world!\n\\newpage\ngood bye\n")
+	c, errc := queue.Submit(context.Background(), 150, tmpl, "Hello world!\n\\newpage\ngood bye\n")
 
 	count := 0
 	for img := range c {
@@ -37,4 +38,7 @@ func TestQueue(t *testing.T) {
 	if count != 2 {
 		t.Error("wrong number of pages, expected 2, got", count)
 	}
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
 }
@@ -0,0 +1,143 @@
+// svg.go - SVG images produced by dvisvgm
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+var imageFormat = flag.String("latex-image-format", "png",
+	"image format for formulas and TikZ pictures, either \"png\" or \"svg\"")
+
+// UseSVG reports whether formulas and TikZ pictures are converted
+// into SVG images, rather than into PNG images.
+func UseSVG() bool {
+	return *imageFormat == "svg"
+}
+
+// SVG is an image in SVG format, together with the dimensions of the
+// corresponding TeX box in TeX points: Height is the extent above the
+// baseline, Depth the extent below the baseline.
+type SVG struct {
+	Data []byte
+	Box
+}
+
+var (
+	errNoSize = errors.New("cannot find the size of the SVG image")
+
+	sizeComment = regexp.MustCompile(
+		`<!-- epublatex width=([-0-9.]+)pt height=([-0-9.]+)pt depth=([-0-9.]+)pt -->`)
+	extentsLine = regexp.MustCompile(
+		`width=([-0-9.]+)pt, height=([-0-9.]+)pt, depth=([-0-9.]+)pt`)
+	pageLine = regexp.MustCompile(`processing page ([0-9]+)`)
+	svgSize  = regexp.MustCompile(
+		`<svg [^>]*width=['"]([-0-9.]+)pt['"][^>]*height=['"]([-0-9.]+)pt['"]`)
+)
+
+// newSVG stores the size of the image as a comment in the SVG data,
+// so that the size can be recovered by ParseSVG.
+func newSVG(data []byte, box *Box) *SVG {
+	comment := fmt.Sprintf("<!-- epublatex width=%.3fpt height=%.3fpt depth=%.3fpt -->\n",
+		box.Width, box.Height, box.Depth)
+
+	// The comment must follow the XML declaration, if any.
+	pos := 0
+	if bytes.HasPrefix(data, []byte("<?xml")) {
+		pos = bytes.Index(data, []byte("?>")) + 2
+		for pos < len(data) && data[pos] == '\n' {
+			pos++
+		}
+	}
+	res := make([]byte, 0, len(data)+len(comment))
+	res = append(res, data[:pos]...)
+	res = append(res, comment...)
+	res = append(res, data[pos:]...)
+
+	return &SVG{
+		Data: res,
+		Box:  *box,
+	}
+}
+
+// ParseSVG recovers the size information from SVG data produced by
+// the render queue, for example after the data was read from a cache.
+func ParseSVG(data []byte) (*SVG, error) {
+	m := sizeComment.FindSubmatch(data)
+	if m == nil {
+		return nil, errNoSize
+	}
+	var size [3]float64
+	for i := range size {
+		x, err := strconv.ParseFloat(string(m[i+1]), 64)
+		if err != nil {
+			return nil, err
+		}
+		size[i] = x
+	}
+	return &SVG{
+		Data: data,
+		Box: Box{
+			Width:  size[0],
+			Height: size[1],
+			Depth:  size[2],
+		},
+	}, nil
+}
+
+// parseExtents extracts the box sizes reported by dvisvgm for pages
+// which use the preview package.  The result maps page numbers to
+// sizes.
+func parseExtents(output []byte) map[int]*Box {
+	res := make(map[int]*Box)
+	page := 0
+	for _, line := range bytes.Split(output, []byte("\n")) {
+		if m := pageLine.FindSubmatch(line); m != nil {
+			page, _ = strconv.Atoi(string(m[1]))
+			continue
+		}
+		if m := extentsLine.FindSubmatch(line); m != nil && page > 0 {
+			res[page] = parseBox(m[1:])
+		}
+	}
+	return res
+}
+
+// svgExtents reads the size of an SVG image from the attributes of
+// the <svg> element.  The depth is taken to be zero.
+func svgExtents(data []byte) (*Box, error) {
+	m := svgSize.FindSubmatch(data)
+	if m == nil {
+		return nil, errNoSize
+	}
+	e := &Box{}
+	var err error
+	e.Width, err = strconv.ParseFloat(string(m[1]), 64)
+	if err != nil {
+		return nil, err
+	}
+	e.Height, err = strconv.ParseFloat(string(m[2]), 64)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,84 @@
+// svg_test.go - unit tests for svg.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package render
+
+import (
+	"bytes"
+	"testing"
+)
+
+const testSVG = `<?xml version='1.0' encoding='UTF-8'?>
+<!-- This file was generated by dvisvgm 2.8.1 -->
+<svg version='1.1' xmlns='http://www.w3.org/2000/svg' width='8.3pt' height='6.93pt' viewBox='0 0 8.3 6.93'>
+</svg>
+`
+
+func TestSVGSize(t *testing.T) {
+	svg := newSVG([]byte(testSVG), &Box{8.3, 6.83, 0.1})
+	if !bytes.HasPrefix(svg.Data, []byte("<?xml version='1.0' encoding='UTF-8'?>\n<!-- epublatex ")) {
+		t.Errorf("size comment not after XML declaration:\n%s", svg.Data)
+	}
+
+	svg2, err := ParseSVG(svg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svg2.Width != 8.3 || svg2.Height != 6.83 || svg2.Depth != 0.1 {
+		t.Errorf("wrong size %g %g %g", svg2.Width, svg2.Height, svg2.Depth)
+	}
+
+	_, err = ParseSVG([]byte(testSVG))
+	if err != errNoSize {
+		t.Error("missing size comment not detected", err)
+	}
+
+	e, err := svgExtents([]byte(testSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Width != 8.3 || e.Height != 6.93 || e.Depth != 0 {
+		t.Errorf("wrong extents %v", e)
+	}
+}
+
+func TestParseExtents(t *testing.T) {
+	output := `pre-processing DVI file (format version 2)
+processing page 1
+  computing extents based on data set by preview package (version 11.88)
+  width=8.3pt, height=6.83pt, depth=0.1pt
+  graphic size: 8.3pt x 6.93pt (2.91719mm x 2.43567mm)
+  output written to img1.svg
+processing page 2
+  graphic size: 10pt x 5pt (3.5mm x 1.7mm)
+  output written to img2.svg
+processing page 3
+  computing extents based on data set by preview package (version 11.88)
+  width=20pt, height=7.5pt, depth=2.5pt
+  output written to img3.svg
+3 of 3 pages converted in 0.1 seconds
+`
+	res := parseExtents([]byte(output))
+	if len(res) != 2 || res[2] != nil {
+		t.Fatalf("wrong pages %v", res)
+	}
+	if e := res[1]; e.Width != 8.3 || e.Height != 6.83 || e.Depth != 0.1 {
+		t.Errorf("wrong extents for page 1: %v", e)
+	}
+	if e := res[3]; e.Width != 20 || e.Height != 7.5 || e.Depth != 2.5 {
+		t.Errorf("wrong extents for page 3: %v", e)
+	}
+}
@@ -0,0 +1,183 @@
+// renderers.go - start the image renderers on demand
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"context"
+
+	"github.com/seehuhn/epublatex/latex/graphics"
+	"github.com/seehuhn/epublatex/latex/math"
+	"github.com/seehuhn/epublatex/latex/render"
+	"github.com/seehuhn/epublatex/latex/tikz"
+)
+
+// renderers creates the renderers for formulas, TikZ pictures and
+// graphics files when they are first used.  All renderers share a
+// single rendering queue, which is also created on first use, so
+// that the number of external programs running at the same time is
+// limited by the -latex-render-workers option.
+type renderers struct {
+	out chan<- *render.BookImage
+
+	queue    *render.Queue
+	maths    *math.Renderer
+	tikz     *tikz.Renderer
+	graphics *graphics.Renderer
+
+	// preamble lines for the maths and TikZ renderers
+	mathsPreamble pendingPreamble
+	tikzPreamble  pendingPreamble
+
+	// fontSize is the font size of the document in TeX points, or 0
+	// if the default size is used.
+	fontSize float64
+
+	// searchPath lists the directories where the TeX engine looks
+	// for packages loaded by the preamble of the document.
+	searchPath []string
+}
+
+func newRenderers(out chan<- *render.BookImage) *renderers {
+	return &renderers{out: out}
+}
+
+func (rs *renderers) getQueue() (*render.Queue, error) {
+	if rs.queue == nil {
+		queue, err := render.NewQueue()
+		if err != nil {
+			return nil, err
+		}
+		queue.SetSearchPath(rs.searchPath)
+		rs.queue = queue
+	}
+	return rs.queue, nil
+}
+
+// Maths returns the renderer for formulas.
+func (rs *renderers) Maths() (*math.Renderer, error) {
+	if rs.maths == nil {
+		queue, err := rs.getQueue()
+		if err != nil {
+			return nil, err
+		}
+		r, err := math.NewRenderer(rs.out, queue)
+		if err != nil {
+			return nil, err
+		}
+		rs.mathsPreamble.flush(r)
+		rs.maths = r
+	}
+	return rs.maths, nil
+}
+
+// Tikz returns the renderer for TikZ pictures.
+func (rs *renderers) Tikz() (*tikz.Renderer, error) {
+	if rs.tikz == nil {
+		queue, err := rs.getQueue()
+		if err != nil {
+			return nil, err
+		}
+		r, err := tikz.NewRenderer(rs.out, queue)
+		if err != nil {
+			return nil, err
+		}
+		if rs.fontSize > 0 {
+			r.SetFontSize(rs.fontSize)
+		}
+		rs.tikzPreamble.flush(r)
+		rs.tikz = r
+	}
+	return rs.tikz, nil
+}
+
+// Graphics returns the renderer for graphics files.
+func (rs *renderers) Graphics() (*graphics.Renderer, error) {
+	if rs.graphics == nil {
+		queue, err := rs.getQueue()
+		if err != nil {
+			return nil, err
+		}
+		r, err := graphics.NewRenderer(rs.out, queue)
+		if err != nil {
+			return nil, err
+		}
+		rs.graphics = r
+	}
+	return rs.graphics, nil
+}
+
+// Finish waits until all renderers which have been started have
+// delivered their images, and then shuts down the queue.  Images
+// which could not be rendered are listed in the first return value.
+// If ctx is cancelled, all remaining rendering jobs are aborted.
+func (rs *renderers) Finish(ctx context.Context) (render.Errors, error) {
+	var errs []error
+	if rs.maths != nil {
+		errs = append(errs, rs.maths.Finish(ctx))
+	}
+	if rs.tikz != nil {
+		errs = append(errs, rs.tikz.Finish())
+	}
+	if rs.graphics != nil {
+		errs = append(errs, rs.graphics.Finish())
+	}
+	if rs.queue != nil {
+		err := rs.queue.Finish(ctx)
+		if _, ok := err.(render.Errors); !ok {
+			// The failed jobs have already been reported by the
+			// renderers, only other errors are of interest here.
+			errs = append(errs, err)
+		}
+	}
+
+	var failed render.Errors
+	var firstErr error
+	for _, err := range errs {
+		if f, ok := err.(render.Errors); ok {
+			failed = append(failed, f...)
+		} else if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return failed, firstErr
+}
+
+// pendingPreamble collects the preamble lines for a renderer which
+// has not been started yet.
+type pendingPreamble struct {
+	lines  []string
+	target preambleAdder
+}
+
+// AddPreamble implements the preambleAdder interface.
+func (p *pendingPreamble) AddPreamble(line string) {
+	if p.target != nil {
+		p.target.AddPreamble(line)
+		return
+	}
+	p.lines = append(p.lines, line)
+}
+
+// flush passes the collected lines to the renderer `target`, which
+// then receives all further lines directly.
+func (p *pendingPreamble) flush(target preambleAdder) {
+	for _, line := range p.lines {
+		target.AddPreamble(line)
+	}
+	p.lines = nil
+	p.target = target
+}
@@ -0,0 +1,92 @@
+// renderers_test.go - unit tests for renderers.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/seehuhn/epublatex/latex/render"
+)
+
+func TestPendingPreamble(t *testing.T) {
+	var p pendingPreamble
+	p.AddPreamble("a")
+	p.AddPreamble("b")
+
+	var target preambleLines
+	p.flush(&target)
+	p.AddPreamble("c")
+	if !reflect.DeepEqual([]string(target), []string{"a", "b", "c"}) {
+		t.Errorf("wrong preamble %q", target)
+	}
+}
+
+func TestRenderersUnused(t *testing.T) {
+	out := make(chan *render.BookImage)
+	rs := newRenderers(out)
+	rs.mathsPreamble.AddPreamble("\\usepackage{amsmath}")
+	failed, err := rs.Finish(context.Background())
+	if err != nil || failed != nil {
+		t.Errorf("unexpected result %v, %v", failed, err)
+	}
+	if rs.queue != nil || rs.maths != nil {
+		t.Error("renderer started without images")
+	}
+}
+
+func TestRenderersShareQueue(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "epublatex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for name, value := range map[string]string{
+		"latex-engine": "fake",
+		"cache-dir":    tmp,
+	} {
+		old := flag.Lookup(name).Value.String()
+		flag.Set(name, value)
+		defer flag.Set(name, old)
+	}
+
+	out := make(chan *render.BookImage)
+	rs := newRenderers(out)
+	_, err = rs.Graphics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := rs.queue
+	if queue == nil {
+		t.Fatal("graphics renderer started without a queue")
+	}
+	_, err = rs.Maths()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rs.queue != queue {
+		t.Error("maths and graphics renderers use different queues")
+	}
+	_, err = rs.Finish(context.Background())
+	if err != nil {
+		t.Error(err)
+	}
+}
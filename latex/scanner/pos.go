@@ -0,0 +1,58 @@
+// pos.go - positions in the input files
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package scanner
+
+import "strconv"
+
+// Pos describes a position in the input files.  Line and column
+// numbers start at 1.
+type Pos struct {
+	Name string
+	Line int
+	Col  int
+}
+
+func (pos Pos) String() string {
+	if pos.Name == "" {
+		return "<input>"
+	}
+	return pos.Name + ":" + strconv.Itoa(pos.Line) + ":" + strconv.Itoa(pos.Col)
+}
+
+// Pos returns the current input position.  Buffers added using
+// .Prepend() have no position of their own; the position in the
+// innermost input file is reported instead.  If no input file is
+// open, the zero Pos is returned.
+func (scan *Scanner) Pos() Pos {
+	for idx := len(scan.sources) - 1; idx >= 0; idx-- {
+		src := scan.sources[idx]
+		if src.IsFile {
+			return src.Pos()
+		} else if src.At.Name != "" {
+			return src.At
+		}
+	}
+	return Pos{}
+}
+
+func (src *source) Pos() Pos {
+	return Pos{
+		Name: src.Name,
+		Line: src.Line + 1,
+		Col:  src.Col + 1,
+	}
+}
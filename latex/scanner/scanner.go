@@ -21,7 +21,6 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 )
 
@@ -40,15 +39,23 @@ type Scanner struct {
 	// relative to this directory.
 	BaseDir string
 
-	sources []*source
-	peekBuf []byte
-	ready   bool
+	// SearchPath lists additional directories which are searched
+	// for include files not found in BaseDir.
+	SearchPath []string
+
+	sources  []*source
+	peekBuf  []byte
+	ready    bool
+	recorded []byte
 }
 
 // Close closes all input files and discards all buffers used by the
 // scanner.
 func (scan *Scanner) Close() (err error) {
 	for _, source := range scan.sources {
+		if source.Fd == nil {
+			continue
+		}
 		e2 := source.Fd.Close()
 		if err == nil {
 			err = e2
@@ -70,12 +77,38 @@ func (scan *Scanner) Prepend(data []byte, name string) {
 	scan.sources = append(scan.sources, src)
 }
 
+// PrependAt is like .Prepend(), but while the buffer is read, .Pos()
+// reports `pos` as the current position.  This is used for text
+// resulting from macro expansion.  Since the buffer is not part of the
+// original input, it is omitted by the .Recorded() method.
+func (scan *Scanner) PrependAt(data []byte, name string, pos Pos) {
+	src := &source{
+		Name:      name,
+		Buffer:    data,
+		At:        pos,
+		Expansion: true,
+	}
+	scan.sources = append(scan.sources, src)
+}
+
 // Include adds the contents of the given file to the list of input
 // sources.  The file contents are read next, followed by all
-// remaining, previously registered inputs.
+// remaining, previously registered inputs.  The file is located using
+// the .Find() method.  Including a file which is already being read
+// is reported as an error.
 func (scan *Scanner) Include(fileName string) error {
-	if scan.BaseDir != "" {
-		fileName = filepath.Join(scan.BaseDir, fileName)
+	fileName, err := scan.Find(fileName)
+	if err != nil {
+		return err
+	}
+	path, err := filepath.Abs(fileName)
+	if err != nil {
+		return err
+	}
+	for _, src := range scan.sources {
+		if src.Path == path {
+			return scan.MakeError("cyclic include of " + fileName)
+		}
 	}
 
 	fd, err := os.Open(fileName)
@@ -84,8 +117,10 @@ func (scan *Scanner) Include(fileName string) error {
 	}
 
 	src := &source{
-		Name: filepath.Base(fileName),
-		Fd:   fd,
+		Name:   filepath.Base(fileName),
+		Path:   path,
+		Fd:     fd,
+		IsFile: true,
 	}
 	scan.sources = append(scan.sources, src)
 
@@ -100,17 +135,53 @@ func (scan *Scanner) Include(fileName string) error {
 	return nil
 }
 
+// Find locates an include file.  Relative file names are looked up
+// in BaseDir first, and then in the directories listed in SearchPath.
+// If the file name has no extension, the name with ".tex" appended is
+// tried before the name itself.
+func (scan *Scanner) Find(fileName string) (string, error) {
+	names := []string{fileName}
+	if filepath.Ext(fileName) == "" {
+		names = []string{fileName + ".tex", fileName}
+	}
+
+	var dirs []string
+	if !filepath.IsAbs(fileName) {
+		dirs = append(dirs, scan.BaseDir)
+		dirs = append(dirs, scan.SearchPath...)
+	} else {
+		dirs = []string{""}
+	}
+	for _, dir := range dirs {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			fi, err := os.Stat(path)
+			if err == nil && fi.Mode().IsRegular() {
+				return path, nil
+			}
+		}
+	}
+	return "", scan.MakeError("file " + fileName + " not found")
+}
+
 // Next checks whether more input is available.  This method must be
 // called before every call to the .Peek() method.
 func (scan *Scanner) Next() bool {
+	return scan.NextWindow(PeekWindowSize)
+}
+
+// NextWindow is like .Next(), but makes sure that the buffer returned
+// by the following call to .Peek() shows at least `size` bytes,
+// unless the end of input is reached.
+func (scan *Scanner) NextWindow(size int) bool {
 	var peekBuf []byte
 	for idx := len(scan.sources) - 1; idx >= 0; idx-- {
-		if len(peekBuf) >= PeekWindowSize {
+		if len(peekBuf) >= size {
 			break
 		}
 
 		src := scan.sources[idx]
-		if len(peekBuf)+len(src.Buffer) < PeekWindowSize &&
+		for len(peekBuf)+len(src.Buffer) < size &&
 			src.Fd != nil &&
 			src.err == nil {
 			buf := make([]byte, peekBufferSize)
@@ -179,6 +250,9 @@ func (scan *Scanner) Skip(n int) {
 		if k > n {
 			k = n
 		}
+		if !src.Expansion {
+			scan.recorded = append(scan.recorded, src.Buffer[:k]...)
+		}
 		src.Skip(k)
 		n -= k
 		scan.peekBuf = scan.peekBuf[k:]
@@ -186,18 +260,36 @@ func (scan *Scanner) Skip(n int) {
 	}
 }
 
+// Recorded returns the input skipped since the previous call to
+// .Recorded().  Text added using .PrependAt() is not included, so
+// that the result is a verbatim copy of the original input.
+func (scan *Scanner) Recorded() string {
+	res := string(scan.recorded)
+	scan.recorded = scan.recorded[:0]
+	return res
+}
+
 type source struct {
-	Name   string
-	Fd     io.ReadCloser
-	Buffer []byte
-	Line   int
-	err    error
+	Name      string
+	Path      string
+	Fd        io.ReadCloser
+	IsFile    bool
+	Expansion bool
+	At        Pos
+	Buffer    []byte
+	Line      int
+	Col       int
+	err       error
 }
 
 func (src *source) Skip(n int) {
 	for _, c := range src.Buffer[:n] {
 		if c == '\n' {
 			src.Line++
+			src.Col = 0
+		} else if c&0xC0 != 0x80 {
+			// count UTF-8 encoded characters, not bytes
+			src.Col++
 		}
 	}
 	src.Buffer = src.Buffer[n:]
@@ -219,8 +311,7 @@ func (scan *Scanner) MakeError(message string) *ParseError {
 			context = string(src.Buffer)
 		}
 		err.stack = append(err.stack, stackFrame{
-			Name:    src.Name,
-			Line:    src.Line + 1,
+			Pos:     src.Pos(),
 			Context: context,
 		})
 	}
@@ -228,8 +319,7 @@ func (scan *Scanner) MakeError(message string) *ParseError {
 }
 
 type stackFrame struct {
-	Name    string
-	Line    int
+	Pos
 	Context string
 }
 
@@ -246,8 +336,7 @@ func (err *ParseError) Error() string {
 		if i > 0 {
 			res = append(res, ", included from")
 		}
-		res = append(res, "\n    ",
-			frame.Name, ", line ", strconv.Itoa(frame.Line))
+		res = append(res, "\n    ", frame.Pos.String())
 		if frame.Context != "" {
 			res = append(res, fmt.Sprintf(", before %q", frame.Context))
 		}
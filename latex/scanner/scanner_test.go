@@ -18,6 +18,9 @@ package scanner
 
 import (
 	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -72,3 +75,178 @@ func TestScannerError(t *testing.T) {
 	}
 	t.Fatal("error not reported")
 }
+
+func TestScannerPos(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "scanner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	err = ioutil.WriteFile(filepath.Join(tmp, "a.tex"),
+		[]byte("ab\ncdé\\x\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := &Scanner{BaseDir: tmp}
+	err = scan.Include("a.tex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"a.tex:1:1", "a.tex:1:2", "a.tex:1:3",
+		"a.tex:2:1", "a.tex:2:2", "a.tex:2:3", "a.tex:2:4",
+	}
+	for i, pos := range expected {
+		if !scan.Next() {
+			t.Fatal("unexpected end of data")
+		}
+		buf, err := scan.Peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := scan.Pos().String(); got != pos {
+			t.Errorf("%d: expected %s, got %s", i, pos, got)
+		}
+		n := 1
+		if buf[0] >= 0x80 {
+			n = 2
+		}
+		scan.Skip(n)
+	}
+
+	// text added by Prepend reports the position in the file
+	scan.Prepend([]byte("expansion"), "macro")
+	if got := scan.Pos().String(); got != "a.tex:2:5" {
+		t.Errorf("expected a.tex:2:5, got %s", got)
+	}
+}
+
+func TestScannerRecorded(t *testing.T) {
+	scan := &Scanner{}
+	scan.Prepend([]byte("def"), "input")
+	scan.PrependAt([]byte("XY"), "expansion", Pos{})
+	scan.Prepend([]byte("abc"), "input")
+
+	var res []string
+	for _, n := range []int{2, 3, 3} {
+		if !scan.Next() {
+			t.Fatal("unexpected end of data")
+		}
+		scan.Skip(n)
+		res = append(res, scan.Recorded())
+	}
+	if res[0] != "ab" || res[1] != "c" || res[2] != "def" {
+		t.Errorf("wrong recorded input %q", res)
+	}
+}
+
+func TestScannerInclude(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "scanner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	extra := filepath.Join(tmp, "extra")
+	err = os.Mkdir(extra, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"main.tex":       "main",
+		"a":              "a without extension",
+		"a.tex":          "a",
+		"extra/b.tex":    "b",
+		"extra/main.tex": "other main",
+	}
+	for name, body := range files {
+		err = ioutil.WriteFile(filepath.Join(tmp, name), []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scan := &Scanner{BaseDir: tmp, SearchPath: []string{extra}}
+	testCases := []struct{ in, out string }{
+		{"main", filepath.Join(tmp, "main.tex")},
+		{"a", filepath.Join(tmp, "a.tex")},
+		{"b", filepath.Join(extra, "b.tex")},
+		{"extra/b.tex", filepath.Join(extra, "b.tex")},
+	}
+	for _, testCase := range testCases {
+		out, err := scan.Find(testCase.in)
+		if err != nil {
+			t.Errorf("%s: %s", testCase.in, err)
+		} else if out != testCase.out {
+			t.Errorf("%s: expected %s, got %s", testCase.in, testCase.out, out)
+		}
+	}
+	_, err = scan.Find("missing")
+	if err == nil {
+		t.Error("missing file not detected")
+	}
+
+	err = scan.Include("main")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = scan.Include("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = scan.Include("main.tex")
+	if err == nil {
+		t.Error("cyclic include not detected")
+	}
+}
+
+func TestScannerNextWindow(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "scanner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	body := make([]byte, 5*peekBufferSize)
+	for i := range body {
+		body[i] = 'a' + byte(i%26)
+	}
+	err = ioutil.WriteFile(filepath.Join(tmp, "main.tex"), body, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scan := &Scanner{BaseDir: tmp}
+	defer scan.Close()
+	err = scan.Include("main.tex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	scan.Prepend([]byte("prefix "), "prefix")
+
+	size := 3 * peekBufferSize
+	if !scan.NextWindow(size) {
+		t.Fatal("unexpected end of data")
+	}
+	buf, err := scan.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) < size {
+		t.Errorf("short look-ahead buffer: %d < %d", len(buf), size)
+	}
+	if string(buf[:9]) != "prefix ab" {
+		t.Errorf("wrong data %q", buf[:9])
+	}
+
+	scan.Skip(len("prefix "))
+	if !scan.NextWindow(10 * peekBufferSize) {
+		t.Fatal("unexpected end of data")
+	}
+	buf, err = scan.Peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != string(body) {
+		t.Error("wrong data at end of input")
+	}
+}
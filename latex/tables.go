@@ -0,0 +1,385 @@
+// tables.go - convert tabular environments to HTML tables
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/seehuhn/epublatex/latex/scanner"
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+// column describes one column of a table, as given by the column
+// specification of a tabular environment.
+type column struct {
+	Align     string
+	Width     string
+	LeftRule  bool
+	RightRule bool
+}
+
+// tableRule describes a horizontal rule.  The rule covers the columns
+// From, ..., To (counting from 0); if To is negative, the rule covers
+// the full width of the table.
+type tableRule struct {
+	Thick    bool
+	From, To int
+}
+
+func (r *tableRule) covers(col, span int) bool {
+	if r.To < 0 {
+		return true
+	}
+	return col <= r.To && col+span > r.From
+}
+
+type tableRow struct {
+	Cells []tokenizer.TokenList
+	Above []*tableRule
+	Below []*tableRule
+}
+
+var cssLength = regexp.MustCompile(`^[0-9]*\.?[0-9]+(cm|mm|in|pt|em|ex)$`)
+
+// parseColumns interprets the column specification of a tabular
+// environment.
+func parseColumns(pos scanner.Pos, spec string) []*column {
+	var cols []*column
+	leftRule := false
+	for {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			break
+		}
+		c := spec[0]
+		spec = spec[1:]
+
+		var col *column
+		switch c {
+		case '|':
+			if len(cols) == 0 {
+				leftRule = true
+			} else {
+				cols[len(cols)-1].RightRule = true
+			}
+		case 'l':
+			col = &column{Align: "left"}
+		case 'c':
+			col = &column{Align: "center"}
+		case 'r':
+			col = &column{Align: "right"}
+		case 'p', 'm', 'b':
+			var width string
+			width, spec = nextGroup(spec)
+			col = &column{Align: "justify"}
+			if cssLength.MatchString(width) {
+				col.Width = width
+			}
+		case 'X':
+			col = &column{Align: "justify"}
+		case '@', '!', '>', '<':
+			_, spec = nextGroup(spec)
+		case '*':
+			var count, body string
+			count, spec = nextGroup(spec)
+			body, spec = nextGroup(spec)
+			n, err := strconv.Atoi(strings.TrimSpace(count))
+			if err != nil {
+				warn(pos, "invalid repeat count %q in column specification",
+					count)
+				n = 1
+			}
+			spec = strings.Repeat(body, n) + spec
+		default:
+			warn(pos, "unknown column type %q", c)
+			col = &column{Align: "left"}
+		}
+		if col != nil {
+			col.LeftRule = leftRule && len(cols) == 0
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// nextGroup splits off the first argument from a column
+// specification.  The argument is either a group enclosed in braces
+// or a single character.
+func nextGroup(spec string) (string, string) {
+	spec = strings.TrimLeft(spec, " \t\n")
+	if spec == "" {
+		return "", ""
+	}
+	if spec[0] != '{' {
+		return spec[:1], spec[1:]
+	}
+	level := 0
+	for i, c := range spec {
+		switch c {
+		case '{':
+			level++
+		case '}':
+			level--
+			if level == 0 {
+				return spec[1:i], spec[i+1:]
+			}
+		}
+	}
+	return spec[1:], ""
+}
+
+// parseRule returns the horizontal rule described by the token, or
+// nil if the token does not describe a rule.
+func parseRule(token *tokenizer.Token) *tableRule {
+	if token.Type != tokenizer.TokenMacro {
+		return nil
+	}
+	switch token.Name {
+	case "\\hline", "\\midrule":
+		return &tableRule{To: -1}
+	case "\\toprule", "\\bottomrule":
+		return &tableRule{Thick: true, To: -1}
+	case "\\cline", "\\cmidrule":
+		cols := token.Args[len(token.Args)-1].String()
+		parts := strings.SplitN(cols, "-", 2)
+		if len(parts) != 2 {
+			return nil
+		}
+		from, e1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+		to, e2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if e1 != nil || e2 != nil || from < 1 || to < from {
+			return nil
+		}
+		return &tableRule{From: from - 1, To: to - 1}
+	}
+	return nil
+}
+
+// splitTable splits the body of a tabular environment into rows and
+// cells.  The method also returns the number of leading rows which
+// form the table header; these are the rows before a booktabs
+// \midrule.
+func splitTable(body tokenizer.TokenList) ([]*tableRow, int) {
+	var rows []*tableRow
+	row := &tableRow{}
+	var cell tokenizer.TokenList
+	header := 0
+	seenMidrule := false
+	for _, token := range body {
+		switch {
+		case token.Type == tokenizer.TokenOther && token.Name == "&":
+			row.Cells = append(row.Cells, cell)
+			cell = nil
+		case token.Type == tokenizer.TokenMacro &&
+			(token.Name == "\\\\" || token.Name == "\\tabularnewline"):
+			row.Cells = append(row.Cells, cell)
+			cell = nil
+			rows = append(rows, row)
+			row = &tableRow{}
+		case len(row.Cells) == 0 && isBlank(cell) && parseRule(token) != nil:
+			row.Above = append(row.Above, parseRule(token))
+			if token.Name == "\\midrule" && !seenMidrule {
+				header = len(rows)
+				seenMidrule = true
+			}
+		case len(row.Cells) == 0 && isBlank(cell) &&
+			token.Type == tokenizer.TokenMacro &&
+			token.Name == "\\addlinespace":
+			// pass
+		default:
+			cell = append(cell, token)
+		}
+	}
+	if len(row.Cells) > 0 || !isBlank(cell) {
+		row.Cells = append(row.Cells, cell)
+		rows = append(rows, row)
+	} else if len(rows) > 0 {
+		rows[len(rows)-1].Below = row.Above
+	}
+	return rows, header
+}
+
+// isBlank checks whether a list of tokens contains only white space
+// and comments.
+func isBlank(tokens tokenizer.TokenList) bool {
+	for _, token := range tokens {
+		if token.Type != tokenizer.TokenSpace &&
+			token.Type != tokenizer.TokenComment {
+			return false
+		}
+	}
+	return true
+}
+
+// trimCell removes leading and trailing white space and comments from
+// the contents of a table cell.
+func trimCell(tokens tokenizer.TokenList) tokenizer.TokenList {
+	for len(tokens) > 0 && isBlank(tokens[:1]) {
+		tokens = tokens[1:]
+	}
+	for len(tokens) > 0 && isBlank(tokens[len(tokens)-1:]) {
+		tokens = tokens[:len(tokens)-1]
+	}
+	return tokens
+}
+
+// mTabular converts a tabular environment into a HTML table.
+func mTabular(args []*tokenizer.Arg, conv *converter) string {
+	pos := conv.Pos
+	n := len(args)
+	envName := args[0].String()
+	cols := parseColumns(pos, args[n-2].String())
+	rows, header := splitTable(args[n-1].Value)
+
+	res := []string{
+		`<table class="` + cssPrefix + strings.TrimSuffix(envName, "*") +
+			`">` + "\n",
+	}
+	if header > 0 {
+		res = append(res, "<thead>\n")
+	} else {
+		res = append(res, "<tbody>\n")
+	}
+	covered := make(map[int]int)
+	for i, row := range rows {
+		if i == header && header > 0 {
+			res = append(res, "</thead>\n<tbody>\n")
+		}
+		tag := "td"
+		if i < header {
+			tag = "th"
+		}
+
+		active := make(map[int]bool)
+		for c, count := range covered {
+			if count > 0 {
+				active[c] = true
+			}
+		}
+
+		res = append(res, "<tr>\n")
+		col := 0
+		for _, cell := range row.Cells {
+			cell = trimCell(cell)
+			colSpan := 1
+			rowSpan := 1
+			info := &column{Align: "left"}
+			if col < len(cols) {
+				info = cols[col]
+			}
+
+			if len(cell) == 1 && cell[0].Type == tokenizer.TokenMacro &&
+				cell[0].Name == "\\multicolumn" {
+				mcArgs := cell[0].Args
+				colSpan = spanCount(pos, mcArgs[0].String())
+				mcCols := parseColumns(pos, mcArgs[1].String())
+				if len(mcCols) > 0 {
+					info = mcCols[0]
+				}
+				cell = trimCell(mcArgs[2].Value)
+			}
+			if active[col] {
+				// This cell is covered by a \multirow cell above.
+				if !isBlank(cell) {
+					warn(pos, "table cell below \\multirow is not empty")
+				}
+				col += colSpan
+				continue
+			}
+
+			if len(cell) == 1 && cell[0].Type == tokenizer.TokenMacro &&
+				cell[0].Name == "\\multirow" {
+				mrArgs := cell[0].Args
+				rowSpan = spanCount(pos, mrArgs[1].String())
+				cell = trimCell(mrArgs[5].Value)
+				for k := col; k < col+colSpan; k++ {
+					covered[k] = rowSpan - 1
+				}
+			}
+
+			var classes []string
+			classes = append(classes, cssPrefix+"align-"+info.Align)
+			if info.LeftRule {
+				classes = append(classes, cssPrefix+"vrule-left")
+			}
+			if info.RightRule {
+				classes = append(classes, cssPrefix+"vrule-right")
+			}
+			classes = append(classes, ruleClasses(row.Above, "above", col, colSpan)...)
+			classes = append(classes, ruleClasses(row.Below, "below", col, colSpan)...)
+
+			attrs := ` class="` + strings.Join(classes, " ") + `"`
+			if tag == "th" {
+				attrs = ` scope="col"` + attrs
+			}
+			if colSpan > 1 {
+				attrs += ` colspan="` + strconv.Itoa(colSpan) + `"`
+			}
+			if rowSpan > 1 {
+				attrs += ` rowspan="` + strconv.Itoa(rowSpan) + `"`
+			}
+			if info.Width != "" {
+				attrs += ` style="width: ` + info.Width + `"`
+			}
+			body := strings.TrimSpace(conv.convertHTML(cell))
+			res = append(res, "<"+tag+attrs+">"+body+"</"+tag+">\n")
+			col += colSpan
+		}
+		res = append(res, "</tr>\n")
+
+		for c := range active {
+			covered[c]--
+		}
+	}
+	if header > 0 && header >= len(rows) {
+		res = append(res, "</thead>\n")
+	} else {
+		res = append(res, "</tbody>\n")
+	}
+	res = append(res, "</table>\n")
+	return strings.Join(res, "")
+}
+
+func spanCount(pos scanner.Pos, arg string) int {
+	n, err := strconv.Atoi(strings.TrimSpace(arg))
+	if err != nil || n < 1 {
+		warn(pos, "invalid table span %q", arg)
+		return 1
+	}
+	return n
+}
+
+func ruleClasses(rules []*tableRule, where string, col, span int) []string {
+	var classes []string
+	seen := make(map[string]bool)
+	for _, rule := range rules {
+		if !rule.covers(col, span) {
+			continue
+		}
+		name := cssPrefix + "rule-" + where
+		if rule.Thick {
+			name = cssPrefix + "thickrule-" + where
+		}
+		if !seen[name] {
+			classes = append(classes, name)
+			seen[name] = true
+		}
+	}
+	return classes
+}
@@ -0,0 +1,133 @@
+// tables_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package latex
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/seehuhn/epublatex/latex/scanner"
+	"github.com/seehuhn/epublatex/latex/tokenizer"
+)
+
+func TestParseColumns(t *testing.T) {
+	cols := parseColumns(scanner.Pos{}, "|l|c*{2}{r}@{.}p{3cm}|")
+	if len(cols) != 5 {
+		t.Fatalf("wrong number of columns: %d", len(cols))
+	}
+	aligns := []string{"left", "center", "right", "right", "justify"}
+	for i, col := range cols {
+		if col.Align != aligns[i] {
+			t.Errorf("column %d: wrong alignment %q", i, col.Align)
+		}
+	}
+	if !cols[0].LeftRule || !cols[0].RightRule || cols[1].LeftRule {
+		t.Error("wrong vertical rules for first columns")
+	}
+	if cols[4].Width != "3cm" || !cols[4].RightRule {
+		t.Error("wrong paragraph column", cols[4])
+	}
+}
+
+func TestTabular(t *testing.T) {
+	src := `\usepackage{booktabs}\usepackage{multirow}%
+\begin{tabular}{lcr}
+\toprule
+A & B & C \\
+\midrule
+\multirow{2}{*}{x} & \multicolumn{2}{c}{\textit{y}} \\
+\cmidrule{2-3}
+ & 1 & 2 \\
+\bottomrule
+\end{tabular}`
+
+	out := convertTable(t, src)
+
+	expected := []string{
+		`<thead>`,
+		`<th scope="col" class="latex-align-left latex-thickrule-above">A</th>`,
+		`</thead>`,
+		`<td class="latex-align-left latex-rule-above" rowspan="2">x</td>`,
+		`<td class="latex-align-center latex-rule-above" colspan="2"><i>y</i></td>`,
+		"<tr>\n<td class=\"latex-align-center latex-rule-above latex-thickrule-below\">1</td>",
+		`<td class="latex-align-right latex-rule-above latex-thickrule-below">2</td>`,
+	}
+	for _, s := range expected {
+		if !strings.Contains(out, s) {
+			t.Errorf("%q missing from output:\n%s", s, out)
+		}
+	}
+}
+
+// convertTable converts the first tabular environment in `src` to HTML.
+func convertTable(t *testing.T, src string) string {
+	toks := tokenizer.NewTokenizer()
+	defer toks.Close()
+	toks.Prepend([]byte(src), "test")
+	c := make(chan *tokenizer.Token)
+	go func() {
+		err := toks.ParseTex(c)
+		if err != nil {
+			t.Error(err)
+		}
+		close(c)
+	}()
+	var table *tokenizer.Token
+	for tok := range c {
+		if tok.Type == tokenizer.TokenMacro && tok.Name == "%tabular%" {
+			table = tok
+		}
+	}
+	if table == nil {
+		t.Fatal("table not found")
+	}
+
+	conv, err := newConverter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conv.Close()
+	return conv.convertHTML(tokenizer.TokenList{table})
+}
+
+func TestCmidrule(t *testing.T) {
+	src := `\usepackage{booktabs}%
+\begin{tabular}{lcr}
+A & B & C \\
+\cmidrule(lr){2-3}
+D & E & F \\
+\cmidrule[0.5pt] (r) {1-1}
+G & H & I \\
+\end{tabular}`
+	out := convertTable(t, src)
+
+	expected := []string{
+		`<td class="latex-align-left">D</td>`,
+		`<td class="latex-align-center latex-rule-above">E</td>`,
+		`<td class="latex-align-right latex-rule-above">F</td>`,
+		`<td class="latex-align-left latex-rule-above">G</td>`,
+		`<td class="latex-align-center">H</td>`,
+	}
+	for _, s := range expected {
+		if !strings.Contains(out, s) {
+			t.Errorf("%q missing from output:\n%s", s, out)
+		}
+	}
+	if strings.Contains(out, "lr") || strings.Contains(out, "1-1") {
+		t.Errorf("rule arguments in output:\n%s", out)
+	}
+}
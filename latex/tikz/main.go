@@ -3,6 +3,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"image/png"
@@ -28,7 +29,11 @@ func main() {
 	flag.Parse()
 
 	out := make(chan *render.BookImage)
-	renderer, err := tikz.NewRenderer(out)
+	queue, err := render.NewQueue()
+	if err != nil {
+		log.Fatal(err)
+	}
+	renderer, err := tikz.NewRenderer(out, queue)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -54,12 +59,16 @@ func main() {
 	}(out)
 
 	renderer.AddPreamble(`\usetikzlibrary{decorations.pathreplacing}`)
-	renderer.AddPicture(picture)
+	renderer.AddPicture(context.Background(), "", picture)
 
 	err = renderer.Finish()
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = queue.Finish(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	close(out)
 	<-done
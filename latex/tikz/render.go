@@ -17,10 +17,12 @@
 package tikz
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"image"
-	"log"
+	"strings"
 	"sync"
 	"text/template"
 
@@ -33,47 +35,73 @@ import (
 var noCache = flag.Bool("latex-tikz-no-cache", false,
 	"whether to disable the TikZ rendering cache")
 
+var errMissing = errors.New("missing image")
+
 const (
 	renderRes = 300     // render resolution [pixels / inch]
 	exHeight  = 4.30554 // x-height of cmi10 [TeX pt / ex]
-	exPerPix  = 72.27 / exHeight / float64(renderRes)
+	ptPerPix  = 72.27 / float64(renderRes)
 
-	tikzCachePruneLimit = 256 * 1024
+	defaultFontSize = 10 // [TeX pt]
 )
 
 type Renderer struct {
 	out chan<- *render.BookImage
 
-	preamble []string
-	seen     map[string]bool
-	cache    *cache.Cache
+	preamble    []string
+	preambleKey string
+	seen        map[string]bool
+	cache       *cache.Cache
+	engine      string
+	fontSize    float64
 
 	queue    *render.Queue
 	children *sync.WaitGroup
 
+	mutex    sync.Mutex
+	failures render.Errors
+
+	svg  bool
 	tmpl *template.Template
 }
 
-func NewRenderer(out chan<- *render.BookImage) (*Renderer, error) {
+// NewRenderer creates a new Renderer.  The pictures are rendered
+// using the given queue, which may be shared with other renderers,
+// and the images are written to the channel `out`.
+func NewRenderer(out chan<- *render.BookImage, queue *render.Queue) (*Renderer, error) {
 	r := &Renderer{
 		out:      out,
 		seen:     make(map[string]bool),
+		queue:    queue,
+		engine:   queue.EngineVersion(),
+		fontSize: defaultFontSize,
 		children: &sync.WaitGroup{},
+		svg:      render.UseSVG(),
 	}
 
-	queue, err := render.NewQueue(renderRes)
-	if err != nil {
-		return nil, err
+	var c *cache.Cache
+	var err error
+	if r.svg {
+		c, err = cache.NewDataCache("tikz-svg", ".svg")
+	} else {
+		c, err = cache.NewCache("tikz")
 	}
-	r.queue = queue
-
-	cache, err := cache.NewCache("tikz")
 	if err != nil {
 		return nil, err
 	}
-	r.cache = cache
+	c.Engine = r.engine
+	if !r.svg {
+		c.Resolution = renderRes
+	}
+	r.cache = c
 
-	tmpl, err := template.New("tikz").Parse(tikzTemplate)
+	tmplText := tikzTemplate
+	if r.svg {
+		// use the pgf driver for dvisvgm, instead of the default
+		// driver for DVI output
+		tmplText = "\\def\\pgfsysdriver{pgfsys-dvisvgm.def}\n" + tikzTemplate
+	}
+	tmpl, err := template.New("tikz").Parse(tmplText)
 	if err != nil {
 		return nil, err
 	}
@@ -82,23 +110,54 @@ func NewRenderer(out chan<- *render.BookImage) (*Renderer, error) {
 	return r, nil
 }
 
+// Finish waits until all images have been delivered.  The queue is
+// not shut down, since it may be used by other renderers.  If some
+// pictures could not be rendered, the returned error is of type
+// render.Errors and lists all these pictures.
 func (r *Renderer) Finish() error {
 	r.children.Wait()
-	err := r.queue.Finish()
-	e2 := r.cache.Close(tikzCachePruneLimit)
+	err := r.cache.Close(cache.PruneLimit())
 	if err == nil {
-		err = e2
+		err = r.failures.Err()
 	}
-
 	return err
 }
 
+// AddPreamble adds a line to the preamble of the TeX files used to
+// render the pictures.  Pictures added before the call are rendered
+// without the new line.
 func (r *Renderer) AddPreamble(line string) {
 	r.preamble = append(r.preamble, line)
+	r.preambleKey = render.PreambleKey(r.preamble)
 }
 
-func (r *Renderer) AddPicture(picture string) {
-	key := r.makeKey(picture)
+// SetFontSize sets the font size of the document, in TeX points.  The
+// pictures are rendered using this font size, and the width of the
+// images is given relative to the x-height of this font, so that the
+// pictures have the same size relative to the text as in the printed
+// document.  Pictures added before the call are not affected.
+func (r *Renderer) SetFontSize(size float64) {
+	r.fontSize = size
+}
+
+// exPerPt converts TeX points into multiples of the x-height of the
+// document font.
+func (r *Renderer) exPerPt() float64 {
+	return 1 / (exHeight * r.fontSize / defaultFontSize)
+}
+
+// Key returns the string used as the image body for a TikZ picture
+// with the given options.
+func Key(options, picture string) string {
+	return options + "%" + picture
+}
+
+// AddPicture schedules a TikZ picture for rendering.  The argument
+// `options` gives the optional argument of the tikzpicture
+// environment, e.g. "scale=0.5".  The context is used for the
+// rendering job started by the call.
+func (r *Renderer) AddPicture(ctx context.Context, options, picture string) {
+	key := r.makeKey(options, picture)
 	if r.seen[key] {
 		// avoid including the same image twice
 		return
@@ -107,13 +166,31 @@ func (r *Renderer) AddPicture(picture string) {
 
 	info := &pictureInfo{
 		key:     key,
+		options: options,
 		picture: picture,
+		exPerPt: r.exPerPt(),
 	}
 
 	if !*noCache && r.cache.Has(key) {
+		if r.svg {
+			data, err := r.cache.GetData(key)
+			var svg *render.SVG
+			if err == nil {
+				svg, err = render.ParseSVG(data)
+			}
+			if err != nil {
+				// render the image again, replacing the damaged
+				// cache entry
+				goto render
+			}
+			r.submitSVG(info, svg)
+			return
+		}
+
 		img, err := r.cache.Get(key)
 		if err != nil {
-			log.Println("cache failure:", err)
+			// render the image again, replacing the damaged
+			// cache entry
 			goto render
 		}
 		r.submit(info, img)
@@ -122,40 +199,89 @@ func (r *Renderer) AddPicture(picture string) {
 
 render:
 	data := map[string]interface{}{
+		"FontSize": r.fontSize,
 		"Preamble": r.preamble,
+		"Options":  options,
 		"Body":     picture,
 	}
-	in := r.queue.Submit(r.tmpl, data)
+	if r.svg {
+		r.renderSVG(ctx, info, data)
+		return
+	}
+	in, errc := r.queue.Submit(ctx, renderRes, r.tmpl, data)
 
 	r.children.Add(1)
 	go func(info *pictureInfo) {
 		img := <-in
-		if img == nil {
-			log.Println("missing image", info.picture)
+		for range in {
+			// Submit delivers at most one image
+		}
+		err := <-errc
+		if img == nil || err != nil {
+			r.failed(info, err)
 		} else {
 			r.cache.Put(info.key, img)
 			r.submit(info, img)
 		}
 		r.children.Done()
+	}(info)
+}
 
+func (r *Renderer) renderSVG(ctx context.Context, info *pictureInfo, data interface{}) {
+	in, errc := r.queue.SubmitSVG(ctx, r.tmpl, data)
+
+	r.children.Add(1)
+	go func() {
+		svg := <-in
 		for range in {
-			log.Println("error: received unexpected image from renderer")
+			// Submit delivers at most one image
 		}
-	}(info)
+		err := <-errc
+		if svg == nil || err != nil {
+			r.failed(info, err)
+		} else {
+			r.cache.PutData(info.key, svg.Data)
+			r.submitSVG(info, svg)
+		}
+		r.children.Done()
+	}()
 }
 
-func (r *Renderer) submit(info *pictureInfo, img image.Image) {
-	alt := "[image]"
-	if len(info.picture) <= 60 {
-		// TODO(voss): is this case really relevant?  Can we get a
-		// user-supplied alt string?
-		alt = info.picture
+// failed replaces a picture which could not be rendered by its TeX
+// source.
+func (r *Renderer) failed(info *pictureInfo, err error) {
+	if err == nil {
+		err = errMissing
+	}
+	r.mutex.Lock()
+	r.failures = append(r.failures, fmt.Errorf("TikZ picture %q: %w",
+		info.alt(), err))
+	r.mutex.Unlock()
+
+	begin := "\\begin{tikzpicture}"
+	if info.options != "" {
+		begin += "[" + info.options + "]"
+	}
+	job := &render.BookImage{
+		Env:  "tikzpicture",
+		Body: Key(info.options, info.picture),
+
+		CssClass: "tikzpicture",
+
+		Err: err,
+		Source: begin + "\n" + strings.TrimSpace(info.picture) +
+			"\n\\end{tikzpicture}",
 	}
-	exWidth := float64(img.Bounds().Dx()) * exPerPix
+	r.out <- job
+}
+
+func (r *Renderer) submit(info *pictureInfo, img image.Image) {
+	alt := info.alt()
+	exWidth := float64(img.Bounds().Dx()) * ptPerPix * info.exPerPt
 	style := fmt.Sprintf("width: %.2fex", exWidth)
 	job := &render.BookImage{
 		Env:  "tikzpicture",
-		Body: info.picture,
+		Body: Key(info.options, info.picture),
 
 		Alt:      alt,
 		CssClass: "tikzpicture",
@@ -167,23 +293,54 @@ func (r *Renderer) submit(info *pictureInfo, img image.Image) {
 	r.out <- job
 }
 
-func (r *Renderer) makeKey(picture string) string {
-	// TODO(voss): should the preamble affect the key?
-	hash := sha3.Sum224([]byte(picture))
-	return fmt.Sprintf("tikz:%d:%f:%x", renderRes, exHeight, hash)
+func (r *Renderer) submitSVG(info *pictureInfo, svg *render.SVG) {
+	style := fmt.Sprintf("width: %.2fex", svg.Width*info.exPerPt)
+	job := &render.BookImage{
+		Env:  "tikzpicture",
+		Body: Key(info.options, info.picture),
+
+		Alt:      info.alt(),
+		CssClass: "tikzpicture",
+		Style:    style,
+
+		Type: render.BookImageTypeSVG,
+		Data: svg.Data,
+	}
+	r.out <- job
+}
+
+func (r *Renderer) makeKey(options, picture string) string {
+	hash := sha3.Sum224([]byte(Key(options, picture)))
+	if r.svg {
+		return fmt.Sprintf("tikz:svg:%s:%f:%g:%s:%x",
+			r.engine, exHeight, r.fontSize, r.preambleKey, hash)
+	}
+	return fmt.Sprintf("tikz:%d:%s:%f:%g:%s:%x",
+		renderRes, r.engine, exHeight, r.fontSize, r.preambleKey, hash)
 }
 
 type pictureInfo struct {
 	key     string
+	options string
 	picture string
+	exPerPt float64
+}
+
+func (info *pictureInfo) alt() string {
+	if len(info.picture) <= 60 {
+		// TODO(voss): is this case really relevant?  Can we get a
+		// user-supplied alt string?
+		return info.picture
+	}
+	return "[image]"
 }
 
-const tikzTemplate = `\documentclass[tikz]{standalone}
+const tikzTemplate = `\documentclass[tikz,{{.FontSize}}pt]{standalone}
 {{range .Preamble -}}
 {{.}}
 {{end}}
 \begin{document}
-\begin{tikzpicture}
+\begin{tikzpicture}{{with .Options}}[{{.}}]{{end}}
 {{.Body}}
 \end{tikzpicture}
 \end{document}
@@ -0,0 +1,143 @@
+// render_test.go - unit tests for render.go
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tikz
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/seehuhn/epublatex/latex/render"
+)
+
+func TestTemplate(t *testing.T) {
+	queue, err := newTestQueue(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Finish(context.Background())
+
+	r, err := NewRenderer(make(chan *render.BookImage), queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.AddPreamble(`\tikzset{every node/.style={draw}}`)
+	r.SetFontSize(12)
+	data := map[string]interface{}{
+		"FontSize": r.fontSize,
+		"Preamble": r.preamble,
+		"Options":  "scale=0.5",
+		"Body":     `\draw (0,0) -- (1,1);`,
+	}
+	buf := &bytes.Buffer{}
+	err = r.tmpl.Execute(buf, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tex := buf.String()
+	for _, expected := range []string{
+		`\documentclass[tikz,12pt]{standalone}`,
+		`\tikzset{every node/.style={draw}}`,
+		`\begin{tikzpicture}[scale=0.5]`,
+	} {
+		if !strings.Contains(tex, expected) {
+			t.Errorf("%q missing from TeX file:\n%s", expected, tex)
+		}
+	}
+}
+
+func TestScaling(t *testing.T) {
+	queue, err := newTestQueue(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer queue.Finish(context.Background())
+
+	out := make(chan *render.BookImage)
+	r, err := NewRenderer(out, queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The fake engine produces pages of width 40pt, which is
+	// 9.29ex for the default font size and 7.74ex at 12pt.
+	styles := make(map[string]string)
+	done := make(chan struct{})
+	go func() {
+		for img := range out {
+			styles[img.Body] = img.Style
+		}
+		close(done)
+	}()
+	r.AddPicture(context.Background(), "", "x")
+	r.SetFontSize(12)
+	r.AddPicture(context.Background(), "scale=2", "x")
+	err = r.Finish()
+	close(out)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := styles[Key("", "x")]; s != "width: 9.29ex" {
+		t.Errorf("wrong style %q for 10pt font", s)
+	}
+	if s := styles[Key("scale=2", "x")]; s != "width: 7.74ex" {
+		t.Errorf("wrong style %q for 12pt font", s)
+	}
+}
+
+// newTestQueue returns a render queue which uses the fake TeX engine
+// and a temporary cache directory.
+func newTestQueue(t *testing.T) (*render.Queue, error) {
+	dir, err := ioutil.TempDir("", "epublatex")
+	if err != nil {
+		return nil, err
+	}
+	oldEngine := flag.Lookup("latex-engine").Value.String()
+	oldCache := flag.Lookup("cache-dir").Value.String()
+	flag.Set("latex-engine", "fake")
+	flag.Set("cache-dir", dir)
+	t.Cleanup(func() {
+		flag.Set("latex-engine", oldEngine)
+		flag.Set("cache-dir", oldCache)
+		os.RemoveAll(dir)
+	})
+	return render.NewQueue()
+}
+
+func TestMakeKey(t *testing.T) {
+	renderers := []*Renderer{
+		{engine: "pdfTeX 3.14; gs"},
+		{engine: "pdfTeX 3.14; mutool"},
+		{engine: "XeTeX 3.14; gs"},
+		{engine: "pdfTeX 3.14; gs", svg: true},
+		{engine: "XeTeX 3.14; gs", svg: true},
+	}
+	seen := make(map[string]int)
+	for i, r := range renderers {
+		key := r.makeKey("", `\draw (0,0) -- (1,1);`)
+		if j, ok := seen[key]; ok {
+			t.Errorf("renderers %d and %d use the same key %q", j, i, key)
+		}
+		seen[key] = i
+	}
+}
@@ -18,16 +18,45 @@ package latex
 
 import (
 	"encoding/gob"
+	"flag"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/seehuhn/epublatex/latex/tokenizer"
 )
 
+var inputPath = flag.String("latex-path", "",
+	"list of directories to search for \\input files, separated by "+
+		string(os.PathListSeparator))
+
+// searchPath returns the directories to search for input files.
+// These are taken from the -latex-path command line option and from
+// the TEXINPUTS environment variable.  Empty entries, which refer to
+// the TeX system directories, are ignored.
+func searchPath() []string {
+	var res []string
+	for _, list := range []string{*inputPath, os.Getenv("TEXINPUTS")} {
+		for _, dir := range filepath.SplitList(list) {
+			// TeX uses "dir//" to search subdirectories, we only
+			// search the directory itself.
+			dir = strings.TrimSuffix(dir, "//")
+			if dir != "" {
+				res = append(res, dir)
+			}
+		}
+	}
+	return res
+}
+
 func (conv *converter) Tokenize(inputFileName string) error {
 	toks := tokenizer.NewTokenizer()
 	defer toks.Close()
-	toks.Include(inputFileName)
+	toks.SearchPath = searchPath()
+	err := toks.Include(inputFileName)
+	if err != nil {
+		return err
+	}
 	return conv.runTokenizer(toks)
 }
 
@@ -0,0 +1,199 @@
+// conditionals.go - TeX conditionals like \iftrue and \ifx
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// condMacro is a TeX conditional.  The function reads the arguments
+// of the conditional and evaluates the condition.
+type condMacro func(p *Tokenizer) (bool, error)
+
+func (cm condMacro) ReadArgs(p *Tokenizer, name string) (TokenList, error) {
+	val, err := cm(p)
+	if err != nil {
+		return nil, err
+	}
+	if val {
+		p.condDepth++
+		return nil, nil
+	}
+	foundElse, err := p.skipConditional(name, true)
+	if err != nil {
+		return nil, err
+	}
+	if foundElse {
+		p.condDepth++
+	}
+	return nil, nil
+}
+
+func isConditional(m macro) bool {
+	if am, ok := m.(*aliasMacro); ok {
+		m = am.Macro
+	}
+	_, ok := m.(condMacro)
+	return ok
+}
+
+func condTrue(p *Tokenizer) (bool, error) {
+	return true, nil
+}
+
+func condFalse(p *Tokenizer) (bool, error) {
+	return false, nil
+}
+
+func condIfx(p *Tokenizer) (bool, error) {
+	a, err := p.readMacroName()
+	if err != nil {
+		return false, err
+	}
+	b, err := p.readMacroName()
+	if err != nil {
+		return false, err
+	}
+	return p.sameMeaning(a, b), nil
+}
+
+func condIfdefined(p *Tokenizer) (bool, error) {
+	name, err := p.readMacroName()
+	if err != nil {
+		return false, err
+	}
+	return p.lookupMacro(name) != nil, nil
+}
+
+// sameMeaning implements the comparison used by \ifx.
+func (p *Tokenizer) sameMeaning(a, b string) bool {
+	if a[0] != '\\' || b[0] != '\\' {
+		return a == b
+	}
+	ma := p.lookupMacro(a)
+	mb := p.lookupMacro(b)
+	switch x := ma.(type) {
+	case nil:
+		return mb == nil
+	case *defMacro:
+		y, ok := mb.(*defMacro)
+		return ok && x.equal(y)
+	case letMacro:
+		y, ok := mb.(letMacro)
+		return ok && x == y
+	}
+	if mb == nil {
+		return false
+	}
+	return builtinName(a, ma) == builtinName(b, mb)
+}
+
+// builtinName returns the name of the builtin macro `m`.
+func builtinName(name string, m macro) string {
+	if am, ok := m.(*aliasMacro); ok {
+		return am.Name
+	}
+	return name
+}
+
+func parseNewif(p *Tokenizer, name string) (TokenList, error) {
+	defName, err := p.readMacroName()
+	if err != nil {
+		return nil, err
+	}
+	if len(defName) < 4 || defName[:3] != "\\if" {
+		return nil, p.MakeError(name + ": invalid name " + defName)
+	}
+	base := "\\" + defName[3:]
+	p.defineMacro(defName, &aliasMacro{
+		Name:  "\\iffalse",
+		Macro: condMacro(condFalse),
+	}, false)
+	p.defineMacro(base+"true", &defMacro{
+		Body: "\\let" + defName + "\\iftrue ",
+	}, false)
+	p.defineMacro(base+"false", &defMacro{
+		Body: "\\let" + defName + "\\iffalse ",
+	}, false)
+	return nil, nil
+}
+
+func parseElse(p *Tokenizer, name string) (TokenList, error) {
+	if p.condDepth == 0 {
+		p.warn("extra " + name)
+		return nil, nil
+	}
+	_, err := p.skipConditional(name, false)
+	if err != nil {
+		return nil, err
+	}
+	p.condDepth--
+	return nil, nil
+}
+
+func parseFi(p *Tokenizer, name string) (TokenList, error) {
+	if p.condDepth == 0 {
+		p.warn("extra " + name)
+		return nil, nil
+	}
+	p.condDepth--
+	return nil, nil
+}
+
+// skipConditional skips input up to the \fi which ends the current
+// conditional.  If stopAtElse is set, the method stops at a matching
+// \else instead, and reports whether this happened.  Nested
+// conditionals are skipped as a whole.
+func (p *Tokenizer) skipConditional(name string, stopAtElse bool) (bool, error) {
+	level := 0
+	for p.Next() {
+		buf, err := p.Peek()
+		if err != nil {
+			return false, err
+		}
+
+		switch buf[0] {
+		case '%':
+			_, err = p.readComment()
+			if err != nil {
+				return false, err
+			}
+		case '\\':
+			n := controlSequenceLength(buf)
+			csName := string(buf[:n])
+			p.Skip(n)
+			switch {
+			case csName == "\\fi" && level == 0,
+				csName == "\\else" && level == 0 && stopAtElse:
+				_, err = p.skipWhiteSpace()
+				return csName == "\\else", err
+			case csName == "\\fi":
+				level--
+			case isConditional(p.lookupMacro(csName)):
+				level++
+			}
+		default:
+			n := bytes.IndexAny(buf, "\\%")
+			if n < 0 {
+				n = len(buf)
+			}
+			p.Skip(n)
+		}
+	}
+	return false, fmt.Errorf("%s: %s not terminated by \\fi", p.tokPos, name)
+}
@@ -0,0 +1,59 @@
+// conditionals_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+import "testing"
+
+func TestConditionals(t *testing.T) {
+	testCases := []struct{ in, out string }{
+		{`\iftrue a\else b\fi`, "a"},
+		{`\iffalse a\else b\fi`, "b"},
+		{`\iffalse a\fi b`, "b"},
+		{`\ifepub a\else b\fi`, "a"},
+		{`\newif\ifepub\epubfalse\ifepub a\else b\fi`, "a"},
+		{`\iffalse \iftrue a\else b\fi c\else d\fi`, "d"},
+		{`\iftrue \iffalse a\else b\fi c\else d\fi`, "bc"},
+		{`\iffalse % \fi
+a\else b\fi`, "b"},
+		{`\newif\ifebook\ifebook a\else b\fi`, "b"},
+		{`\newif\ifebook\ebooktrue\ifebook a\else b\fi`, "a"},
+		{`\newif\ifebook\ebooktrue\ebookfalse\ifebook a\else b\fi`, "b"},
+		{`\newif\ifebook\iffalse\ifebook a\fi b\else c\fi`, "c"},
+		{`\def\a{x}\def\b{x}\ifx\a\b y\else n\fi`, "y"},
+		{`\def\a{x}\def\b{z}\ifx\a\b y\else n\fi`, "n"},
+		{`\let\a\textit\ifx\a\textit y\else n\fi`, "y"},
+		{`\ifx\a\undefined y\else n\fi`, "y"},
+		{`\ifx aay\else n\fi`, "y"},
+		{`\ifdefined\textit y\else n\fi`, "y"},
+		{`\ifdefined\foo y\else n\fi`, "n"},
+		{`\newif\ifa\ifx\ifa\iffalse y\else n\fi`, "y"},
+		{`\newif\ifa\atrue\ifx\ifa\iffalse y\else n\fi`, "n"},
+	}
+	for i, testCase := range testCases {
+		out := parseString(testCase.in).FormatText()
+		if out != testCase.out {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.out, out)
+		}
+	}
+}
+
+func TestConditionalsUnterminated(t *testing.T) {
+	_, err := NewTokenizer().tokenizeString(`\iffalse a`)
+	if err == nil {
+		t.Error("missing \\fi not detected")
+	}
+}
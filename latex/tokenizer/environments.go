@@ -16,6 +16,8 @@
 
 package tokenizer
 
+import "strings"
+
 type isEnd func(tok *Token) bool
 
 type environment interface {
@@ -56,13 +58,21 @@ func (env typedEnv) ReadArgs(p *Tokenizer, name string) (TokenList, isEnd, error
 			if err != nil {
 				return nil, nil, err
 			}
-			args = append(args, &Arg{Optional: false, Value: parseString(arg)})
+			val, err := p.parseArg(arg)
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, &Arg{Optional: false, Value: val})
 		case 'O':
 			arg, err := p.readOptionalArg()
 			if err != nil {
 				return nil, nil, err
 			}
-			args = append(args, &Arg{Optional: true, Value: parseString(arg)})
+			val, err := p.parseArg(arg)
+			if err != nil {
+				return nil, nil, err
+			}
+			args = append(args, &Arg{Optional: true, Value: val})
 		case 'V':
 			arg, err := p.readMandatoryArg()
 			if err != nil {
@@ -101,6 +111,7 @@ func (env verbatimEnv) ReadArgs(p *Tokenizer, name string) (TokenList, isEnd, er
 		},
 	}
 	res := TokenList{&Token{Type: TokenMacro, Name: string(env), Args: args}}
+	p.endGroup(name)
 	return res, nil, nil
 }
 
@@ -113,7 +124,11 @@ func (env collectEnv) ReadArgs(p *Tokenizer, name string) (TokenList, isEnd, err
 	if err != nil {
 		return nil, nil, err
 	}
-	args = append(args, &Arg{Optional: true, Value: parseString(arg)})
+	val, err := p.parseArg(arg)
+	if err != nil {
+		return nil, nil, err
+	}
+	args = append(args, &Arg{Optional: true, Value: val})
 
 	endFn := func(token *Token) bool {
 		return isMacro(token, "\\end", name)
@@ -121,3 +136,126 @@ func (env collectEnv) ReadArgs(p *Tokenizer, name string) (TokenList, isEnd, err
 
 	return TokenList{&Token{Type: TokenMacro, Name: string(env), Args: args}}, endFn, nil
 }
+
+// tableEnv is an environment which is collected into a single
+// "%tabular%" token.  The arguments of the token are the name of the
+// environment, the arguments described by the string (as for
+// typedEnv), and the body of the environment.
+type tableEnv string
+
+func (env tableEnv) ReadArgs(p *Tokenizer, name string) (TokenList, isEnd, error) {
+	res, _, err := typedEnv(env).ReadArgs(p, name)
+	if err != nil {
+		return nil, nil, err
+	}
+	res[0].Name = "%tabular%"
+
+	endFn := func(token *Token) bool {
+		return isMacro(token, "\\end", name)
+	}
+	return res, endFn, nil
+}
+
+// formatTable converts a token generated by tableEnv back into LaTeX
+// source.  This is used for array environments inside maths.
+func formatTable(tok *Token) string {
+	n := len(tok.Args)
+	begin := &Token{Type: TokenMacro, Name: "\\begin", Args: tok.Args[:n-1]}
+	end := &Token{Type: TokenMacro, Name: "\\end", Args: tok.Args[:1]}
+	return TokenList{begin}.FormatMaths() + tok.Args[n-1].Value.FormatMaths() +
+		TokenList{end}.FormatMaths()
+}
+
+// defEnv is an environment defined using \newenvironment.  The begin
+// code is expanded at \begin, the end code at the matching \end.
+type defEnv struct {
+	Begin *defMacro
+	End   string
+}
+
+func (env *defEnv) ReadArgs(p *Tokenizer, name string) (TokenList, isEnd, error) {
+	_, err := env.Begin.ReadArgs(p, "\\begin{"+name+"}")
+	return nil, nil, err
+}
+
+func parseNewenvironment(p *Tokenizer, name string) (TokenList, error) {
+	_, err := p.readOptionalStar()
+	if err != nil {
+		return nil, err
+	}
+	envName, err := p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+	envName = strings.TrimSpace(envName)
+	begin, err := p.readArgSpec(envName)
+	if err != nil {
+		return nil, err
+	}
+	begin.Body, err = p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+	end, err := p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+
+	exists := p.lookupEnvironment(envName) != nil
+	switch name {
+	case "\\newenvironment":
+		if exists {
+			// Like LaTeX after the error, keep the old definition.
+			p.warn(name + ": " + envName + " already defined")
+			return nil, nil
+		}
+	case "\\renewenvironment":
+		if !exists {
+			p.warn(name + ": " + envName + " not defined")
+		}
+	}
+
+	p.defineEnvironment(envName, &defEnv{
+		Begin: begin,
+		End:   end,
+	}, false)
+	return nil, nil
+}
+
+func parseEnd(p *Tokenizer, name string) (TokenList, error) {
+	envName, err := p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+
+	if env, ok := p.lookupEnvironment(envName).(*defEnv); ok {
+		// The group is closed only after the end code has been
+		// expanded, so that the end code can use local definitions
+		// made by the begin code.
+		endCode := env.End + "\\epubendgroup{" + envName + "}"
+		p.expand([]byte(endCode), "\\end{"+envName+"}")
+		return nil, nil
+	}
+	p.endGroup(envName)
+
+	tok := &Token{
+		Type: TokenMacro,
+		Name: name,
+		Args: []*Arg{
+			&Arg{
+				Optional: false,
+				Value:    TokenList{verbatim(envName)},
+			},
+		},
+	}
+	return TokenList{tok}, nil
+}
+
+func parseEndgroup(p *Tokenizer, name string) (TokenList, error) {
+	envName, err := p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+	p.endGroup(envName)
+	return nil, nil
+}
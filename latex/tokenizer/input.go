@@ -0,0 +1,113 @@
+// input.go - \input, \include and \includeonly
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+import (
+	"io"
+	"strings"
+)
+
+// parseInput implements \input, both in the LaTeX form \input{file}
+// and in the TeX form \input file.
+func parseInput(p *Tokenizer, name string) (TokenList, error) {
+	if !p.Next() {
+		return nil, io.EOF
+	}
+	buf, err := p.Peek()
+	if err != nil {
+		return nil, err
+	}
+	var fileName string
+	if buf[0] == '{' {
+		fileName, err = p.readMandatoryArg()
+	} else {
+		fileName, err = p.readFileName()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, p.Include(strings.TrimSpace(fileName))
+}
+
+// readFileName reads a file name which is not enclosed in braces.
+// The file name is terminated by white space, which is skipped.
+func (p *Tokenizer) readFileName() (string, error) {
+	var res []byte
+	for p.Next() {
+		buf, err := p.Peek()
+		if err != nil {
+			return "", err
+		}
+
+		pos := 0
+		for pos < len(buf) && !isSpace(buf[pos]) &&
+			!strings.ContainsRune("{}\\%", rune(buf[pos])) {
+			pos++
+		}
+		res = append(res, buf[:pos]...)
+		if pos < len(buf) && isSpace(buf[pos]) {
+			pos++
+		}
+		p.Skip(pos)
+
+		if pos < len(buf) {
+			break
+		}
+	}
+	if len(res) == 0 {
+		return "", p.MakeError("missing file name")
+	}
+	return string(res), nil
+}
+
+// parseInclude implements \include.  The included file starts and
+// ends with a page break.  Files not listed in \includeonly are
+// skipped.
+func parseInclude(p *Tokenizer, name string) (TokenList, error) {
+	fileName, err := p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+	fileName = strings.TrimSpace(fileName)
+	if p.includeOnly != nil && !p.includeOnly[fileName] {
+		return nil, nil
+	}
+
+	// The page break after the file is read once the file is done.
+	p.expand([]byte("\\clearpage "), name+"{"+fileName+"}")
+	err = p.Include(fileName)
+	if err != nil {
+		return nil, err
+	}
+	tok := &Token{Type: TokenMacro, Name: "\\clearpage"}
+	return TokenList{tok}, nil
+}
+
+func parseIncludeonly(p *Tokenizer, name string) (TokenList, error) {
+	list, err := p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+	p.includeOnly = make(map[string]bool)
+	for _, fileName := range strings.Split(list, ",") {
+		fileName = strings.TrimSpace(fileName)
+		if fileName != "" {
+			p.includeOnly[fileName] = true
+		}
+	}
+	return nil, nil
+}
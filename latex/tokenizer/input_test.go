@@ -0,0 +1,99 @@
+// input_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tokenizeFiles(t *testing.T, files map[string]string) (TokenList, error) {
+	tmp, err := ioutil.TempDir("", "tokenizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	for name, body := range files {
+		err = ioutil.WriteFile(filepath.Join(tmp, name), []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p := NewTokenizer()
+	defer p.Close()
+	p.BaseDir = tmp
+	err = p.Include("main.tex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan *Token)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- p.ParseTex(c)
+		close(c)
+	}()
+	var res TokenList
+	for tok := range c {
+		res = append(res, tok)
+	}
+	return res, <-errChan
+}
+
+func TestInput(t *testing.T) {
+	testCases := []struct {
+		main, out string
+	}{
+		{`<\input{a}>`, "<A>"},
+		{`<\input{a.tex}>`, "<A>"},
+		{`<\input a >`, "<A>"},
+		{`<\input a.tex >`, "<A>"},
+		{`<\include{a}>`, `<\clearpage A\clearpage>`},
+		{`\includeonly{b}<\include{a}\include{b}>`,
+			`<\clearpage B\clearpage>`},
+		{`\includeonly{a, b}<\include{a}\include{b}>`,
+			`<\clearpage A\clearpage\clearpage B\clearpage>`},
+	}
+	for i, testCase := range testCases {
+		toks, err := tokenizeFiles(t, map[string]string{
+			"main.tex": testCase.main,
+			"a.tex":    "A",
+			"b.tex":    "B",
+		})
+		if err != nil {
+			t.Errorf("test %d: %s", i, err)
+			continue
+		}
+		out := toks.FormatText()
+		if out != testCase.out {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.out, out)
+		}
+	}
+}
+
+func TestInputCycle(t *testing.T) {
+	_, err := tokenizeFiles(t, map[string]string{
+		"main.tex": `\input{a}`,
+		"a.tex":    `\input{b}`,
+		"b.tex":    `\input{main}`,
+	})
+	if err == nil {
+		t.Error("cyclic \\input not detected")
+	}
+}
@@ -18,9 +18,7 @@
 package tokenizer
 
 import (
-	"bytes"
 	"io"
-	"log"
 	"strconv"
 	"strings"
 
@@ -52,72 +50,274 @@ func (p *Tokenizer) addBuiltinMacros() {
 	// builtin EPUB support
 	p.macros["\\epubauthor"] = typedMacro("A")
 	p.macros["\\epubcover"] = typedMacro("A")
+	p.macros["\\epubendgroup"] = macroFunc(parseEndgroup)
+	p.macros["\\epubfalse"] = &defMacro{} // \ifepub cannot be changed
 	p.macros["\\epubmaketitle"] = typedMacro("")
 	p.macros["\\epubsection"] = typedMacro("OA")
 	p.macros["\\epubsubsection"] = typedMacro("OA")
 	p.macros["\\epubtitle"] = typedMacro("A")
+	p.macros["\\epubtrue"] = &defMacro{}
 
 	// TeX/LaTeX macros
 	p.macros["\\ "] = &defMacro{Count: 0, Body: " "}
+	p.macros["\\!"] = typedMacro("")
 	p.macros["\\,"] = typedMacro("")
+	p.macros["\\:"] = typedMacro("")
+	p.macros["\\;"] = typedMacro("")
+	p.macros["\\>"] = typedMacro("")
+	p.macros["\\Big"] = typedMacro("")
+	p.macros["\\Bigl"] = typedMacro("")
+	p.macros["\\Bigr"] = typedMacro("")
+	p.macros["\\Delta"] = typedMacro("")
+	p.macros["\\Gamma"] = typedMacro("")
+	p.macros["\\Lambda"] = typedMacro("")
+	p.macros["\\Leftarrow"] = typedMacro("")
+	p.macros["\\Leftrightarrow"] = typedMacro("")
+	p.macros["\\Omega"] = typedMacro("")
+	p.macros["\\Phi"] = typedMacro("")
+	p.macros["\\Pi"] = typedMacro("")
+	p.macros["\\Pr"] = typedMacro("")
+	p.macros["\\Psi"] = typedMacro("")
+	p.macros["\\Rightarrow"] = typedMacro("")
+	p.macros["\\Sigma"] = typedMacro("")
+	p.macros["\\Theta"] = typedMacro("")
+	p.macros["\\Upsilon"] = typedMacro("")
+	p.macros["\\Vert"] = typedMacro("")
+	p.macros["\\Xi"] = typedMacro("")
 	p.macros["\\\\"] = typedMacro("O")
 	p.macros["\\alpha"] = typedMacro("")
 	p.macros["\\approx"] = typedMacro("")
+	p.macros["\\arccos"] = typedMacro("")
+	p.macros["\\arcsin"] = typedMacro("")
+	p.macros["\\arctan"] = typedMacro("")
+	p.macros["\\arg"] = typedMacro("")
+	p.macros["\\ast"] = typedMacro("")
+	p.macros["\\bar"] = typedMacro("A")
+	p.macros["\\begingroup"] = macroFunc(parseBegingroup)
 	p.macros["\\beta"] = typedMacro("")
 	p.macros["\\bf"] = typedMacro("")
+	p.macros["\\big"] = typedMacro("")
+	p.macros["\\bigcap"] = typedMacro("")
+	p.macros["\\bigcup"] = typedMacro("")
+	p.macros["\\bigg"] = typedMacro("")
 	p.macros["\\bigl"] = typedMacro("")
 	p.macros["\\bigm"] = typedMacro("")
+	p.macros["\\bigoplus"] = typedMacro("")
 	p.macros["\\bigr"] = typedMacro("")
+	p.macros["\\cap"] = typedMacro("")
+	p.macros["\\caption"] = typedMacro("OA")
+	p.macros["\\cdot"] = typedMacro("")
+	p.macros["\\cdots"] = typedMacro("")
+	p.macros["\\centering"] = typedMacro("")
 	p.macros["\\chi"] = typedMacro("")
+	p.macros["\\circ"] = typedMacro("")
+	p.macros["\\clearpage"] = typedMacro("")
+	p.macros["\\cline"] = typedMacro("V")
 	p.macros["\\colon"] = typedMacro("")
+	p.macros["\\columnwidth"] = typedMacro("")
+	p.macros["\\cong"] = typedMacro("")
+	p.macros["\\coprod"] = typedMacro("")
+	p.macros["\\cos"] = typedMacro("")
+	p.macros["\\cosh"] = typedMacro("")
+	p.macros["\\cot"] = typedMacro("")
+	p.macros["\\cup"] = typedMacro("")
+	p.macros["\\ddot"] = typedMacro("A")
+	p.macros["\\ddots"] = typedMacro("")
 	p.macros["\\def"] = macroFunc(parseDef)
+	p.macros["\\deg"] = typedMacro("")
 	p.macros["\\delta"] = typedMacro("")
+	p.macros["\\det"] = typedMacro("")
+	p.macros["\\dim"] = typedMacro("")
+	p.macros["\\displaystyle"] = typedMacro("")
+	p.macros["\\div"] = typedMacro("")
 	p.macros["\\documentclass"] = macroFunc(parseDocumentclass)
-	p.macros["\\end"] = typedMacro("V")
+	p.macros["\\dot"] = typedMacro("A")
+	p.macros["\\dots"] = typedMacro("")
+	p.macros["\\edef"] = macroFunc(parseDef)
+	p.macros["\\ell"] = typedMacro("")
+	p.macros["\\else"] = macroFunc(parseElse)
+	p.macros["\\emptyset"] = typedMacro("")
+	p.macros["\\end"] = macroFunc(parseEnd)
+	p.macros["\\endgroup"] = macroFunc(parseBegingroup)
 	p.macros["\\epsilon"] = typedMacro("")
+	p.macros["\\equiv"] = typedMacro("")
 	p.macros["\\eta"] = typedMacro("")
+	p.macros["\\exists"] = typedMacro("")
+	p.macros["\\exp"] = typedMacro("")
+	p.macros["\\fi"] = macroFunc(parseFi)
+	p.macros["\\forall"] = typedMacro("")
 	p.macros["\\frac"] = typedMacro("AA")
+	p.macros["\\footnote"] = typedMacro("OA")
 	p.macros["\\gamma"] = typedMacro("")
+	p.macros["\\gcd"] = typedMacro("")
+	p.macros["\\gdef"] = macroFunc(parseDef)
+	p.macros["\\ge"] = typedMacro("")
+	p.macros["\\geq"] = typedMacro("")
+	p.macros["\\gg"] = typedMacro("")
+	p.macros["\\global"] = macroFunc(parseGlobal)
+	p.macros["\\hat"] = typedMacro("A")
+	p.macros["\\hbar"] = typedMacro("")
 	p.macros["\\hskip"] = macroFunc(parseHskip)
+	p.macros["\\hline"] = typedMacro("")
+	p.macros["\\ifdefined"] = condMacro(condIfdefined)
+	p.macros["\\ifepub"] = &aliasMacro{
+		Name:  "\\iftrue",
+		Macro: condMacro(condTrue),
+	}
+	p.macros["\\iff"] = typedMacro("")
+	p.macros["\\iffalse"] = condMacro(condFalse)
+	p.macros["\\iftrue"] = condMacro(condTrue)
+	p.macros["\\ifx"] = condMacro(condIfx)
 	p.macros["\\in"] = typedMacro("")
+	p.macros["\\include"] = macroFunc(parseInclude)
+	p.macros["\\includeonly"] = macroFunc(parseIncludeonly)
+	p.macros["\\inf"] = typedMacro("")
 	p.macros["\\infty"] = typedMacro("")
+	p.macros["\\input"] = macroFunc(parseInput)
 	p.macros["\\int"] = typedMacro("")
+	p.macros["\\item"] = typedMacro("O")
 	p.macros["\\iota"] = typedMacro("")
 	p.macros["\\it"] = typedMacro("")
 	p.macros["\\kappa"] = typedMacro("")
+	p.macros["\\ker"] = typedMacro("")
 	p.macros["\\label"] = typedMacro("V")
 	p.macros["\\lambda"] = typedMacro("")
+	p.macros["\\langle"] = typedMacro("")
+	p.macros["\\lceil"] = typedMacro("")
 	p.macros["\\ldots"] = typedMacro("")
+	p.macros["\\le"] = typedMacro("")
+	p.macros["\\left"] = typedMacro("")
+	p.macros["\\leftarrow"] = typedMacro("")
+	p.macros["\\leq"] = typedMacro("")
+	p.macros["\\let"] = macroFunc(parseLet)
+	p.macros["\\lfloor"] = typedMacro("")
+	p.macros["\\lim"] = typedMacro("")
+	p.macros["\\liminf"] = typedMacro("")
+	p.macros["\\limits"] = typedMacro("")
+	p.macros["\\limsup"] = typedMacro("")
+	p.macros["\\linewidth"] = typedMacro("")
+	p.macros["\\listoffigures"] = typedMacro("")
+	p.macros["\\listoftables"] = typedMacro("")
+	p.macros["\\ll"] = typedMacro("")
+	p.macros["\\ln"] = typedMacro("")
+	p.macros["\\lnot"] = typedMacro("")
+	p.macros["\\log"] = typedMacro("")
+	p.macros["\\mapsto"] = typedMacro("")
+	p.macros["\\mathbf"] = typedMacro("A")
 	p.macros["\\mathcal"] = typedMacro("")
+	p.macros["\\mathit"] = typedMacro("A")
+	p.macros["\\mathrm"] = typedMacro("A")
+	p.macros["\\mathsf"] = typedMacro("A")
+	p.macros["\\mathtt"] = typedMacro("A")
+	p.macros["\\max"] = typedMacro("")
 	p.macros["\\mbox"] = typedMacro("A")
+	p.macros["\\mid"] = typedMacro("")
+	p.macros["\\min"] = typedMacro("")
+	p.macros["\\mp"] = typedMacro("")
 	p.macros["\\mu"] = typedMacro("")
+	p.macros["\\multicolumn"] = typedMacro("VVA")
+	p.macros["\\nabla"] = typedMacro("")
+	p.macros["\\ne"] = typedMacro("")
+	p.macros["\\neg"] = typedMacro("")
 	p.macros["\\neq"] = typedMacro("")
+	p.macros["\\newpage"] = typedMacro("")
+	p.macros["\\newcommand"] = macroFunc(parseNewcommand)
+	p.macros["\\newenvironment"] = macroFunc(parseNewenvironment)
+	p.macros["\\newif"] = macroFunc(parseNewif)
+	p.macros["\\ni"] = typedMacro("")
+	p.macros["\\nolimits"] = typedMacro("")
+	p.macros["\\nonumber"] = typedMacro("")
+	p.macros["\\notin"] = typedMacro("")
 	p.macros["\\nu"] = typedMacro("")
+	p.macros["\\oint"] = typedMacro("")
 	p.macros["\\omega"] = typedMacro("")
+	p.macros["\\oplus"] = typedMacro("")
+	p.macros["\\otimes"] = typedMacro("")
+	p.macros["\\overline"] = typedMacro("A")
+	p.macros["\\partial"] = typedMacro("")
+	p.macros["\\perp"] = typedMacro("")
 	p.macros["\\phi"] = typedMacro("")
 	p.macros["\\pi"] = typedMacro("")
-	p.macros["\\pi"] = typedMacro("")
+	p.macros["\\pm"] = typedMacro("")
+	p.macros["\\prime"] = typedMacro("")
+	p.macros["\\prod"] = typedMacro("")
+	p.macros["\\propto"] = typedMacro("")
+	p.macros["\\providecommand"] = macroFunc(parseNewcommand)
 	p.macros["\\psi"] = typedMacro("")
+	p.macros["\\qquad"] = typedMacro("")
+	p.macros["\\quad"] = typedMacro("")
+	p.macros["\\rangle"] = typedMacro("")
+	p.macros["\\rceil"] = typedMacro("")
 	p.macros["\\ref"] = typedMacro("V")
+	p.macros["\\renewcommand"] = macroFunc(parseNewcommand)
+	p.macros["\\renewenvironment"] = macroFunc(parseNewenvironment)
+	p.macros["\\rfloor"] = typedMacro("")
 	p.macros["\\rho"] = typedMacro("")
+	p.macros["\\right"] = typedMacro("")
+	p.macros["\\rightarrow"] = typedMacro("")
+	p.macros["\\sec"] = typedMacro("")
+	p.macros["\\setminus"] = typedMacro("")
 	p.macros["\\sigma"] = typedMacro("")
+	p.macros["\\sim"] = typedMacro("")
+	p.macros["\\simeq"] = typedMacro("")
+	p.macros["\\sin"] = typedMacro("")
+	p.macros["\\sinh"] = typedMacro("")
+	p.macros["\\sqrt"] = typedMacro("OA")
+	p.macros["\\star"] = typedMacro("")
+	p.macros["\\subset"] = typedMacro("")
+	p.macros["\\subseteq"] = typedMacro("")
 	p.macros["\\sum"] = typedMacro("")
+	p.macros["\\sup"] = typedMacro("")
+	p.macros["\\supset"] = typedMacro("")
+	p.macros["\\supseteq"] = typedMacro("")
+	p.macros["\\tabularnewline"] = typedMacro("O")
+	p.macros["\\tan"] = typedMacro("")
+	p.macros["\\tanh"] = typedMacro("")
 	p.macros["\\tau"] = typedMacro("")
 	p.macros["\\textit"] = typedMacro("A")
+	p.macros["\\textrm"] = typedMacro("A")
+	p.macros["\\textstyle"] = typedMacro("")
+	p.macros["\\textwidth"] = typedMacro("")
 	p.macros["\\theta"] = typedMacro("")
+	p.macros["\\tilde"] = typedMacro("A")
 	p.macros["\\times"] = typedMacro("")
 	p.macros["\\to"] = typedMacro("")
+	p.macros["\\underline"] = typedMacro("A")
+	p.macros["\\upsilon"] = typedMacro("")
 	p.macros["\\usepackage"] = macroFunc(parseUsepackage)
 	p.macros["\\varepsilon"] = typedMacro("")
 	p.macros["\\varphi"] = typedMacro("")
+	p.macros["\\varpi"] = typedMacro("")
+	p.macros["\\varrho"] = typedMacro("")
+	p.macros["\\varsigma"] = typedMacro("")
+	p.macros["\\vartheta"] = typedMacro("")
+	p.macros["\\vdots"] = typedMacro("")
+	p.macros["\\vec"] = typedMacro("A")
+	p.macros["\\vee"] = typedMacro("")
 	p.macros["\\verb"] = macroFunc(parseVerb)
+	p.macros["\\vert"] = typedMacro("")
+	p.macros["\\wedge"] = typedMacro("")
+	p.macros["\\widehat"] = typedMacro("A")
+	p.macros["\\widetilde"] = typedMacro("A")
+	p.macros["\\xdef"] = macroFunc(parseDef)
 	p.macros["\\xi"] = typedMacro("")
 	p.macros["\\zeta"] = typedMacro("")
 	p.macros["\\{"] = typedMacro("")
+	p.macros["\\|"] = typedMacro("")
 	p.macros["\\}"] = typedMacro("")
 
+	p.environments["array"] = tableEnv("OV")
+	p.environments["description"] = simpleEnv
 	p.environments["document"] = simpleEnv
+	p.environments["enumerate"] = simpleEnv
 	p.environments["equation"] = simpleEnv
+	p.environments["figure"] = typedEnv("O")
+	p.environments["figure*"] = typedEnv("O")
+	p.environments["itemize"] = simpleEnv
+	p.environments["table"] = typedEnv("O")
+	p.environments["table*"] = typedEnv("O")
+	p.environments["tabular"] = tableEnv("OV")
+	p.environments["tabular*"] = tableEnv("AOV")
 	p.environments["verbatim"] = verbatimEnv("%verbatim%")
 }
 
@@ -146,7 +346,7 @@ func parseDocumentclass(p *Tokenizer, name string) (TokenList, error) {
 		p.macros["\\subsection"] = letMacro("\\epubsubsubsection")
 		p.macros["\\title"] = letMacro("\\epubtitle")
 	default:
-		log.Println("unknown document class", class)
+		p.warn("unknown document class " + class)
 	}
 
 	tok := &Token{
@@ -185,7 +385,7 @@ func parseUsepackage(p *Tokenizer, name string) (TokenList, error) {
 		if load != nil {
 			load(p)
 		} else {
-			log.Printf("unknown usepackage %q", pkg)
+			p.warn("unknown package " + strconv.Quote(pkg))
 		}
 
 		tok := &Token{
@@ -207,45 +407,199 @@ func parseUsepackage(p *Tokenizer, name string) (TokenList, error) {
 	return res, nil
 }
 
-func parseDef(p *Tokenizer, _ string) (TokenList, error) {
+// parseDef implements \def, \gdef, \edef and \xdef.  The body of
+// \edef and \xdef is expanded at the time of definition.
+func parseDef(p *Tokenizer, name string) (TokenList, error) {
+	global := name == "\\gdef" || name == "\\xdef"
+	expand := name == "\\edef" || name == "\\xdef"
+	return p.readDef(global, expand)
+}
+
+func (p *Tokenizer) readDef(global, expand bool) (TokenList, error) {
 	defName, err := p.readMacroName()
 	if err != nil {
 		return nil, err
 	}
 
-	count := 0
-	idx := 1
-	for p.Next() {
-		iStr := "#" + strconv.Itoa(idx)
-		idx++
+	prefix, delims, err := p.readParamText(defName)
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.readBalancedUntil('}')
+	if err != nil {
+		return nil, err
+	}
+	if expand {
+		expanded, err := p.parseArg(body)
+		if err != nil {
+			return nil, err
+		}
+		body = expanded.FormatText()
+	}
 
-		buf, err := p.Peek()
+	p.defineMacro(defName, &defMacro{
+		Count:  len(delims),
+		Prefix: prefix,
+		Delims: delims,
+		Body:   body,
+	}, global)
+	return nil, nil
+}
+
+// parseLet implements \let\a=\b, which gives \a the current meaning
+// of \b.
+func parseLet(p *Tokenizer, _ string) (TokenList, error) {
+	return p.readLet(false)
+}
+
+func (p *Tokenizer) readLet(global bool) (TokenList, error) {
+	defName, err := p.readMacroName()
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.skipWhiteSpace()
+	if err != nil {
+		return nil, err
+	}
+	if !p.Next() {
+		return nil, io.EOF
+	}
+	buf, err := p.Peek()
+	if err != nil {
+		return nil, err
+	}
+	if buf[0] == '=' {
+		p.Skip(1)
+		_, err = p.skipWhiteSpace()
 		if err != nil {
 			return nil, err
 		}
-		if bytes.HasPrefix(buf, []byte(iStr)) {
-			count++
-			p.Skip(len(iStr))
-		} else {
-			break
+	}
+	srcName, err := p.readMacroName()
+	if err != nil {
+		return nil, err
+	}
+
+	var m macro
+	if srcName[0] != '\\' {
+		m = &defMacro{Body: srcName}
+	} else {
+		switch src := p.lookupMacro(srcName).(type) {
+		case nil:
+			p.warn("\\let: " + srcName + " not defined")
+			m = letMacro(srcName)
+		case *defMacro, letMacro, *aliasMacro:
+			m = src
+		default:
+			m = &aliasMacro{Name: srcName, Macro: src}
 		}
 	}
+	p.defineMacro(defName, m, global)
+	return nil, nil
+}
+
+// parseGlobal implements the \global prefix for \def, \edef and \let.
+func parseGlobal(p *Tokenizer, name string) (TokenList, error) {
+	next, err := p.readMacroName()
+	if err != nil {
+		return nil, err
+	}
+	switch next {
+	case "\\def", "\\gdef":
+		return p.readDef(true, false)
+	case "\\edef", "\\xdef":
+		return p.readDef(true, true)
+	case "\\let":
+		return p.readLet(true)
+	}
+	p.warn(name + " ignored before " + next)
+	p.expand([]byte(next+" "), name+" "+next)
+	return nil, nil
+}
+
+// parseBegingroup implements \begingroup and \endgroup.
+func parseBegingroup(p *Tokenizer, name string) (TokenList, error) {
+	if name == "\\begingroup" {
+		p.beginGroup(name)
+	} else {
+		p.endGroup("\\begingroup")
+	}
+	return nil, nil
+}
 
-	body, err := p.readMandatoryArg()
+// parseNewcommand implements \newcommand, \renewcommand and
+// \providecommand, including the starred forms and an optional
+// default value for the first argument.
+func parseNewcommand(p *Tokenizer, name string) (TokenList, error) {
+	_, err := p.readOptionalStar()
+	if err != nil {
+		return nil, err
+	}
+	defName, err := p.readCommandName()
+	if err != nil {
+		return nil, err
+	}
+	m, err := p.readArgSpec(defName)
+	if err != nil {
+		return nil, err
+	}
+	m.Body, err = p.readMandatoryArg()
 	if err != nil {
 		return nil, err
 	}
 
-	// Macro names starting with "\epub" cannot be redefined.
-	if !strings.HasPrefix(defName, "\\epub") {
-		p.macros[defName] = &defMacro{
-			Count: count,
-			Body:  body,
+	exists := p.lookupMacro(defName) != nil
+	switch name {
+	case "\\newcommand":
+		if exists {
+			// Like LaTeX after the error, keep the old definition.
+			p.warn(name + ": " + defName + " already defined")
+			return nil, nil
+		}
+	case "\\renewcommand":
+		if !exists {
+			p.warn(name + ": " + defName + " not defined")
+		}
+	case "\\providecommand":
+		if exists {
+			return nil, nil
 		}
 	}
+
+	p.defineMacro(defName, m, false)
 	return nil, nil
 }
 
+// readArgSpec reads the optional number of arguments and the optional
+// default value for the first argument, as used by \newcommand and
+// \newenvironment.  The body of the returned macro is not yet set.
+func (p *Tokenizer) readArgSpec(defName string) (*defMacro, error) {
+	countStr, err := p.readOptionalArg()
+	if err != nil {
+		return nil, err
+	}
+	count := 0
+	if countStr != "" {
+		count, err = strconv.Atoi(strings.TrimSpace(countStr))
+		if err != nil || count < 0 || count > 9 {
+			return nil, p.MakeError("invalid number of arguments for " + defName)
+		}
+	}
+	defaultArg, hasDefault, err := p.tryOptionalArg()
+	if err != nil {
+		return nil, err
+	}
+	if hasDefault && count == 0 {
+		return nil, p.MakeError("default argument for " + defName +
+			" without arguments")
+	}
+	return &defMacro{
+		Count:      count,
+		HasDefault: hasDefault,
+		Default:    defaultArg,
+	}, nil
+}
+
 func parseHskip(p *Tokenizer, name string) (TokenList, error) {
 	amount, err := p.readNumber()
 	if err != nil {
@@ -302,65 +656,110 @@ func parseVerb(p *Tokenizer, name string) (TokenList, error) {
 type letMacro string
 
 func (m letMacro) ReadArgs(p *Tokenizer, name string) (TokenList, error) {
-	p.Prepend([]byte(m), name+" -> "+string(m))
+	p.expand([]byte(m), name+" -> "+string(m))
 	return nil, nil
 }
 
+// aliasMacro is the meaning given to a macro by \let, if the
+// original macro is not user-defined.  The resulting tokens keep the
+// name of the original macro, so that the converter recognises them.
+type aliasMacro struct {
+	Name  string
+	Macro macro
+}
+
+func (am *aliasMacro) ReadArgs(p *Tokenizer, _ string) (TokenList, error) {
+	return am.Macro.ReadArgs(p, am.Name)
+}
+
+// defMacro is a user-defined macro.  If HasDefault is set, the first
+// argument is optional and Default is used when it is omitted.  For
+// macros defined using \def, Prefix is the text which must follow the
+// macro name and Delims[i] is the delimiter which ends argument i+1,
+// or the empty string for undelimited arguments.
 type defMacro struct {
-	Count int
-	Body  string
+	Count      int
+	HasDefault bool
+	Default    string
+	Prefix     string
+	Delims     []string
+	Body       string
+}
+
+func (dm *defMacro) equal(other *defMacro) bool {
+	if dm.Count != other.Count ||
+		dm.HasDefault != other.HasDefault ||
+		dm.Default != other.Default ||
+		dm.Prefix != other.Prefix ||
+		dm.Body != other.Body ||
+		len(dm.Delims) != len(other.Delims) {
+		return false
+	}
+	for i, delim := range dm.Delims {
+		if other.Delims[i] != delim {
+			return false
+		}
+	}
+	return true
 }
 
 func (dm *defMacro) ReadArgs(p *Tokenizer, name string) (TokenList, error) {
+	if dm.Prefix != "" {
+		err := p.matchPrefix(name, dm.Prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
 	args := make([]string, dm.Count)
 	for i := range args {
-		arg, err := p.readMandatoryArg()
+		var arg string
+		var err error
+		if i < len(dm.Delims) && dm.Delims[i] != "" {
+			arg, err = p.readDelimitedArg(name, dm.Delims[i])
+		} else if i == 0 && dm.HasDefault {
+			var present bool
+			arg, present, err = p.tryOptionalArg()
+			if !present {
+				arg = dm.Default
+			}
+		} else {
+			arg, err = p.readUndelimitedArg()
+		}
 		if err != nil {
 			return nil, err
 		}
 		args[i] = arg
 	}
 	out := substituteMacroArgs(dm.Body, args)
-	p.Prepend([]byte(out), name+" macro body")
+	p.expand([]byte(out), name+" macro body")
 	return nil, nil
 }
 
 func substituteMacroArgs(body string, args []string) string {
-	var parts []string
-
-	partStart := 0
-	numStart := -1
-	hashSeen := false
+	var res []byte
 	for pos := 0; pos < len(body); pos++ {
 		c := body[pos]
-
-		if numStart >= 0 {
-			if isDigit(c) {
-				continue
-			}
-
-			num, err := strconv.Atoi(body[numStart:pos])
-			if err == nil && num > 0 && num <= len(args) {
-				parts = append(parts, args[num-1])
-			}
-			partStart = pos
-			numStart = -1
+		if c != '#' || pos+1 >= len(body) {
+			res = append(res, c)
+			continue
 		}
 
+		next := body[pos+1]
 		switch {
-		case hashSeen && isDigit(c):
-			numStart = pos
-			hashSeen = false
-		case c == '#' && !hashSeen:
-			parts = append(parts, body[partStart:pos])
-			partStart = pos + 1
-			hashSeen = true
+		case next == '#':
+			res = append(res, '#')
+			pos++
+		case isDigit(next):
+			num := int(next - '0')
+			if num > 0 && num <= len(args) {
+				res = append(res, args[num-1]...)
+			}
+			pos++
 		default:
-			hashSeen = false
+			res = append(res, c)
 		}
 	}
-	parts = append(parts, body[partStart:])
-	return strings.Join(parts, "")
+	return string(res)
 }
 
 type typedMacro string
@@ -374,13 +773,21 @@ func (tm typedMacro) ReadArgs(p *Tokenizer, name string) (TokenList, error) {
 			if err != nil {
 				return nil, err
 			}
-			args = append(args, &Arg{Optional: false, Value: parseString(arg)})
+			val, err := p.parseArg(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, &Arg{Optional: false, Value: val})
 		case 'O':
 			arg, err := p.readOptionalArg()
 			if err != nil {
 				return nil, err
 			}
-			args = append(args, &Arg{Optional: true, Value: parseString(arg)})
+			val, err := p.parseArg(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, &Arg{Optional: true, Value: val})
 		case 'V':
 			arg, err := p.readMandatoryArg()
 			if err != nil {
@@ -454,37 +861,76 @@ func (p *Tokenizer) readMandatoryArg() (string, error) {
 }
 
 func (p *Tokenizer) readOptionalArg() (string, error) {
+	arg, _, err := p.tryOptionalArg()
+	return arg, err
+}
+
+// tryOptionalArg is like readOptionalArg, but additionally reports
+// whether the optional argument was present.
+func (p *Tokenizer) tryOptionalArg() (string, bool, error) {
 	if !p.Next() {
-		return "", nil
+		return "", false, nil
 	}
 	buf, err := p.Peek()
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 	space := isSpace(buf[0])
 	if space {
 		_, err = p.skipWhiteSpace()
 		if err != nil {
-			return "", err
+			return "", false, err
 		}
 	}
 
 	if !p.Next() {
-		return "", nil
+		return "", false, nil
 	}
 	buf, err = p.Peek()
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
 	if buf[0] != '[' {
 		if space {
-			p.Prepend([]byte{' '}, "space after macro")
+			p.expand([]byte{' '}, "space after macro")
 		}
-		return "", nil
+		return "", false, nil
+	}
+
+	p.Skip(1)
+	arg, err := p.readBalancedUntil(']')
+	return arg, err == nil, err
+}
+
+// readCommandName reads the name of the macro defined by \newcommand
+// and similar macros.  The name can be given either as `\name` or as
+// `{\name}`.
+func (p *Tokenizer) readCommandName() (string, error) {
+	_, err := p.skipWhiteSpace()
+	if err != nil {
+		return "", err
+	}
+	if !p.Next() {
+		return "", io.EOF
+	}
+	buf, err := p.Peek()
+	if err != nil {
+		return "", err
+	}
+	if buf[0] != '{' {
+		return p.readMacroName()
 	}
 
 	p.Skip(1)
-	return p.readBalancedUntil(']')
+	name, err := p.readBalancedUntil('}')
+	if err != nil {
+		return "", err
+	}
+	name = strings.TrimSpace(name)
+	if len(name) < 2 || name[0] != '\\' {
+		return "", p.MakeError("invalid macro name " + name)
+	}
+	return name, nil
 }
 
 func (p *Tokenizer) readOptionalStar() (TokenList, error) {
@@ -519,14 +965,22 @@ loop:
 			if err != nil {
 				return nil, err
 			}
-			args = append(args, &Arg{Optional: false, Value: parseString(arg)})
+			val, err := p.parseArg(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, &Arg{Optional: false, Value: val})
 		case '[':
 			p.Skip(1)
 			arg, err := p.readBalancedUntil(']')
 			if err != nil {
 				return nil, err
 			}
-			args = append(args, &Arg{Optional: true, Value: parseString(arg)})
+			val, err := p.parseArg(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, &Arg{Optional: true, Value: val})
 		case '%':
 			_, err := p.readComment()
 			if err != nil {
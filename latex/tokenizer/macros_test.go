@@ -18,6 +18,11 @@ package tokenizer
 
 import (
 	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -136,6 +141,8 @@ func TestSubstituteMacroArgs(t *testing.T) {
 		{" abc ", nil, " abc "},
 		{"xxx#1zzz", []string{"yyy"}, "xxxyyyzzz"},
 		{"#1#2#3###5", []string{"1", "2", "3", "4", "5"}, "123#5"},
+		{"(#2,#1)", []string{"a", "b"}, "(b,a)"},
+		{"#12", []string{"a"}, "a2"},
 	}
 
 	for i, testCase := range testCases {
@@ -215,3 +222,252 @@ func TestParseVerb(t *testing.T) {
 		}
 	}
 }
+
+func TestNewcommand(t *testing.T) {
+	testCases := []struct{ in, out string }{
+		{`\newcommand{\hello}{world}\hello`, "world"},
+		{`\newcommand\hello{world}\hello`, "world"},
+		{`\newcommand*{\hello}{world}\hello`, "world"},
+		{`\newcommand{\pair}[2]{(#1,#2)}\pair{a}{b}`, "(a,b)"},
+		{`\newcommand{\norm}[2][2]{|#2|_#1}\norm{x} \norm[1]{y}`,
+			"|x|_2 |y|_1"},
+		{`\newcommand{\x}{a}\renewcommand{\x}{b}\x`, "b"},
+		{`\newcommand{\x}{a}\providecommand{\x}{b}\x`, "a"},
+		{`\providecommand{\x}{b}\x`, "b"},
+	}
+	for i, testCase := range testCases {
+		out := parseString(testCase.in).FormatText()
+		if out != testCase.out {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.out, out)
+		}
+	}
+}
+
+func TestNewcommandRedefine(t *testing.T) {
+	testCases := []struct{ in, out, warning string }{
+		{"\\newcommand{\\x}{a}%\n\\newcommand{\\x}{b}\\x", "a",
+			`main.tex:2:1: \newcommand: \x already defined`},
+		{"\\newenvironment{x}{<}{>}%\n" +
+			"\\newenvironment{x}{(}{)}\\begin{x}y\\end{x}",
+			"<y>", `main.tex:2:1: \newenvironment: x already defined`},
+	}
+
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+	for i, test := range testCases {
+		buf.Reset()
+		toks, err := tokenizeFiles(t, map[string]string{"main.tex": test.in})
+		if err != nil {
+			t.Errorf("test %d: unexpected error %s", i, err)
+			continue
+		}
+		out := toks.FormatText()
+		if out != test.out {
+			t.Errorf("test %d: expected %q, got %q", i, test.out, out)
+		}
+		if !strings.Contains(buf.String(), test.warning) {
+			t.Errorf("test %d: warning %q missing from %q",
+				i, test.warning, buf.String())
+		}
+	}
+}
+
+func TestNewenvironment(t *testing.T) {
+	testCases := []struct{ in, out string }{
+		{`\newenvironment{x}{<}{>}\begin{x}y\end{x}`, "<y>"},
+		{`\newenvironment{x}[2]{<#2#1}{>}\begin{x}{a}{b}c\end{x}`, "<bac>"},
+		{`\newenvironment{x}[1][a]{<#1}{>}\begin{x}b\end{x}`, "<ab>"},
+		{`\newenvironment{x}[1][a]{<#1}{>}\begin{x}[c]b\end{x}`, "<cb>"},
+		{`\newenvironment{x}{<}{>}\renewenvironment{x}{(}{)}\begin{x}y\end{x}`,
+			"(y)"},
+		{`\newenvironment{x}{<}{>}\newenvironment{y}{\begin{x}}{\end{x}}` +
+			`\begin{y}z\end{y}`, "<z>"},
+	}
+	for i, testCase := range testCases {
+		out := parseString(testCase.in).FormatText()
+		if out != testCase.out {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.out, out)
+		}
+	}
+}
+
+func TestDelimitedParameters(t *testing.T) {
+	testCases := []struct{ in, out string }{
+		{`\def\abs|#1|{<#1>}\abs|x+y|`, "<x+y>"},
+		{`\def\pair(#1,#2){[#2;#1]}\pair(a,b)`, "[b;a]"},
+		{`\def\pair(#1,#2){[#2;#1]}\pair({a,b},c)`, "[c;a,b]"},
+		{`\def\pair(#1,#2){[#2;#1]}\pair({a}{b},c)`, "[c;{a}{b}]"},
+		{`\def\x#1.{<#1>}\x abc.d`, "<abc>d"},
+		{`\def\x#1#2.{<#1|#2>}\x abc.`, "<a|bc>"},
+		{`\def\x#1\stop{<#1>}\x a\stopper\stop b`, "<a\\stopper>b"},
+		{`\def\x#1 #2 {<#1|#2>}\x a  b c`, "<a|b>c"},
+		{`\def\x#1{<#1>}\x\alpha`, "<\\alpha>"},
+		{`\def\x#1|{<#1>}\x\||`, "<\\|>"},
+	}
+	for i, testCase := range testCases {
+		out := parseString(testCase.in).FormatText()
+		if out != testCase.out {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.out, out)
+		}
+	}
+}
+
+func TestDelimitedParametersLong(t *testing.T) {
+	// The delimiters are longer than the buffer used to read the
+	// input file, so they always cross the end of the look-ahead
+	// buffer.
+	long := strings.Repeat("a", 3000)
+	bars := strings.Repeat("|", 3000)
+	spaces := strings.Repeat(" ", 3000)
+	testCases := []struct{ in, out string }{
+		{`\def\x#1` + bars + `{<#1>}\x ` + long + bars + "b", "<" + long + ">b"},
+		{`\def\x#1 #2 {<#1|#2>}\x ` + long + spaces + "b c", "<" + long + "|b>c"},
+		{`\def\x#1\stop{<#1>}\x ` + long + `\stop` + spaces + "b", "<" + long + ">b"},
+	}
+	for i, testCase := range testCases {
+		toks, err := tokenizeFiles(t, map[string]string{
+			"main.tex": testCase.in,
+		})
+		if err != nil {
+			t.Errorf("test %d: %s", i, err)
+			continue
+		}
+		out := toks.FormatText()
+		if out != testCase.out {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.out, out)
+		}
+	}
+}
+
+func TestDelimitedParametersMismatch(t *testing.T) {
+	_, err := NewTokenizer().tokenizeString(`\def\pair(#1,#2){}\pair[a,b]`)
+	if err == nil {
+		t.Error("mismatched macro use not detected")
+	}
+}
+
+func TestTokenPos(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "tokenizer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	err = ioutil.WriteFile(filepath.Join(tmp, "a.tex"),
+		[]byte("\\def\\x{a b}\nc \\x\n\\textit{d}"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewTokenizer()
+	defer p.Close()
+	p.BaseDir = tmp
+	err = p.Include("a.tex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := make(chan *Token, 64)
+	err = p.ParseTex(c)
+	close(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"a.tex:1:12", // newline
+		"a.tex:2:1",  // c
+		"a.tex:2:2",  // space
+		"a.tex:2:3",  // a from \x
+		"a.tex:2:3",  // space from \x
+		"a.tex:2:3",  // b from \x
+		"a.tex:3:1",  // \textit
+	}
+	var toks TokenList
+	for tok := range c {
+		toks = append(toks, tok)
+	}
+	if len(toks) != len(expected) {
+		t.Fatalf("expected %d tokens, got %s", len(expected), toks)
+	}
+	for i, tok := range toks {
+		if tok.Pos.String() != expected[i] {
+			t.Errorf("token %d %s: expected %s, got %s",
+				i, tok, expected[i], tok.Pos)
+		}
+	}
+	if pos := toks[6].Args[0].Value[0].Pos.String(); pos != "a.tex:3:1" {
+		t.Errorf("wrong argument position %s", pos)
+	}
+}
+
+func TestSource(t *testing.T) {
+	testCases := []string{
+		"hello  world\n\n\n  again",
+		"a % comment\n b",
+		`\def\x#1{<#1>}\x{a}  \x b`,
+		`\newcommand{\x}[1][a]{#1}\x \x[b]`,
+		`\section [short]{long \emph{title}}`,
+		"\\begin{verbatim}\n  \\x \n\\end{verbatim}",
+		`$\frac 12 + x^{2}$`,
+		`\newenvironment{e}{<}{>}\begin{e}x\end{e}`,
+		`text\def\x{y}`,
+	}
+	for i, in := range testCases {
+		out := parseString(in).Source()
+		if out != in {
+			t.Errorf("test %d: expected %q, got %q", i, in, out)
+		}
+	}
+
+	toks := parseString(`\textit{a  \x b}`)
+	if src := toks[0].Args[0].Value.Source(); src != `a  \x b` {
+		t.Errorf("wrong argument source %q", src)
+	}
+}
+
+func TestHasExpansions(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expanded bool
+	}{
+		{`$\frac 12 + x^{2}$`, false},
+		{"$x % comment\ny$", false},
+		{`\def\R{R}$x+1$`, false},
+		{`\def\R{R}$\R+1$`, true},
+		{`\newcommand\R{R}$\frac{\R}{2}$`, true},
+		{`\newif\ifa $\ifa a\else b\fi$`, true},
+	}
+	for i, testCase := range testCases {
+		toks := parseString(testCase.in)
+		var formula TokenList
+		inMath := false
+		for _, tok := range toks {
+			if tok.Type == TokenOther && tok.Name == "$" {
+				inMath = !inMath
+			} else if inMath {
+				formula = append(formula, tok)
+			}
+		}
+		if formula.HasExpansions() != testCase.expanded {
+			t.Errorf("test %d: expected %t, got %t",
+				i, testCase.expanded, !testCase.expanded)
+		}
+	}
+}
+
+func TestTableEnv(t *testing.T) {
+	tokens := parseString(`\begin{tabular}{l|r}a & b\\ \hline c & d\end{tabular}`)
+	if len(tokens) != 1 || !isMacro(tokens[0], "%tabular%", "tabular", "", "l|r") {
+		t.Fatalf("tabular environment not collected: %v", tokens)
+	}
+	body := tokens[0].Args[3].Value.FormatText()
+	if body != `a & b\\ \hline c & d` {
+		t.Errorf("wrong table body %q", body)
+	}
+
+	tokens = parseString(`\begin{array}{cc}x & y\\ z & w\end{array}`)
+	maths := tokens.FormatMaths()
+	if maths != `\begin{array}{cc}x&y\\z&w\end{array}` {
+		t.Errorf("wrong array formatting %q", maths)
+	}
+}
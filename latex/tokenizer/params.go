@@ -0,0 +1,306 @@
+// params.go - delimited parameters of macros defined by \def
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+
+	"github.com/seehuhn/epublatex/latex/scanner"
+)
+
+// readParamText reads the parameter text of a \def, i.e. everything
+// between the macro name and the opening brace of the body.  The
+// returned prefix is the text which must follow the macro name, and
+// delims[i] is the text which delimits argument i+1.  Undelimited
+// arguments have an empty delimiter.
+func (p *Tokenizer) readParamText(defName string) (string, []string, error) {
+	text, err := p.readBalancedUntil('{')
+	if err != nil {
+		return "", nil, err
+	}
+
+	var prefix string
+	var delims []string
+	start := 0
+	for pos := 0; pos < len(text); pos++ {
+		switch text[pos] {
+		case '\\':
+			pos++
+		case '#':
+			if pos+1 >= len(text) || text[pos+1] != byte('1'+len(delims)) {
+				return "", nil, p.MakeError("parameters of " + defName +
+					" must be numbered consecutively")
+			}
+			seg := normaliseDelim(text[start:pos])
+			if delims == nil {
+				prefix = seg
+			} else {
+				delims[len(delims)-1] = seg
+			}
+			delims = append(delims, "")
+			pos++
+			start = pos + 1
+		}
+	}
+	if len(delims) > 9 {
+		return "", nil, p.MakeError("too many parameters for " + defName)
+	}
+	seg := normaliseDelim(text[start:])
+	if delims == nil {
+		prefix = seg
+	} else {
+		delims[len(delims)-1] = seg
+	}
+	return prefix, delims, nil
+}
+
+// normaliseDelim replaces every run of white space in a delimiter by
+// a single space, and removes white space after control words.  This
+// corresponds to the way TeX turns the parameter text into tokens.
+func normaliseDelim(text string) string {
+	var res []byte
+	afterWord := false
+	for pos := 0; pos < len(text); pos++ {
+		c := text[pos]
+		switch {
+		case isSpace(c):
+			if !afterWord && (len(res) == 0 || res[len(res)-1] != ' ') {
+				res = append(res, ' ')
+			}
+		case c == '\\':
+			n := controlSequenceLength([]byte(text[pos:]))
+			res = append(res, text[pos:pos+n]...)
+			afterWord = n > 2 || n == 2 && isLetter(text[pos+1])
+			pos += n - 1
+			continue
+		default:
+			res = append(res, c)
+		}
+		if !isSpace(c) {
+			afterWord = false
+		}
+	}
+	return string(res)
+}
+
+// controlSequenceLength returns the length of the control sequence at
+// the start of buf.
+func controlSequenceLength(buf []byte) int {
+	if len(buf) < 2 {
+		return len(buf)
+	}
+	if !isLetter(buf[1]) {
+		return 2
+	}
+	n := 2
+	for n < len(buf) && isLetter(buf[n]) {
+		n++
+	}
+	return n
+}
+
+// matchDelim checks whether buf starts with the delimiter `delim`.  A
+// space in the delimiter matches any non-empty run of white space,
+// and white space after a control word in buf is ignored.  If the
+// delimiter matches, the number of bytes matched is returned.  If
+// the end of buf is reached before a decision can be made, short is
+// set and the caller should retry with a longer buffer.
+func matchDelim(buf []byte, delim string) (n int, ok bool, short bool) {
+	pos := 0
+	for i := 0; i < len(delim); {
+		c := delim[i]
+		switch {
+		case c == ' ':
+			if pos >= len(buf) {
+				return 0, false, true
+			}
+			if !isSpace(buf[pos]) {
+				return 0, false, false
+			}
+			for pos < len(buf) && isSpace(buf[pos]) {
+				pos++
+			}
+			i++
+		case c == '\\':
+			n := controlSequenceLength([]byte(delim[i:]))
+			if len(buf)-pos < n {
+				return 0, false, true
+			}
+			if !bytes.HasPrefix(buf[pos:], []byte(delim[i:i+n])) {
+				return 0, false, false
+			}
+			pos += n
+			if n > 2 || n == 2 && isLetter(delim[i+1]) {
+				if pos >= len(buf) {
+					return 0, false, true
+				}
+				if isLetter(buf[pos]) {
+					return 0, false, false
+				}
+				for pos < len(buf) && isSpace(buf[pos]) {
+					pos++
+				}
+			}
+			i += n
+		default:
+			if pos >= len(buf) {
+				return 0, false, true
+			}
+			if buf[pos] != c {
+				return 0, false, false
+			}
+			pos++
+			i++
+		}
+	}
+	// White space matched at the end of the buffer may continue
+	// beyond the buffer.
+	if pos == len(buf) && pos > 0 && isSpace(buf[pos-1]) {
+		return pos, true, true
+	}
+	return pos, true, false
+}
+
+// peekDelim checks whether the input starts with the delimiter
+// `delim`, using a look-ahead buffer which is long enough to contain
+// the complete delimiter.  If the delimiter matches, the number of
+// bytes matched is returned.  The input position is not changed.
+func (p *Tokenizer) peekDelim(delim string) (int, bool, error) {
+	size := scanner.PeekWindowSize
+	for size < 2*len(delim) {
+		size *= 2
+	}
+	for {
+		if !p.NextWindow(size) {
+			return 0, false, io.EOF
+		}
+		buf, err := p.Peek()
+		if err != nil {
+			return 0, false, err
+		}
+		n, ok, short := matchDelim(buf, delim)
+		if !short || len(buf) < size {
+			// Either a decision was made, or all remaining
+			// input is visible in buf.
+			return n, ok, nil
+		}
+		size *= 2
+	}
+}
+
+// matchPrefix skips the text which must follow the name of a macro
+// with the given prefix.
+func (p *Tokenizer) matchPrefix(name, prefix string) error {
+	n, ok, err := p.peekDelim(prefix)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return p.MakeError("use of " + name + " does not match its definition")
+	}
+	p.Skip(n)
+	return nil
+}
+
+// readDelimitedArg reads a macro argument which extends up to the
+// first occurrence of `delim` outside braces.  If the argument
+// consists of a single group, the enclosing braces are removed.
+func (p *Tokenizer) readDelimitedArg(name, delim string) (string, error) {
+	var res []byte
+	level := 0
+	for p.Next() {
+		if level == 0 {
+			n, ok, err := p.peekDelim(delim)
+			if err != nil {
+				return "", err
+			}
+			if ok {
+				p.Skip(n)
+				return stripBraces(string(res)), nil
+			}
+		}
+		buf, err := p.Peek()
+		if err != nil {
+			return "", err
+		}
+
+		n := 1
+		switch buf[0] {
+		case '{':
+			level++
+		case '}':
+			level--
+			if level < 0 {
+				return "", p.MakeError("argument of " + name +
+					" has an extra }")
+			}
+		case '\\':
+			n = controlSequenceLength(buf)
+		}
+		res = append(res, buf[:n]...)
+		p.Skip(n)
+	}
+	return "", p.MakeError("missing delimiter " + strconv.Quote(delim) +
+		" for argument of " + name)
+}
+
+// readUndelimitedArg reads an undelimited argument of a user-defined
+// macro.  Unlike readMandatoryArg, a control sequence is read as a
+// whole, the way TeX does it.
+func (p *Tokenizer) readUndelimitedArg() (string, error) {
+	_, err := p.skipWhiteSpace()
+	if err != nil {
+		return "", err
+	}
+	if !p.Next() {
+		return "", io.EOF
+	}
+	buf, err := p.Peek()
+	if err != nil {
+		return "", err
+	}
+	if buf[0] == '\\' {
+		return p.readMacroName()
+	}
+	return p.readMandatoryArg()
+}
+
+// stripBraces removes the braces around an argument which consists of
+// a single group.
+func stripBraces(arg string) string {
+	n := len(arg)
+	if n < 2 || arg[0] != '{' || arg[n-1] != '}' {
+		return arg
+	}
+	level := 0
+	for pos := 0; pos < n-1; pos++ {
+		switch arg[pos] {
+		case '\\':
+			pos++
+		case '{':
+			level++
+		case '}':
+			level--
+			if level == 0 {
+				return arg
+			}
+		}
+	}
+	return arg[1 : n-1]
+}
@@ -18,6 +18,7 @@ package tokenizer
 
 func addAmsfontsMacros(p *Tokenizer) {
 	p.macros["\\mathbb"] = typedMacro("A")
+	p.macros["\\mathfrak"] = typedMacro("A")
 }
 
 func init() {
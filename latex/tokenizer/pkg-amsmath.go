@@ -18,7 +18,20 @@ package tokenizer
 
 func addAmsmathMacros(p *Tokenizer) {
 	p.macros["\\DeclareMathOperator"] = macroFunc(amsmathDMO)
+	p.macros["\\binom"] = typedMacro("AA")
+	p.macros["\\boldsymbol"] = typedMacro("A")
+	p.macros["\\dfrac"] = typedMacro("AA")
 	p.macros["\\eqref"] = &defMacro{Count: 1, Body: "(\\ref{#1})"}
+	p.macros["\\iint"] = typedMacro("")
+	p.macros["\\implies"] = typedMacro("")
+	p.macros["\\lVert"] = typedMacro("")
+	p.macros["\\lvert"] = typedMacro("")
+	p.macros["\\notag"] = typedMacro("")
+	p.macros["\\operatorname"] = typedMacro("A")
+	p.macros["\\rVert"] = typedMacro("")
+	p.macros["\\rvert"] = typedMacro("")
+	p.macros["\\text"] = typedMacro("A")
+	p.macros["\\tfrac"] = typedMacro("AA")
 
 	p.environments["align"] = simpleEnv
 	p.environments["align*"] = simpleEnv
@@ -19,6 +19,8 @@ package tokenizer
 func addAmsthmMacros(p *Tokenizer) {
 	p.macros["\\newtheorem"] = macroFunc(parseNewtheorem)
 	p.macros["\\theoremstyle"] = typedMacro("V")
+
+	p.environments["proof"] = typedEnv("O")
 }
 
 func parseNewtheorem(p *Tokenizer, name string) (TokenList, error) {
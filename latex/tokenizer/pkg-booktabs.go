@@ -0,0 +1,93 @@
+// pkg-booktabs.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+func addBooktabsMacros(p *Tokenizer) {
+	p.macros["\\addlinespace"] = typedMacro("O")
+	p.macros["\\bottomrule"] = typedMacro("O")
+	p.macros["\\cmidrule"] = macroFunc(parseCmidrule)
+	p.macros["\\midrule"] = typedMacro("O")
+	p.macros["\\toprule"] = typedMacro("O")
+}
+
+// parseCmidrule reads the arguments of \cmidrule[wd](trim){a-b}.  The
+// trim specification is ignored, the resulting token has the optional
+// width and the range of columns as its arguments.
+func parseCmidrule(p *Tokenizer, name string) (TokenList, error) {
+	width, err := p.readOptionalArg()
+	if err != nil {
+		return nil, err
+	}
+	err = p.skipTrimSpec()
+	if err != nil {
+		return nil, err
+	}
+	cols, err := p.readMandatoryArg()
+	if err != nil {
+		return nil, err
+	}
+
+	tok := &Token{
+		Type: TokenMacro,
+		Name: name,
+		Args: []*Arg{
+			&Arg{
+				Optional: true,
+				Value:    TokenList{verbatim(width)},
+			},
+			&Arg{
+				Optional: false,
+				Value:    TokenList{verbatim(cols)},
+			},
+		},
+	}
+	return TokenList{tok}, nil
+}
+
+// skipTrimSpec skips an optional trim specification like "(lr)".
+func (p *Tokenizer) skipTrimSpec() error {
+	if !p.Next() {
+		return nil
+	}
+	buf, err := p.Peek()
+	if err != nil {
+		return err
+	}
+	if isSpace(buf[0]) {
+		_, err = p.skipWhiteSpace()
+		if err != nil {
+			return err
+		}
+		if !p.Next() {
+			return nil
+		}
+		buf, err = p.Peek()
+		if err != nil {
+			return err
+		}
+	}
+	if buf[0] != '(' {
+		return nil
+	}
+	p.Skip(1)
+	_, err = p.readUntilChar(')')
+	return err
+}
+
+func init() {
+	addPackage("booktabs", addBooktabsMacros)
+}
@@ -17,6 +17,7 @@
 package tokenizer
 
 func addGraphicxMacros(p *Tokenizer) {
+	p.macros["\\graphicspath"] = typedMacro("V")
 	p.macros["\\includegraphics"] = typedMacro("OA")
 }
 
@@ -17,6 +17,9 @@
 package tokenizer
 
 func addTikzMacros(p *Tokenizer) {
+	p.macros["\\tikzset"] = typedMacro("V")
+	p.macros["\\usetikzlibrary"] = typedMacro("V")
+
 	p.environments["tikzpicture"] = collectEnv("%tikz%")
 }
 
@@ -34,6 +34,10 @@ Hello,
 	for _, tok := range tokens {
 		if isMacro(tok, "%tikz%") {
 			seen = true
+			raw := "\\begin{tikzpicture}\n  \\draw (0,0) -- (1,1);\n\\end{tikzpicture}"
+			if tok.Raw != raw {
+				t.Errorf("wrong source for tikz picture: %q", tok.Raw)
+			}
 		}
 		if isMacro(tok, "\\draw") {
 			t.Error("tikzpicture environment didn't capture it's contents")
@@ -0,0 +1,114 @@
+// scope.go - local and global definitions of macros and environments
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+import "strings"
+
+// definitions holds the macros and environments known to a
+// Tokenizer.  The maps contain the global definitions, local
+// definitions are stored in the stack of open groups.  The
+// definitions are shared between a Tokenizer and the child
+// tokenizers used to parse macro arguments.
+type definitions struct {
+	macros       map[string]macro
+	environments map[string]environment
+	scopes       []*scope
+}
+
+// scope holds the definitions made inside a group.  The name is "{"
+// for groups delimited by braces, "\begingroup" for groups started by
+// \begingroup, and the name of the environment otherwise.
+type scope struct {
+	name         string
+	macros       map[string]macro
+	environments map[string]environment
+}
+
+func (p *Tokenizer) beginGroup(name string) {
+	p.scopes = append(p.scopes, &scope{name: name})
+}
+
+func (p *Tokenizer) endGroup(name string) {
+	n := len(p.scopes)
+	if n == 0 {
+		p.warn("unmatched end of group " + name)
+		return
+	}
+	if open := p.scopes[n-1].name; open != name {
+		p.warn("group " + open + " ended by " + name)
+	}
+	p.scopes = p.scopes[:n-1]
+}
+
+func (p *Tokenizer) lookupMacro(name string) macro {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if m, ok := p.scopes[i].macros[name]; ok {
+			return m
+		}
+	}
+	return p.macros[name]
+}
+
+func (p *Tokenizer) lookupEnvironment(name string) environment {
+	for i := len(p.scopes) - 1; i >= 0; i-- {
+		if env, ok := p.scopes[i].environments[name]; ok {
+			return env
+		}
+	}
+	return p.environments[name]
+}
+
+// defineMacro sets the meaning of the macro `name`.  Local
+// definitions are discarded at the end of the current group.  Macro
+// names starting with "\epub", as well as \ifepub, cannot be
+// redefined.
+func (p *Tokenizer) defineMacro(name string, m macro, global bool) {
+	if strings.HasPrefix(name, "\\epub") || name == "\\ifepub" {
+		return
+	}
+	n := len(p.scopes)
+	if global || n == 0 {
+		p.macros[name] = m
+		for _, s := range p.scopes {
+			delete(s.macros, name)
+		}
+		return
+	}
+	top := p.scopes[n-1]
+	if top.macros == nil {
+		top.macros = make(map[string]macro)
+	}
+	top.macros[name] = m
+}
+
+// defineEnvironment sets the meaning of the environment `name`.
+// Local definitions are discarded at the end of the current group.
+func (p *Tokenizer) defineEnvironment(name string, env environment, global bool) {
+	n := len(p.scopes)
+	if global || n == 0 {
+		p.environments[name] = env
+		for _, s := range p.scopes {
+			delete(s.environments, name)
+		}
+		return
+	}
+	top := p.scopes[n-1]
+	if top.environments == nil {
+		top.environments = make(map[string]environment)
+	}
+	top.environments[name] = env
+}
@@ -0,0 +1,51 @@
+// scope_test.go -
+// Copyright (C) 2016  Jochen Voss <voss@seehuhn.de>
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+
+package tokenizer
+
+import "testing"
+
+func TestScopes(t *testing.T) {
+	testCases := []struct{ in, out string }{
+		{`\def\x{a}{\def\x{b}\x}\x`, "{b}a"},
+		{`\def\x{a}{\gdef\x{b}\x}\x`, "{b}b"},
+		{`\def\x{a}{\global\def\x{b}}\x`, "{}b"},
+		{`\def\x{a}{{\global\def\x{b}}\x}\x`, "{{}b}b"},
+		{`\def\x{a}\begingroup\def\x{b}\endgroup\x`, "a"},
+		{`\newcommand{\x}{a}{\renewcommand{\x}{b}\x}\x`, "{b}a"},
+		{`\newenvironment{e}{}{}\begin{e}\def\x{b}\end{e}\def\x{a}\x`, "a"},
+		{`\newenvironment{e}{\def\x{b}}{\x}\begin{e}\x\end{e}`, "bb"},
+		{`\textit{\def\x{b}}\def\x{a}\x`, "\\textit{}a"},
+		{`\def\x{a}\mbox{\def\x{b}\x}\x`, "\\mbox{b}a"},
+		{`\def\x{a}\mbox{\textit{\def\x{b}}\x}\x`, "\\mbox{\\textit{}a}a"},
+		{`\def\x{a}\mbox{\gdef\x{b}}\x`, "\\mbox{}b"},
+		{`\def\x{a}\edef\y{\x}\def\x{b}\y\x`, "ab"},
+		{`\def\x{a}\def\y{\x}\def\x{b}\y`, "b"},
+		{`\def\x{a}{\xdef\y{\x}}\def\x{b}\y`, "{}a"},
+		{`\def\x{a}\let\y=\x\def\x{b}\y\x`, "ab"},
+		{`\def\x{a}\let\y\x\y`, "a"},
+		{`\let\y=x\y`, "x"},
+		{`\let\y\textit\y{a}`, "\\textit{a}"},
+		{`\def\x{a}{\let\x\textit}\x`, "{}a"},
+		{`\def\x{a}{\global\let\y\x}\y`, "{}a"},
+	}
+	for i, testCase := range testCases {
+		out := parseString(testCase.in).FormatText()
+		if out != testCase.out {
+			t.Errorf("test %d: expected %q, got %q", i, testCase.out, out)
+		}
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+
+	"github.com/seehuhn/epublatex/latex/scanner"
 )
 
 // TokenType is used to enumerate different types of token
@@ -50,6 +52,23 @@ type Token struct {
 	// For tokens of type TokenMacro, this field specifies the values
 	// of the macro arguments.  Unused for all other token types.
 	Args []*Arg
+
+	// Pos gives the location of the token in the input files.  For
+	// tokens resulting from macro expansion, this is the position
+	// of the expanded macro.
+	Pos scanner.Pos
+
+	// Raw is the input text this token was read from, including
+	// white space and comments.  For tokens resulting from macro
+	// expansion, the first token carries the text of the macro
+	// call, and all other tokens have an empty Raw field.
+	Raw string
+
+	// Expanded is set for tokens which result from macro expansion,
+	// and for tokens which follow input which produced no tokens,
+	// for example macro definitions or conditionals.  For these
+	// tokens, Raw is not equivalent to the token itself.
+	Expanded bool
 }
 
 func (tok *Token) String() string {
@@ -88,6 +107,34 @@ func verbatim(s string) *Token {
 // TokenList describes tokenized data in the argument of a macro call.
 type TokenList []*Token
 
+// Source returns the input text the tokens were read from.  For a
+// complete token stream, this reproduces the input exactly.
+func (toks TokenList) Source() string {
+	var res []string
+	for _, tok := range toks {
+		res = append(res, tok.Raw)
+	}
+	return strings.Join(res, "")
+}
+
+// HasExpansions reports whether any of the tokens, including the
+// tokens in macro arguments, has the Expanded field set.  If this is
+// not the case, .Source() gives TeX code which is equivalent to the
+// tokens.
+func (toks TokenList) HasExpansions() bool {
+	for _, tok := range toks {
+		if tok.Expanded {
+			return true
+		}
+		for _, arg := range tok.Args {
+			if arg.Value.HasExpansions() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // FormatText converts a TokenList to a string.
 func (toks TokenList) FormatText() string {
 	var res []string
@@ -140,6 +187,10 @@ func (toks TokenList) FormatMaths() string {
 	for _, tok := range toks {
 		switch tok.Type {
 		case TokenMacro:
+			if tok.Name == "%tabular%" {
+				res = append(res, formatTable(tok))
+				break
+			}
 			res = append(res, tok.Name)
 			for _, arg := range tok.Args {
 				if tok.Name == "\\mbox" {
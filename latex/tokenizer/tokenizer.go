@@ -18,9 +18,9 @@ package tokenizer
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"log"
-	"strings"
 
 	"github.com/seehuhn/epublatex/latex/scanner"
 )
@@ -29,16 +29,29 @@ import (
 // User-defined macros are expanded in the process.
 type Tokenizer struct {
 	scanner.Scanner
+	*definitions
 
-	macros       map[string]macro
-	environments map[string]environment
+	// tokPos is the position of the token currently being read.
+	// basePos is used instead of the scanner position for child
+	// tokenizers which parse macro arguments.
+	tokPos  scanner.Pos
+	basePos scanner.Pos
+
+	// condDepth is the number of currently open conditionals.
+	condDepth int
+
+	// includeOnly, if non-nil, lists the files which may be read
+	// by \include.
+	includeOnly map[string]bool
 }
 
 // NewTokenizer creates and initialises a new Tokenizer.
 func NewTokenizer() *Tokenizer {
 	p := &Tokenizer{
-		macros:       make(map[string]macro),
-		environments: make(map[string]environment),
+		definitions: &definitions{
+			macros:       make(map[string]macro),
+			environments: make(map[string]environment),
+		},
 	}
 	p.addBuiltinMacros()
 	return p
@@ -61,12 +74,14 @@ func (p *Tokenizer) ParseTex(res chan<- *Token) error {
 	var stack []collectState
 	var lookingFor isEnd
 	var collectingInto *Token
+	var pendingRaw string
 
 	for p.Next() {
 		buf, err := p.Peek()
 		if err != nil {
 			return err
 		}
+		p.tokPos = p.pos()
 
 		var nextBatch TokenList
 
@@ -77,7 +92,7 @@ func (p *Tokenizer) ParseTex(res chan<- *Token) error {
 				return err
 			}
 
-			if m := p.macros[name]; m != nil {
+			if m := p.lookupMacro(name); m != nil {
 				tokens, err := m.ReadArgs(p, name)
 				if err != nil {
 					return err
@@ -88,7 +103,8 @@ func (p *Tokenizer) ParseTex(res chan<- *Token) error {
 				if err != nil {
 					return err
 				}
-				if env := p.environments[envName]; env != nil {
+				p.beginGroup(envName)
+				if env := p.lookupEnvironment(envName); env != nil {
 					tokens, newLookingFor, err := env.ReadArgs(p, envName)
 					if err != nil {
 						return err
@@ -102,7 +118,7 @@ func (p *Tokenizer) ParseTex(res chan<- *Token) error {
 					lookingFor = newLookingFor
 					collectingInto = nil
 				} else {
-					log.Println("unknown environment", envName)
+					p.warn("unknown environment " + envName)
 					args, err := p.readAllMacroArgs()
 					if err != nil {
 						return err
@@ -117,20 +133,8 @@ func (p *Tokenizer) ParseTex(res chan<- *Token) error {
 						&Token{Type: TokenMacro, Name: name, Args: args},
 					}
 				}
-			} else if name == "\\include" {
-				fileName, err := p.readMandatoryArg()
-				if err != nil {
-					return err
-				}
-				if !strings.HasSuffix(fileName, ".tex") {
-					fileName = fileName + ".tex"
-				}
-				err = p.Include(fileName)
-				if err != nil {
-					return err
-				}
 			} else {
-				log.Println("unknown macro", name)
+				p.warn("unknown macro " + name)
 				args, err := p.readAllMacroArgs()
 				if err != nil {
 					return err
@@ -179,16 +183,47 @@ func (p *Tokenizer) ParseTex(res chan<- *Token) error {
 				name = string(buf[:1])
 				p.Skip(1)
 			}
+			switch name {
+			case "{":
+				p.beginGroup("{")
+			case "}":
+				p.endGroup("{")
+			}
 			nextBatch = TokenList{&Token{Type: TokenOther, Name: name}}
 		}
 
+		// Input which does not produce tokens, for example macro
+		// definitions or the names of expanded macros, is attached
+		// to the next token.
+		// Tokens read from the text of a macro expansion have no
+		// recorded input.
+		recorded := p.Recorded()
+		raw := pendingRaw + recorded
+		if len(nextBatch) == 0 {
+			pendingRaw = raw
+			continue
+		}
+		expanded := pendingRaw != "" || recorded == ""
+		nextBatch[0].Raw = raw
+		pendingRaw = ""
+
 		for _, tok := range nextBatch {
+			if tok.Pos.Name == "" {
+				tok.Pos = p.tokPos
+			}
+			if expanded {
+				tok.Expanded = true
+			}
 			if lookingFor != nil {
 				if collectingInto == nil {
 					tok.Args = append(tok.Args, &Arg{})
 					collectingInto = tok
 					continue
 				}
+				collectingInto.Raw += tok.Raw
+				if tok.Expanded {
+					collectingInto.Expanded = true
+				}
 				if !lookingFor(tok) {
 					k := len(collectingInto.Args) - 1
 					collectingInto.Args[k].Value = append(collectingInto.Args[k].Value, tok)
@@ -205,8 +240,15 @@ func (p *Tokenizer) ParseTex(res chan<- *Token) error {
 		}
 	}
 
+	if pendingRaw != "" {
+		// keep trailing definitions, so that the input can be
+		// reconstructed from the tokens
+		res <- &Token{Type: TokenComment, Pos: p.tokPos, Raw: pendingRaw}
+	}
+
 	if lookingFor != nil {
-		return MissingEndError(collectingInto.Name)
+		return fmt.Errorf("%s: %w", collectingInto.Pos,
+			MissingEndError(collectingInto.Name))
 	}
 
 	return nil
@@ -235,7 +277,7 @@ func (p *Tokenizer) skipWhiteSpace() (bool, error) {
 
 	emptyLine := false
 	if nlSeen > 1 {
-		p.Prepend([]byte("\n\n"), "<end of paragraph>")
+		p.expand([]byte("\n\n"), "<end of paragraph>")
 		emptyLine = true
 	}
 	return emptyLine, nil
@@ -457,16 +499,51 @@ func (p *Tokenizer) readUnit() (string, error) {
 	return word, nil
 }
 
-func parseString(text string) TokenList {
+// parseArg splits the text of a macro argument into tokens.  The
+// macros and environments currently defined in p are used for this.
+// The argument forms a group, so that local definitions inside the
+// argument do not affect the rest of the input.
+func (p *Tokenizer) parseArg(text string) (TokenList, error) {
+	child := &Tokenizer{
+		definitions: p.definitions,
+		basePos:     p.tokPos,
+	}
+	depth := len(p.scopes)
+	p.beginGroup("{")
+	res, err := child.tokenizeString(text)
+	if len(p.scopes) > depth {
+		p.scopes = p.scopes[:depth]
+	}
+	return res, err
+}
+
+// pos returns the current input position.
+func (p *Tokenizer) pos() scanner.Pos {
+	pos := p.Pos()
+	if pos.Name == "" {
+		pos = p.basePos
+	}
+	return pos
+}
+
+// expand inserts the result of a macro expansion into the input.
+// Tokens read from this text are reported at the position of the
+// expanded macro.
+func (p *Tokenizer) expand(data []byte, name string) {
+	p.PrependAt(data, name, p.tokPos)
+}
+
+// warn logs a warning about the token currently being read.
+func (p *Tokenizer) warn(msg string) {
+	log.Println(p.tokPos.String() + ": " + msg)
+}
+
+func (p *Tokenizer) tokenizeString(text string) (TokenList, error) {
 	c := make(chan *Token, 64)
+	errChan := make(chan error, 1)
 	go func() {
-		p := NewTokenizer()
 		p.Prepend([]byte(text), "text")
-		err := p.ParseTex(c)
-		if err != nil {
-			// Should not happen, since the parser input is not file based.
-			panic(err)
-		}
+		errChan <- p.ParseTex(c)
 		close(c)
 	}()
 
@@ -474,5 +551,14 @@ func parseString(text string) TokenList {
 	for tok := range c {
 		res = append(res, tok)
 	}
+	return res, <-errChan
+}
+
+func parseString(text string) TokenList {
+	res, err := NewTokenizer().tokenizeString(text)
+	if err != nil {
+		// Should not happen, since the parser input is not file based.
+		panic(err)
+	}
 	return res
 }
@@ -16,6 +16,18 @@
 
 package latex
 
+import (
+	"fmt"
+	"log"
+
+	"github.com/seehuhn/epublatex/latex/scanner"
+)
+
+// warn logs a warning about the input at position `pos`.
+func warn(pos scanner.Pos, format string, args ...interface{}) {
+	log.Print(pos.String() + ": " + fmt.Sprintf(format, args...))
+}
+
 func firstOf(errors ...error) error {
 	for _, err := range errors {
 		if err != nil {
@@ -41,9 +41,16 @@ type writer struct {
 	state *State
 	stack []*State
 
+	lists []*listState
+
 	nextParTag string
 }
 
+type listState struct {
+	tag      string
+	itemOpen bool
+}
+
 func newWriter(out *epub.Book, baseDir string) *writer {
 	return &writer{
 		out:     out,
@@ -71,6 +78,14 @@ func (w *writer) WriteTitle(title, author string) error {
 	return firstOf(e1, e2)
 }
 
+// EndChapter closes the current chapter, so that footnotes in the
+// title of the next chapter are added to the new chapter.
+func (w *writer) EndChapter() error {
+	e1 := w.EndParagraph()
+	e2 := w.out.EndChapter()
+	return firstOf(e1, e2)
+}
+
 func (w *writer) AddSection(level int, title string, id string) error {
 	e1 := w.EndParagraph()
 	e2 := w.out.AddSection(level, title, id)
@@ -83,6 +98,13 @@ func (w *writer) WriteVertical(body string) error {
 	return firstOf(e1, e2)
 }
 
+// PageBreak makes the following text start on a new page.
+func (w *writer) PageBreak() error {
+	e1 := w.EndParagraph()
+	e2 := w.out.PageBreak()
+	return firstOf(e1, e2)
+}
+
 func (w *writer) StartBlock(name string, classes []string, id string) error {
 	w.EndParagraph()
 
@@ -106,6 +128,101 @@ func (w *writer) EndBlock() error {
 	return w.out.WriteString("</div>\n")
 }
 
+// StartFigure opens a HTML figure element, used for LaTeX floats.
+func (w *writer) StartFigure(id, class string) error {
+	e1 := w.EndParagraph()
+	e2 := w.out.WriteString(`<figure id="` + id + `" class="` + class + "\">\n")
+	return firstOf(e1, e2)
+}
+
+// WriteCaption writes the caption of the current figure.  The
+// caption must be valid HTML.
+func (w *writer) WriteCaption(caption string) error {
+	e1 := w.EndParagraph()
+	e2 := w.out.WriteString("<figcaption>" + caption + "</figcaption>\n")
+	return firstOf(e1, e2)
+}
+
+// EndFigure closes the current figure.
+func (w *writer) EndFigure() error {
+	e1 := w.EndParagraph()
+	e2 := w.out.WriteString("</figure>\n")
+	return firstOf(e1, e2)
+}
+
+// AddList adds a page with a list of figures or tables to the book.
+func (w *writer) AddList(title, listType string, entries []epub.ListEntry) error {
+	e1 := w.EndParagraph()
+	e2 := w.out.AddList(title, listType, entries)
+	return firstOf(e1, e2)
+}
+
+// StartList opens a HTML list.  The argument `tag` must be one of
+// "ul", "ol" and "dl".
+func (w *writer) StartList(tag, class string) error {
+	e1 := w.EndParagraph()
+	w.lists = append(w.lists, &listState{tag: tag})
+	e2 := w.out.WriteString("<" + tag + ` class="` + class + "\">\n")
+	return firstOf(e1, e2)
+}
+
+// StartItem starts a new item in the innermost open list.  The label,
+// if non-empty, must be valid HTML.
+func (w *writer) StartItem(label, id string) error {
+	e1 := w.EndParagraph()
+	n := len(w.lists)
+	if n == 0 {
+		return e1
+	}
+	list := w.lists[n-1]
+
+	var parts []string
+	if list.itemOpen {
+		parts = append(parts, list.itemEndTag())
+	}
+	if id != "" {
+		id = ` id="` + id + `"`
+	}
+	if list.tag == "dl" {
+		parts = append(parts, "<dt"+id+">"+label+"</dt>\n<dd>\n")
+	} else {
+		parts = append(parts, "<li"+id+">\n")
+	}
+	list.itemOpen = true
+	e2 := w.out.WriteString(strings.Join(parts, ""))
+
+	if list.tag != "dl" && label != "" {
+		w.WriteString(`<span class="` + cssPrefix + `label">` + label + "</span>")
+		e2 = firstOf(e2, w.EndWord())
+	}
+	return firstOf(e1, e2)
+}
+
+// EndList closes the innermost open list.
+func (w *writer) EndList() error {
+	e1 := w.EndParagraph()
+	n := len(w.lists)
+	if n == 0 {
+		return e1
+	}
+	list := w.lists[n-1]
+	w.lists = w.lists[:n-1]
+
+	var end string
+	if list.itemOpen {
+		end = list.itemEndTag()
+	}
+	e2 := w.out.WriteString(end + "</" + list.tag + ">\n")
+	return firstOf(e1, e2)
+}
+
+func (list *listState) itemEndTag() string {
+	if list.tag == "dl" {
+		return "</dd>\n"
+	}
+	return "</li>\n"
+}
+
 func (w *writer) suspendParagraph(contTag string) error {
 	w.nextParTag = ""
 	e1 := w.endWord(true)
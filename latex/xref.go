@@ -21,6 +21,7 @@ import "strconv"
 type xRef struct {
 	Label   string
 	Chapter int
+	Part    int
 	ID      string
 	Pos     int
 	Type    string
@@ -70,3 +71,23 @@ func (conv *converter) xRefLookup(pos int) string {
 	}
 	return ""
 }
+
+// posID returns the HTML id for the element generated from the token
+// at position `pos`.  If the element has a \label, the id is derived
+// from the label.
+func (conv *converter) posID(pos int) string {
+	id := conv.xRefLookup(pos)
+	if id == "" {
+		id = "pos-" + strconv.Itoa(pos)
+	}
+	return id
+}
+
+// currentChapter returns the number of the current chapter, or 0 for
+// the front matter.
+func (conv *converter) currentChapter() int {
+	if len(conv.Section) == 0 {
+		return 0
+	}
+	return conv.Section[0]
+}
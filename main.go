@@ -17,14 +17,18 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
 
 	"github.com/seehuhn/epublatex/epub"
 	"github.com/seehuhn/epublatex/latex"
+	"github.com/seehuhn/epublatex/latex/render"
 )
 
 var output = flag.String("output", "", "the output file name")
@@ -34,8 +38,16 @@ func main() {
 	log.Println("start")
 	flag.Parse()
 
+	if flag.NArg() > 0 && flag.Arg(0) == "cache" {
+		err := cacheCommand(flag.Args()[1:])
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	if flag.NArg() != 1 {
-		log.Fatal("usage: main <input.tex>")
+		log.Fatal("usage: main <input.tex>\n       main cache <command>")
 	}
 	inputName := flag.Arg(0)
 
@@ -66,14 +78,25 @@ func main() {
 			log.Fatal(err)
 		}
 	}
-	defer func() {
-		err := book.Close()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
 
-	err = latex.Convert(book, inputName)
+	// stop rendering images on Ctrl-C
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	err = latex.Convert(ctx, book, inputName)
+	if errors.Is(err, context.Canceled) && !*html {
+		// don't leave a truncated EPUB file behind
+		os.Remove(outputName)
+	}
+	var failed render.Errors
+	if err != nil && !errors.As(err, &failed) {
+		log.Fatal(err)
+	}
+	// The book is complete even if some images could not be rendered.
+	e2 := book.Close()
+	if e2 != nil {
+		log.Fatal(e2)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}